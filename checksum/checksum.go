@@ -0,0 +1,200 @@
+// Package checksum computes checksums of files, so that files which exist at
+// both sites can be compared by content rather than just by name and size.
+// Everything is streamed through the hash, so large files are never loaded
+// into memory all at once.
+package checksum
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// New returns a new hash.Hash for the named algorithm - either "md5" or "sha256".
+func New(algo string) (hash.Hash, error) {
+
+	switch strings.ToLower(algo) {
+	case "md5":
+		return md5.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: <%s> (use md5 or sha256)", algo)
+	}
+
+}
+
+// Reader streams everything from r through the named hash algorithm, and returns
+// the checksum as a hex string.
+func Reader(r io.Reader, algo string) (string, error) {
+
+	h, err := New(algo)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+
+}
+
+// File returns the checksum of the file at path as a hex string.
+func File(path, algo string) (string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return Reader(f, algo)
+
+}
+
+// FromHeader looks for a checksum of the given algorithm in a set of HTTP
+// response headers, and returns it as a hex string. Servers advertise these a
+// few different ways, so we check, in order:
+//
+//	Digest: SHA-256=<base64>, MD5=<base64>    (RFC 3230)
+//	Content-MD5: <base64>                     (md5 only)
+//	X-Checksum-Sha256: <hex>                  (Artifactory, and others)
+//	X-Checksum-Md5: <hex>
+//
+// If none of them have what we're looking for, ok is false.
+func FromHeader(header http.Header, algo string) (sum string, ok bool) {
+
+	algo = strings.ToLower(algo)
+	digestName := map[string]string{"md5": "md5", "sha256": "sha-256"}[algo]
+	if digestName == "" {
+		return "", false
+	}
+
+	for _, digest := range header.Values("Digest") {
+		for _, part := range strings.Split(digest, ",") {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == digestName {
+				if sum, ok := base64ToHex(kv[1]); ok {
+					return sum, true
+				}
+			}
+		}
+	}
+
+	if algo == "md5" {
+		if sum, ok := base64ToHex(header.Get("Content-MD5")); ok {
+			return sum, true
+		}
+	}
+
+	if sum := strings.TrimSpace(header.Get("X-Checksum-" + algo)); sum != "" {
+		if _, err := hex.DecodeString(sum); err == nil {
+			return strings.ToLower(sum), true
+		}
+	}
+
+	return "", false
+
+}
+
+// ParseManifest reads a checksum manifest, like the SHA256SUMS files that
+// mirrors publish, and returns the checksums it lists, keyed by path. format says
+// how the lines are laid out - "gnu" is what sha256sum and friends write:
+//
+//	<hex>  path/to/file      (or <hex> *path/to/file, in binary mode)
+//
+// and "bsd" is what BSD's sha256 (or sha256sum --tag) writes:
+//
+//	SHA256 (path/to/file) = <hex>
+//
+// Paths are cleaned, so "./file" is listed as "file". Blank lines and "#"
+// comments are skipped, but anything else that isn't in the right format is an
+// error.
+func ParseManifest(r io.Reader, format string) (map[string]string, error) {
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var sum, name string
+		switch strings.ToLower(format) {
+		case "gnu":
+			fields := strings.SplitN(text, " ", 2)
+			if len(fields) == 2 {
+				sum, name = fields[0], strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*")
+			}
+		case "bsd":
+			open, eq := strings.Index(text, " ("), strings.LastIndex(text, ") = ")
+			if open > 0 && eq > open {
+				sum, name = strings.TrimSpace(text[eq+4:]), text[open+2:eq]
+			}
+		default:
+			return nil, fmt.Errorf("unsupported manifest format: <%s> (use gnu or bsd)", format)
+		}
+
+		if _, err := hex.DecodeString(sum); err != nil || sum == "" || name == "" {
+			return nil, fmt.Errorf("line %d isn't a %s style checksum: <%s>", line, format, text)
+		}
+
+		sums[path.Clean(name)] = strings.ToLower(sum)
+
+	}
+
+	return sums, scanner.Err()
+
+}
+
+// ParseSidecar reads a checksum file published alongside a single file, like
+// file.iso.sha256. These usually hold a line in the "gnu" manifest format, but
+// some have nothing but the checksum itself, so only the first word is used.
+func ParseSidecar(r io.Reader) (string, error) {
+
+	data, err := io.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file is empty")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("checksum file doesn't start with a checksum: <%s>", fields[0])
+	}
+
+	return strings.ToLower(fields[0]), nil
+
+}
+
+func base64ToHex(s string) (string, bool) {
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", false
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", false
+	}
+
+	return hex.EncodeToString(raw), true
+
+}
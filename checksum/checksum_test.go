@@ -0,0 +1,125 @@
+package checksum
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	helloMD5    = "5d41402abc4b2a76b9719d911017c592"
+	helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+)
+
+func TestReader(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		algo        string
+		expected    string
+		expectError bool
+	}{
+		{"md5", helloMD5, false},
+		{"sha256", helloSHA256, false},
+		{"SHA256", helloSHA256, false},
+		{"crc32", "", true},
+	}
+	for _, test := range tests {
+		sum, err := Reader(strings.NewReader("hello"), test.algo)
+		if test.expectError {
+			assert.NotNil(err)
+		} else {
+			assert.Nil(err)
+			assert.Equal(test.expected, sum)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpfile, err := ioutil.TempFile("", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString("hello")
+	tmpfile.Close()
+
+	sum, err := File(tmpfile.Name(), "sha256")
+	assert.Nil(err)
+	assert.Equal(helloSHA256, sum)
+
+	_, err = File(tmpfile.Name()+".missing", "sha256")
+	assert.NotNil(err)
+}
+
+func TestFromHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		header   http.Header
+		algo     string
+		expected string
+		ok       bool
+	}{
+		{http.Header{"Digest": {"SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="}}, "sha256", helloSHA256, true},
+		{http.Header{"Digest": {"md5=XUFAKrxLKna5cZ2REBfFkg==, SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="}}, "md5", helloMD5, true},
+		{http.Header{"Content-Md5": {"XUFAKrxLKna5cZ2REBfFkg=="}}, "md5", helloMD5, true},
+		{http.Header{"Content-Md5": {"XUFAKrxLKna5cZ2REBfFkg=="}}, "sha256", "", false},
+		{http.Header{"X-Checksum-Sha256": {strings.ToUpper(helloSHA256)}}, "sha256", helloSHA256, true},
+		{http.Header{"X-Checksum-Md5": {helloMD5}}, "md5", helloMD5, true},
+		{http.Header{"X-Checksum-Md5": {"not hex"}}, "md5", "", false},
+		{http.Header{}, "sha256", "", false},
+	}
+	for _, test := range tests {
+		sum, ok := FromHeader(test.header, test.algo)
+		assert.Equal(test.ok, ok, test.header)
+		assert.Equal(test.expected, sum, test.header)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	gnu := "# made by sha256sum\n" +
+		helloSHA256 + "  hello.txt\n" +
+		"\n" +
+		strings.ToUpper(helloMD5) + " *./dir/file with spaces.bin\n"
+	sums, err := ParseManifest(strings.NewReader(gnu), "gnu")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"hello.txt": helloSHA256, "dir/file with spaces.bin": helloMD5}, sums)
+
+	bsd := "SHA256 (hello.txt) = " + helloSHA256 + "\nSHA256 (dir/a (1).txt) = " + helloSHA256 + "\n"
+	sums, err = ParseManifest(strings.NewReader(bsd), "BSD")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"hello.txt": helloSHA256, "dir/a (1).txt": helloSHA256}, sums)
+
+	_, err = ParseManifest(strings.NewReader(bsd), "gnu")
+	assert.NotNil(err)
+	_, err = ParseManifest(strings.NewReader(gnu), "bsd")
+	assert.NotNil(err)
+	_, err = ParseManifest(strings.NewReader(gnu), "xml")
+	assert.NotNil(err)
+}
+
+func TestParseSidecar(t *testing.T) {
+	assert := assert.New(t)
+
+	sum, err := ParseSidecar(strings.NewReader(helloSHA256 + "  hello.txt\n"))
+	assert.Nil(err)
+	assert.Equal(helloSHA256, sum)
+
+	sum, err = ParseSidecar(strings.NewReader(strings.ToUpper(helloMD5) + "\n"))
+	assert.Nil(err)
+	assert.Equal(helloMD5, sum)
+
+	_, err = ParseSidecar(strings.NewReader(""))
+	assert.NotNil(err)
+	_, err = ParseSidecar(strings.NewReader("<html>Not Found</html>"))
+	assert.NotNil(err)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// completionScript writes a script that gives the named shell (bash, zsh or
+// fish) tab completion for every flag in flags, for --completion. It's built
+// from the flags as they're registered, so it never falls behind them. Where a
+// flag takes a value, the shell is left to complete a file name, which is what
+// most of them want.
+func completionScript(shell string, flags *flag.FlagSet) (string, error) {
+
+	var b strings.Builder
+
+	switch shell {
+	case "bash":
+		var words []string
+		flags.VisitAll(func(f *flag.Flag) {
+			if f.Hidden {
+				return
+			}
+			words = append(words, "--"+f.Name)
+			if f.Shorthand != "" {
+				words = append(words, "-"+f.Shorthand)
+			}
+		})
+		fmt.Fprintf(&b, "_sitescan() {\n")
+		fmt.Fprintf(&b, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+		fmt.Fprintf(&b, "    if [[ \"$cur\" == -* ]]; then\n")
+		fmt.Fprintf(&b, "        COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(words, " "))
+		fmt.Fprintf(&b, "    fi\n")
+		fmt.Fprintf(&b, "}\n")
+		fmt.Fprintf(&b, "complete -o default -F _sitescan sitescan\n")
+
+	case "zsh":
+		fmt.Fprintf(&b, "#compdef sitescan\n\n")
+		fmt.Fprintf(&b, "_arguments -s")
+		flags.VisitAll(func(f *flag.Flag) {
+			if f.Hidden {
+				return
+			}
+			desc := "[" + zshEscape(f.Usage) + "]"
+			if takesValue(f) {
+				desc += ":" + f.Name + ":_files"
+			}
+			if f.Shorthand != "" {
+				fmt.Fprintf(&b, " \\\n    '(-%s --%s)'{-%s,--%s}'%s'", f.Shorthand, f.Name, f.Shorthand, f.Name, desc)
+			} else {
+				fmt.Fprintf(&b, " \\\n    '--%s%s'", f.Name, desc)
+			}
+		})
+		fmt.Fprintf(&b, "\n")
+
+	case "fish":
+		flags.VisitAll(func(f *flag.Flag) {
+			if f.Hidden {
+				return
+			}
+			fmt.Fprintf(&b, "complete -c sitescan -l %s", f.Name)
+			if f.Shorthand != "" {
+				fmt.Fprintf(&b, " -s %s", f.Shorthand)
+			}
+			if takesValue(f) {
+				fmt.Fprintf(&b, " -r")
+			}
+			fmt.Fprintf(&b, " -d '%s'\n", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(f.Usage))
+		})
+
+	default:
+		return "", fmt.Errorf("no completion for <%s> - it can be bash, zsh or fish", shell)
+	}
+
+	return b.String(), nil
+
+}
+
+// takesValue reports whether a flag needs a value after it. Only the bools can
+// stand on their own.
+func takesValue(f *flag.Flag) bool {
+	return f.NoOptDefVal == ""
+}
+
+// zshEscape makes a flag's usage safe to put inside the brackets of an
+// _arguments spec, itself inside single quotes.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
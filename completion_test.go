@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionScript(t *testing.T) {
+
+	flags := flag.NewFlagSet("sitescan", flag.ContinueOnError)
+	flags.BoolP("debug", "d", false, "output debugging info")
+	flags.String("state", "", "save what was found: it's [kept] for next time")
+	flags.Bool("secret", false, "not for everyone")
+	flags.MarkHidden("secret")
+
+	script, err := completionScript("bash", flags)
+	assert.Nil(t, err)
+	assert.Contains(t, script, `compgen -W "--debug -d --state" -- "$cur"`)
+	assert.Contains(t, script, "complete -o default -F _sitescan sitescan")
+
+	script, err = completionScript("zsh", flags)
+	assert.Nil(t, err)
+	assert.Contains(t, script, `'(-d --debug)'{-d,--debug}'[output debugging info]'`)
+	assert.Contains(t, script, `'--state[save what was found\: it'\''s \[kept\] for next time]:state:_files'`)
+	assert.NotContains(t, script, "secret")
+
+	script, err = completionScript("fish", flags)
+	assert.Nil(t, err)
+	assert.Contains(t, script, "complete -c sitescan -l debug -s d -d 'output debugging info'\n")
+	assert.Contains(t, script, `complete -c sitescan -l state -r -d 'save what was found: it\'s [kept] for next time'`)
+	assert.NotContains(t, script, "secret")
+
+	_, err = completionScript("powershell", flags)
+	assert.NotNil(t, err)
+
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// configInitName is the file --init writes, in the current directory - the
+// first place sitescan looks for its config.
+const configInitName = "sitescan_config.yaml"
+
+// configInitSkip are the flags that don't belong in a config file: they say
+// which config file to read, or make sitescan do something else and exit.
+var configInitSkip = map[string]bool{
+	"config":       true,
+	"help":         true,
+	"version":      true,
+	"print-config": true,
+	"completion":   true,
+	"init":         true,
+}
+
+// configInitSiteKeys are the settings configInitSites already has, so they're
+// not written again should any of them be registered as flags.
+var configInitSiteKeys = map[string]bool{
+	"site1": true, "site1name": true, "site1user": true, "site1pass": true,
+	"site2": true, "site2name": true, "site2user": true, "site2pass": true,
+	"sites": true, "ignore": true, "ignore-replace": true,
+}
+
+// configInitSites is the top of the file --init writes, the same as the
+// example in the docs, with the logins commented out.
+const configInitSites = `# sitescan_config.yaml, written by sitescan --init. Anything that's commented
+# out keeps the default shown - uncomment it to change it. Command line options
+# and SITESCAN_ environment variables override what's here.
+
+# the two sites to compare
+site1: http://webserver.myhost.com/path/to/examine
+site2: http://www.anotherhost.org:8080/
+site1name: MyHost.com site
+site2name: AnotherHost site
+# site1user: someguy
+# site1pass: spaceballs12345
+# site2user:
+# site2pass:
+
+# or, to compare more than two sites, list them all instead of site1/site2:
+# sites:
+#   - url: http://webserver.myhost.com/path/to/examine
+#     name: MyHost.com site
+#     user: someguy
+#     pass: spaceballs12345
+#   - url: http://www.anotherhost.org:8080/
+#     name: AnotherHost site
+#   - url: /srv/local/copy
+#     name: Local copy
+
+# link texts to ignore, as well as the defaults (or instead of them, with
+# ignore-replace)
+# ignore:
+#   - "Parent directory/"
+# ignore-replace: false
+`
+
+// configTemplate is the starter config --init writes: the sites, as in the
+// docs' example, and then every other setting in flags, commented out, with its
+// default and what it does. It's built from the flags as they're registered, so
+// it never falls behind them.
+func configTemplate(flags *flag.FlagSet) string {
+
+	var b strings.Builder
+	b.WriteString(configInitSites)
+	b.WriteString("\n# everything else\n")
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Hidden || configInitSkip[f.Name] || configInitSiteKeys[f.Name] {
+			return
+		}
+		b.WriteString("\n")
+		for _, line := range wrapText(f.Usage, 76) {
+			fmt.Fprintf(&b, "# %s\n", line)
+		}
+		fmt.Fprintf(&b, "# %s: %s\n", f.Name, configValue(f))
+	})
+
+	return b.String()
+
+}
+
+// configValue is a flag's default, written so YAML reads it back the way the
+// flag would. Strings are always quoted, so one like "0644" isn't taken for a
+// number.
+func configValue(f *flag.Flag) string {
+
+	switch f.Value.Type() {
+	case "string":
+		return strconv.Quote(f.DefValue)
+	case "stringArray", "stringSlice":
+		return "[]"
+	default:
+		return f.DefValue
+	}
+
+}
+
+// writeConfigInit writes the configTemplate for flags to path, for --init. It
+// won't replace a file that's already there, unless overwrite is set.
+func writeConfigInit(path string, flags *flag.FlagSet, overwrite bool) error {
+
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return fmt.Errorf("%s already exists - use --force to replace it", path)
+	}
+
+	return writeFileAtomic(path, []byte(configTemplate(flags)), 0600)
+
+}
+
+// wrapText breaks s into lines of no more than width characters, at spaces.
+// A word longer than width gets a line of its own.
+func wrapText(s string, width int) []string {
+
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigTemplate(t *testing.T) {
+
+	flags := flag.NewFlagSet("sitescan", flag.ContinueOnError)
+	flags.StringP("config", "c", "", "path to alternate configuration file")
+	flags.String("site1", "", "Site 1 URL")
+	flags.Bool("download", false, "automatically download files that exist on Site 2 that are missing for Site 1")
+	flags.String("file-mode", "0644", "permissions for downloaded files, in octal")
+	flags.Int("retries", 2, "how many times to retry")
+	flags.Duration("heartbeat", time.Minute, "how often to log a line")
+	flags.StringArray("include", nil, "only compare files whose path matches this glob pattern")
+	flags.StringArray("ignore", nil, "link texts to ignore")
+	flags.Bool("secret", false, "not for everyone")
+	flags.MarkHidden("secret")
+
+	tmpl := configTemplate(flags)
+	assert.True(t, strings.HasPrefix(tmpl, configInitSites))
+	assert.Contains(t, tmpl, "\n# automatically download files that exist on Site 2 that are missing for Site\n# 1\n# download: false\n")
+	assert.NotContains(t, tmpl, "# config:")
+	assert.NotContains(t, tmpl, "# site1:")
+	assert.NotContains(t, tmpl, "secret")
+	assert.Equal(t, 1, strings.Count(tmpl, "# ignore:"))
+
+	// as it is, it's the example sites and nothing more
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.Nil(t, v.ReadConfig(strings.NewReader(tmpl)))
+	assert.Equal(t, "http://webserver.myhost.com/path/to/examine", v.GetString("site1"))
+	assert.False(t, v.IsSet("download"))
+
+	// and with every setting uncommented, each one reads back as its default
+	uncommented := regexp.MustCompile(`(?m)^# ([a-z0-9-]+: )`).ReplaceAllString(tmpl[len(configInitSites):], "$1")
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.Nil(t, v.ReadConfig(strings.NewReader(configInitSites+uncommented)))
+	assert.Equal(t, false, v.GetBool("download"))
+	assert.Equal(t, "0644", v.GetString("file-mode"))
+	assert.Equal(t, 2, v.GetInt("retries"))
+	assert.Equal(t, time.Minute, v.GetDuration("heartbeat"))
+	assert.Empty(t, v.GetStringSlice("include"))
+	for _, key := range []string{"download", "file-mode", "retries", "heartbeat", "include"} {
+		assert.True(t, v.IsSet(key), key)
+	}
+
+}
+
+func TestWriteConfigInit(t *testing.T) {
+
+	flags := flag.NewFlagSet("sitescan", flag.ContinueOnError)
+	flags.Bool("download", false, "download what's missing")
+
+	path := filepath.Join(t.TempDir(), configInitName)
+	assert.Nil(t, writeConfigInit(path, flags, false))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, configTemplate(flags), string(data))
+
+	// an existing config is left alone, unless it's forced
+	assert.Nil(t, os.WriteFile(path, []byte("site1: /srv/mine\n"), 0600))
+	err = writeConfigInit(path, flags, false)
+	assert.ErrorContains(t, err, "already exists - use --force")
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "site1: /srv/mine\n", string(data))
+
+	assert.Nil(t, writeConfigInit(path, flags, true))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, configTemplate(flags), string(data))
+
+}
+
+func TestWrapText(t *testing.T) {
+
+	assert.Equal(t, []string{"one two", "three"}, wrapText("one two three", 8))
+	assert.Equal(t, []string{"a", "enormousword", "b"}, wrapText("a enormousword b", 5))
+	assert.Nil(t, wrapText("", 10))
+
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// siteCredentials is one entry in the "credentials" list of a
+// --credentials-file, with the login for the site in the sites list that has
+// the same name.
+type siteCredentials struct {
+	Name  string `mapstructure:"name"`
+	User  string `mapstructure:"user"`
+	Pass  string `mapstructure:"pass"`
+	Token string `mapstructure:"token"`
+}
+
+// loadCredentials reads the --credentials-file at path, and merges it into v,
+// over the top of the config file (flags and environment variables still win).
+// It's a config file like any other - so it can have site1pass, site2token and
+// the rest - but it's kept apart, so the config file can go somewhere public,
+// like a git repository, and the secrets can't. It warns if anyone but its
+// owner can read it.
+func loadCredentials(v *viper.Viper, path string) error {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		slog.Warn("other users can read the credentials file - try chmod 600", "path", path)
+	}
+
+	cv := viper.New()
+	cv.SetConfigFile(path)
+	if configFileName(path) != path {
+		// no extension to go by, so YAML, like the config file
+		cv.SetConfigType("yaml")
+	}
+	if err := cv.ReadInConfig(); err != nil {
+		return err
+	}
+
+	return v.MergeConfigMap(cv.AllSettings())
+
+}
+
+// applyCredentials fills in the logins from the credentials list for the sites
+// in a sites list, matching them up by name (ignoring case). A site without a
+// name is matched by the one it gets by default, like "Site 1". Anything in the
+// credentials list takes the place of what's in the sites list.
+func applyCredentials(v *viper.Viper, configs []siteConfig) error {
+
+	var creds []siteCredentials
+	if err := v.UnmarshalKey("credentials", &creds); err != nil {
+		return err
+	}
+
+	for i := range configs {
+		name := strings.Trim(configs[i].Name, "\"")
+		if name == "" {
+			name = fmt.Sprintf("Site %d", i+1)
+		}
+		for _, c := range creds {
+			if !strings.EqualFold(c.Name, name) {
+				continue
+			}
+			if c.User != "" {
+				configs[i].User = c.User
+			}
+			if c.Pass != "" {
+				configs[i].Pass = c.Pass
+			}
+			if c.Token != "" {
+				configs[i].Token = c.Token
+			}
+		}
+	}
+
+	return nil
+
+}
+
+// warnExposedSecrets warns if the config file at path has passwords or tokens
+// in it, and everyone can read it.
+func warnExposedSecrets(path string) {
+
+	info, err := os.Stat(path)
+	if err != nil || runtime.GOOS == "windows" || info.Mode().Perm()&0004 == 0 {
+		return
+	}
+
+	// just the file, without the flags, environment and defaults
+	fv := viper.New()
+	fv.SetConfigFile(path)
+	if err := fv.ReadInConfig(); err != nil {
+		return
+	}
+
+	flat := make(map[string]interface{})
+	flattenSettings("", fv.AllSettings(), flat)
+	for name, value := range flat {
+		if secretSetting(name) && fmt.Sprint(value) != "" {
+			slog.Warn("the config file has passwords or tokens in it, and everyone can read it - consider moving them to a --credentials-file", "path", filepath.Clean(path))
+			return
+		}
+	}
+
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCredentials(t *testing.T) {
+
+	dir := t.TempDir()
+
+	config := filepath.Join(dir, "sitescan_config.yaml")
+	assert.Nil(t, os.WriteFile(config, []byte("site1: http://mirror1.example.com/\nsite1user: someguy\nsite1pass: changeme\n"), 0644))
+	creds := filepath.Join(dir, "secrets")
+	assert.Nil(t, os.WriteFile(creds, []byte("site1pass: spaceballs12345\nsite2token: abc123\n"), 0600))
+
+	v := viper.New()
+	v.SetConfigFile(config)
+	assert.Nil(t, v.ReadInConfig())
+	assert.Nil(t, loadCredentials(v, creds))
+
+	assert.Equal(t, "http://mirror1.example.com/", v.GetString("site1"))
+	assert.Equal(t, "someguy", v.GetString("site1user"))
+	assert.Equal(t, "spaceballs12345", v.GetString("site1pass"))
+	assert.Equal(t, "abc123", v.GetString("site2token"))
+
+	// a flag still beats it
+	v.Set("site1pass", "fromflag")
+	assert.Nil(t, loadCredentials(v, creds))
+	assert.Equal(t, "fromflag", v.GetString("site1pass"))
+
+	assert.NotNil(t, loadCredentials(v, filepath.Join(dir, "missing.yaml")))
+
+}
+
+func TestApplyCredentials(t *testing.T) {
+
+	v := viper.New()
+	v.Set("credentials", []interface{}{
+		map[string]interface{}{"name": "mirror 1", "user": "someguy", "pass": "spaceballs12345"},
+		map[string]interface{}{"name": "Site 2", "token": "abc123"},
+	})
+
+	configs := []siteConfig{
+		{URL: "http://mirror1.example.com/", Name: "Mirror 1", User: "nobody"},
+		{URL: "http://mirror2.example.com/"},
+		{URL: "http://mirror3.example.com/", Name: "Mirror 3", Pass: "unchanged"},
+	}
+	assert.Nil(t, applyCredentials(v, configs))
+
+	assert.Equal(t, "someguy", configs[0].User)
+	assert.Equal(t, "spaceballs12345", configs[0].Pass)
+	assert.Equal(t, "abc123", configs[1].Token)
+	assert.Equal(t, "unchanged", configs[2].Pass)
+
+}
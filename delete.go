@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/davexre/sitescan/scanner"
+)
+
+// confirmInput is where confirm reads its answer from. It's swapped out by the
+// tests.
+var confirmInput io.Reader = os.Stdin
+
+// confirm asks the user a yes or no question, and reports whether they said
+// yes. Anything but "y" or "yes" (including no answer at all, when there's
+// nobody there to give one) is a no.
+func confirm(question string) bool {
+
+	fmt.Fprintf(statusOut, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(confirmInput).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+
+}
+
+// deleteLocal deletes everything in filelist (the files that are only at Site
+// 1) from localpath, for --delete, and returns how many files and directories
+// went. Directories are only deleted with --delete-dirs, and only once they're
+// empty - the deepest first, so a tree that's gone from Site 2 goes in one run.
+// Partial downloads are left for the next run to resume, and nothing outside
+// localpath is ever touched. With --dryrun, it just says what it would do.
+func deleteLocal(localpath string, filelist []string) (files, dirs int) {
+
+	var dirlist []string
+
+	for _, file := range filelist {
+
+		target, ok := scanner.LocalTarget(localpath, file)
+		switch {
+		case !ok:
+			slog.Warn("not deleting - it's outside the local directory", "file", file, "dir", localpath)
+			continue
+		case strings.HasSuffix(file, dlSuffix):
+			continue
+		case strings.HasSuffix(file, "/"):
+			if deleteDirs {
+				dirlist = append(dirlist, file)
+			}
+			continue
+		}
+
+		if dryrun {
+			dlLog.Printf("would delete: %s", file)
+			files++
+			continue
+		}
+
+		if err := os.Remove(target); err != nil {
+			dlLog.Printf("error deleting: %s: %v", file, err)
+			dlProgress.Errors.Add(target, err)
+			continue
+		}
+		dlLog.Printf("deleted: %s", file)
+		files++
+
+	}
+
+	// reverse order puts every directory after the ones inside it
+	sort.Sort(sort.Reverse(sort.StringSlice(dirlist)))
+
+	for _, dir := range dirlist {
+
+		target, _ := scanner.LocalTarget(localpath, dir)
+
+		if dryrun {
+			dlLog.Printf("would delete directory: %s", dir)
+			dirs++
+			continue
+		}
+
+		if err := os.Remove(target); err != nil {
+			dlLog.Printf("error deleting directory: %s: %v", dir, err)
+			dlProgress.Errors.Add(target, err)
+			continue
+		}
+		dlLog.Printf("deleted directory: %s", dir)
+		dirs++
+
+	}
+
+	return files, dirs
+
+}
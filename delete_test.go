@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirm(t *testing.T) {
+
+	defer func() { confirmInput = os.Stdin }()
+
+	for answer, expected := range map[string]bool{"y\n": true, "YES\n": true, " yes \n": true, "n\n": false, "\n": false, "": false, "yeah\n": false} {
+		confirmInput = strings.NewReader(answer)
+		assert.Equal(t, expected, confirm("Delete everything?"), answer)
+	}
+}
+
+func TestDeleteLocal(t *testing.T) {
+
+	savedLog := dlLog
+	defer func() { dlLog, dryrun, deleteDirs = savedLog, false, false }()
+
+	var logged bytes.Buffer
+	dlLog = log.New(&logged, "", 0)
+
+	setup := func() string {
+		dir, _ := ioutil.TempDir("", "sitescan-delete")
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, "base", "gone", "deeper"), 0755))
+		for _, file := range []string{"outside", "base/keep", "base/old", "base/gone/file1", "base/gone/deeper/file2", "base/new" + dlSuffix} {
+			assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(file), 0644))
+		}
+		return dir
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	filelist := []string{"../outside", "gone/", "gone/deeper/", "gone/deeper/file2", "gone/file1", "new" + dlSuffix, "old"}
+
+	// a dry run leaves everything where it is
+	dir := setup()
+	defer os.RemoveAll(dir)
+	dryrun = true
+	files, dirs := deleteLocal(filepath.Join(dir, "base")+"/", filelist)
+	assert.Equal(t, 3, files)
+	assert.Equal(t, 0, dirs)
+	assert.True(t, exists(filepath.Join(dir, "base", "old")))
+	assert.Contains(t, logged.String(), "would delete: old\n")
+
+	// without --delete-dirs, just the files go
+	dryrun = false
+	files, dirs = deleteLocal(filepath.Join(dir, "base")+"/", filelist)
+	assert.Equal(t, 3, files)
+	assert.Equal(t, 0, dirs)
+	assert.False(t, exists(filepath.Join(dir, "base", "old")))
+	assert.False(t, exists(filepath.Join(dir, "base", "gone", "deeper", "file2")))
+	assert.True(t, exists(filepath.Join(dir, "base", "gone", "deeper")))
+	assert.True(t, exists(filepath.Join(dir, "base", "keep")))
+	assert.True(t, exists(filepath.Join(dir, "base", "new"+dlSuffix)))
+	assert.True(t, exists(filepath.Join(dir, "outside")))
+	assert.Contains(t, logged.String(), "deleted: gone/file1\n")
+
+	// and with it, the directories, deepest first
+	dir2 := setup()
+	defer os.RemoveAll(dir2)
+	deleteDirs = true
+	files, dirs = deleteLocal(filepath.Join(dir2, "base")+"/", filelist)
+	assert.Equal(t, 3, files)
+	assert.Equal(t, 2, dirs)
+	assert.False(t, exists(filepath.Join(dir2, "base", "gone")))
+	assert.True(t, exists(filepath.Join(dir2, "base", "keep")))
+	assert.True(t, exists(filepath.Join(dir2, "outside")))
+}
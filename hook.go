@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// hookRunner runs the --on-download-complete command for each file that's
+// downloaded. No more than throttle of them run at once - when they're all
+// busy, the download that's just finished waits for one to be done, so a slow
+// command slows the downloads down rather than piling up processes.
+type hookRunner struct {
+	command []string
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newHookRunner sets up a hookRunner for the command template in command, which
+// is split into words the way a shell would (see splitCommand). It runs at
+// most limit commands at once.
+func newHookRunner(command string, limit int) (*hookRunner, error) {
+
+	words, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("the command is empty")
+	}
+
+	return &hookRunner{command: words, slots: make(chan struct{}, limit)}, nil
+
+}
+
+// run starts the command for file, the full path it was downloaded to, once
+// there's a slot free for it. A command that fails, or exits with anything but
+// 0, is logged, and the downloads carry on regardless. Cancelling ctx kills
+// any that are still running.
+func (h *hookRunner) run(ctx context.Context, file string) {
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer func() {
+			<-h.slots
+			h.wg.Done()
+		}()
+
+		args := hookCommand(h.command, file)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), "SITESCAN_FILE="+file)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			dlLog.Printf("--on-download-complete failed for %s: %v: %s", file, err, strings.TrimSpace(string(out)))
+			return
+		}
+		if debug {
+			dlLog.Printf("--on-download-complete finished for %s", file)
+		}
+	}()
+
+}
+
+// wait waits for every command that's been started to finish.
+func (h *hookRunner) wait() {
+	h.wg.Wait()
+}
+
+// hookCommand is the command to run for file: the words of the template, with
+// every "{}" in them replaced by file. Each word stays a single argument,
+// whatever's in the file's name, since there's no shell to split it up again.
+func hookCommand(template []string, file string) []string {
+
+	args := make([]string, len(template))
+	for i, word := range template {
+		args[i] = strings.ReplaceAll(word, "{}", file)
+	}
+
+	return args
+
+}
+
+// splitCommand splits a command line into words at spaces, as a shell would,
+// keeping anything in single or double quotes together, and taking a
+// backslash to mean the next character is part of the word (except inside
+// single quotes).
+func splitCommand(s string) ([]string, error) {
+
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case r == '\\' && quote != '\'':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("%q ends with a backslash", s)
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("%q has an unterminated %c quote", s, quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+
+}
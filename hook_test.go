@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommand(t *testing.T) {
+
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{"notify-send done", []string{"notify-send", "done"}},
+		{"  spaced   out  ", []string{"spaced", "out"}},
+		{`cp {} "/mnt/my backups/"`, []string{"cp", "{}", "/mnt/my backups/"}},
+		{`sh -c 'echo "$SITESCAN_FILE"'`, []string{"sh", "-c", `echo "$SITESCAN_FILE"`}},
+		{`a\ b c`, []string{"a b", "c"}},
+		{`empty ""`, []string{"empty", ""}},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		words, err := splitCommand(test.input)
+		assert.Nil(t, err, test.input)
+		assert.Equal(t, test.expected, words, test.input)
+	}
+
+	_, err := splitCommand(`echo "oops`)
+	assert.NotNil(t, err)
+	_, err = splitCommand(`echo oops\`)
+	assert.NotNil(t, err)
+
+	_, err = newHookRunner("  ", 1)
+	assert.NotNil(t, err)
+
+}
+
+func TestHookCommand(t *testing.T) {
+	assert.Equal(t, []string{"cp", "/dl/a; rm -rf ~.mp3", "/backup//dl/a; rm -rf ~.mp3.bak"},
+		hookCommand([]string{"cp", "{}", "/backup/{}.bak"}, "/dl/a; rm -rf ~.mp3"))
+}
+
+func TestHookRunner(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	saved := dlLog
+	defer func() { dlLog = saved }()
+	var out bytes.Buffer
+	dlLog = log.New(&out, "", 0)
+
+	dir := t.TempDir()
+	h, err := newHookRunner(`sh -c 'echo "$1 $SITESCAN_FILE" >> "$0.log"; case "$1" in *bad*) exit 3;; esac' `+filepath.Join(dir, "hook")+` {}`, 2)
+	assert.Nil(t, err)
+
+	for _, file := range []string{"/dl/one", "/dl/two words", "/dl/bad"} {
+		h.run(context.Background(), file)
+	}
+	h.wait()
+
+	logged, err := os.ReadFile(filepath.Join(dir, "hook.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(logged), "/dl/one /dl/one\n")
+	assert.Contains(t, string(logged), "/dl/two words /dl/two words\n")
+	assert.Contains(t, string(logged), "/dl/bad /dl/bad\n")
+
+	assert.Contains(t, out.String(), "--on-download-complete failed for /dl/bad: exit status 3")
+	assert.NotContains(t, out.String(), "/dl/one")
+
+	// once ctx is cancelled, nothing more is started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.slots <- struct{}{}
+	h.slots <- struct{}{}
+	h.run(ctx, "/dl/never")
+	h.wait()
+	logged, _ = os.ReadFile(filepath.Join(dir, "hook.log"))
+	assert.NotContains(t, string(logged), "never")
+
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// logLevels are the names --log-level takes.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// parseLogLevel turns a --log-level name into a slog.Level.
+func parseLogLevel(s string) (slog.Level, error) {
+
+	level, ok := logLevels[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("<%s> must be error, warn, info or debug", s)
+	}
+
+	return level, nil
+
+}
+
+// logFormats are the names --log-format takes.
+var logFormats = []string{"text", "json"}
+
+// setupLogging sends sitescan's messages at level and above to w, in the
+// --log-format, and sets debug to match, for the places that only do extra
+// work when debugging.
+func setupLogging(w io.Writer, level slog.Level) {
+
+	var h slog.Handler = newLogHandler(w, level)
+	if logFormat == "json" {
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	}
+
+	slog.SetDefault(slog.New(errorRecorder{h}))
+	debug = level <= slog.LevelDebug
+
+}
+
+// setDownloadLog points dlLog at w. For --log-format json, every line it gets
+// becomes a JSON object of its own, and dlJSON is set up alongside it for the
+// messages that have more to say than their text, like which worker's talking.
+// The handler writes each object in one go, so workers can share it without
+// their lines getting mixed up.
+func setDownloadLog(w io.Writer, flags int) {
+
+	if logFormat == "json" {
+		dlJSON = slog.New(slog.NewJSONHandler(w, nil))
+		dlLog = slog.NewLogLogger(dlJSON.Handler(), slog.LevelInfo)
+		return
+	}
+
+	dlJSON = nil
+	dlLog = log.New(w, "", flags)
+
+}
+
+// lastError is the last error logged, as "message key=value ...", for
+// --notify-webhook to say what went wrong.
+var lastError atomic.Value
+
+// errorRecorder is a slog.Handler that keeps the last error passed through it
+// in lastError, on its way to the handler it wraps.
+type errorRecorder struct {
+	slog.Handler
+}
+
+func (h errorRecorder) Handle(ctx context.Context, r slog.Record) error {
+
+	if r.Level >= slog.LevelError {
+		var b strings.Builder
+		b.WriteString(r.Message)
+		r.Attrs(func(a slog.Attr) bool {
+			writeAttr(&b, "", a)
+			return true
+		})
+		lastError.Store(b.String())
+	}
+
+	return h.Handler.Handle(ctx, r)
+
+}
+
+func (h errorRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return errorRecorder{h.Handler.WithAttrs(attrs)}
+}
+
+func (h errorRecorder) WithGroup(name string) slog.Handler {
+	return errorRecorder{h.Handler.WithGroup(name)}
+}
+
+// logHandler is the slog.Handler behind sitescan's messages. Each one goes on
+// a line of its own, as "LEVEL: message", followed by its attributes as
+// key=value pairs - the way sitescan has always written them, rather than
+// slog's timestamped key=value format, since there's usually a person reading.
+type logHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	group string
+}
+
+func newLogHandler(w io.Writer, level slog.Level) *logHandler {
+	return &logHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *logHandler) Handle(_ context.Context, r slog.Record) error {
+
+	var b strings.Builder
+	level := r.Level.String()
+	if r.Level == slog.LevelWarn {
+		level = "WARNING"
+	}
+	fmt.Fprintf(&b, "%s: %s", level, r.Message)
+
+	for _, a := range h.attrs {
+		writeAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+
+	return err
+
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	h2 := *h
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		h2.attrs = append(h2.attrs, a)
+	}
+
+	return &h2
+
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	if h2.group != "" {
+		h2.group += "."
+	}
+	h2.group += name
+
+	return &h2
+
+}
+
+// writeAttr writes one attribute as " key=value", quoting the value if it's
+// empty, or has spaces or quotes in it.
+func writeAttr(b *strings.Builder, group string, a slog.Attr) {
+
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	key := a.Key
+	if group != "" {
+		key = group + "." + key
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			writeAttr(b, key, ga)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s=%s", key, value)
+
+}
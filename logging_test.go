@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+
+	for name, want := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		level, err := parseLogLevel(name)
+		assert.Nil(t, err)
+		assert.Equal(t, want, level)
+	}
+
+	_, err := parseLogLevel("verbose")
+	assert.NotNil(t, err)
+
+}
+
+func TestLogHandler(t *testing.T) {
+
+	saved := slog.Default()
+	defer func() {
+		slog.SetDefault(saved)
+		debug = false
+	}()
+
+	var buf bytes.Buffer
+	setupLogging(&buf, slog.LevelWarn)
+	assert.False(t, debug)
+
+	slog.Debug("not shown")
+	slog.Info("not shown either")
+	slog.Warn("nothing was found under the strip path", "strip", "pub/", "site", "Mirror 1")
+	slog.Error("unable to open output file", "path", "/tmp/out.txt", "err", errors.New("permission denied"))
+	slog.With("site", 2).WithGroup("req").Error("failed", "status", 404, "empty", "")
+
+	assert.Equal(t, "WARNING: nothing was found under the strip path strip=pub/ site=\"Mirror 1\"\n"+
+		"ERROR: unable to open output file path=/tmp/out.txt err=\"permission denied\"\n"+
+		"ERROR: failed site=2 req.status=404 req.empty=\"\"\n", buf.String())
+
+	buf.Reset()
+	setupLogging(&buf, slog.LevelDebug)
+	assert.True(t, debug)
+	slog.Debug("config", "throttle", 4)
+	assert.Equal(t, "DEBUG: config throttle=4\n", buf.String())
+
+}
+
+func TestJSONLogging(t *testing.T) {
+
+	savedLog, saved := dlLog, slog.Default()
+	defer func() {
+		logFormat = "text"
+		setupLogging(&bytes.Buffer{}, slog.LevelInfo)
+		slog.SetDefault(saved)
+		dlLog, dlJSON = savedLog, nil
+	}()
+
+	logFormat = "json"
+	var buf, dl bytes.Buffer
+	setupLogging(&buf, slog.LevelInfo)
+	setDownloadLog(&dl, 0)
+
+	slog.Warn("nothing was found under the strip path", "strip", "pub/", "site", "Mirror 1")
+	var line map[string]interface{}
+	if assert.Nil(t, json.Unmarshal(buf.Bytes(), &line)) {
+		assert.Equal(t, "WARN", line["level"])
+		assert.Equal(t, "nothing was found under the strip path", line["msg"])
+		assert.Equal(t, "Mirror 1", line["site"])
+		assert.NotEmpty(t, line["time"])
+	}
+
+	// every worker's line is a JSON object of its own, however many are
+	// talking at once
+	var wg sync.WaitGroup
+	for id := 1; id <= 8; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				workerLog(id, "finished: file%d", i)
+			}
+		}(id)
+	}
+	wg.Wait()
+	dlLog.Printf("Downloads complete")
+
+	lines := strings.Split(strings.TrimSuffix(dl.String(), "\n"), "\n")
+	if assert.Len(t, lines, 401) {
+		for _, l := range lines[:400] {
+			line = nil
+			if assert.Nil(t, json.Unmarshal([]byte(l), &line), l) {
+				assert.Contains(t, line["msg"], "finished: file")
+				assert.NotNil(t, line["worker"])
+			}
+		}
+		assert.Nil(t, json.Unmarshal([]byte(lines[400]), &line))
+		assert.Equal(t, "Downloads complete", line["msg"])
+	}
+
+}
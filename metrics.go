@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// writeMetrics writes report to path for --metrics-file, in the Prometheus
+// text format that node_exporter's textfile collector reads. It's replaced
+// whole, so the collector never picks up half a run.
+func writeMetrics(path string, report runReport) error {
+
+	var b strings.Builder
+	if err := renderMetrics(&b, report); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, []byte(b.String()), 0644)
+
+}
+
+// renderMetrics writes report as Prometheus metrics. Everything's a gauge,
+// since each is what the last run found, rather than a count that only goes
+// up.
+func renderMetrics(w io.Writer, report runReport) error {
+
+	metric := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	metric("sitescan_files_total", "Files found at each site in the last run.")
+	for _, s := range report.Sites {
+		fmt.Fprintf(w, "sitescan_files_total{site=%s,url=%s} %d\n", metricLabel(s.Name), metricLabel(redactURL(s.URL)), s.Files)
+	}
+
+	metric("sitescan_diff_total", "Differences found between the sites in the last run.")
+	fmt.Fprintf(w, "sitescan_diff_total %d\n", report.Differences)
+
+	metric("sitescan_scan_duration_seconds", "How long the last run took.")
+	fmt.Fprintf(w, "sitescan_scan_duration_seconds %s\n", strconv.FormatFloat(report.Seconds, 'f', -1, 64))
+
+	metric("sitescan_download_bytes_total", "Bytes downloaded in the last run.")
+	fmt.Fprintf(w, "sitescan_download_bytes_total %d\n", report.DownloadBytes)
+
+	metric("sitescan_exit_status", "The exit status of the last run.")
+	fmt.Fprintf(w, "sitescan_exit_status %d\n", report.ExitStatus)
+
+	metric("sitescan_last_run_timestamp_seconds", "When the last run finished, in seconds since the Unix epoch.")
+	_, err := fmt.Fprintf(w, "sitescan_last_run_timestamp_seconds %d\n", report.Finished.Unix())
+
+	return err
+
+}
+
+// metricLabel quotes a label value the way the Prometheus text format wants
+// it, escaping backslashes, double quotes and newlines.
+func metricLabel(s string) string {
+
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+
+	return `"` + s + `"`
+
+}
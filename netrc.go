@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcLogin is a login and password from a .netrc file.
+type netrcLogin struct {
+	Login    string
+	Password string
+}
+
+// netrc holds the logins from a .netrc file, keyed by machine name. The
+// "default" entry, if there is one, is under "".
+type netrc map[string]netrcLogin
+
+// parseNetrc reads a .netrc file, as used by ftp and curl. Only the machine,
+// default, login and password tokens matter here - account is skipped, and so
+// are macro definitions. The first entry for a machine is the one that counts.
+func parseNetrc(r io.Reader) (netrc, error) {
+
+	scanner := bufio.NewScanner(r)
+	n := make(netrc)
+
+	var machine string
+	var entry *netrcLogin
+	inMacro := false
+
+	save := func() {
+		if entry != nil {
+			if _, ok := n[machine]; !ok {
+				n[machine] = *entry
+			}
+		}
+		entry = nil
+	}
+
+	for scanner.Scan() {
+
+		line := scanner.Text()
+
+		// a macro definition runs until the next blank line
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+
+			token := fields[i]
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+
+			next := func() (string, error) {
+				if i+1 >= len(fields) {
+					return "", fmt.Errorf("%q needs a value after it", token)
+				}
+				i++
+				return fields[i], nil
+			}
+
+			switch token {
+			case "machine":
+				save()
+				name, err := next()
+				if err != nil {
+					return nil, err
+				}
+				machine, entry = strings.ToLower(name), &netrcLogin{}
+			case "default":
+				save()
+				machine, entry = "", &netrcLogin{}
+			case "login", "password", "account":
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if entry == nil {
+					return nil, fmt.Errorf("%q before any machine", token)
+				}
+				switch token {
+				case "login":
+					entry.Login = value
+				case "password":
+					entry.Password = value
+				}
+			case "macdef":
+				save()
+				inMacro = true
+				i = len(fields)
+			default:
+				return nil, fmt.Errorf("unexpected %q", token)
+			}
+
+		}
+
+	}
+	save()
+
+	return n, scanner.Err()
+
+}
+
+// lookup finds the login for host, falling back on the default entry.
+func (n netrc) lookup(host string) (netrcLogin, bool) {
+
+	if login, ok := n[strings.ToLower(host)]; ok {
+		return login, true
+	}
+	login, ok := n[""]
+
+	return login, ok
+
+}
+
+// loadNetrc reads the .netrc file at path. Without a path, it's $NETRC, or
+// .netrc in the home directory - and it's no problem if that isn't there.
+func loadNetrc(path string) (netrc, error) {
+
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	n, err := parseNetrc(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return n, nil
+
+}
+
+// applyNetrc gives each site that hasn't been given a user, password or token
+// (in its URL, either) the login .netrc has for its host. S3 and local sites
+// don't log in that way, so they're left alone.
+func applyNetrc(n netrc, configs []siteConfig) {
+
+	for i, c := range configs {
+
+		if c.User != "" || c.Pass != "" || c.Token != "" {
+			continue
+		}
+
+		u, err := url.Parse(strings.Trim(c.URL, "\""))
+		if err != nil || u.Hostname() == "" || u.User != nil || u.Scheme == "s3" || u.Scheme == "file" {
+			continue
+		}
+
+		if login, ok := n.lookup(u.Hostname()); ok {
+			configs[i].User, configs[i].Pass = login.Login, login.Password
+		}
+
+	}
+
+}
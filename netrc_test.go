@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleNetrc = `# mirrors
+machine mirror1.example.com
+  login someguy
+  password spaceballs12345
+
+machine ftp.example.org login anonymous password guest@example.org account extra
+
+macdef init
+cd /pub
+binary
+
+machine Mirror1.example.com login second password ignored
+default login fallback password fallback123
+`
+
+func TestParseNetrc(t *testing.T) {
+
+	n, err := parseNetrc(strings.NewReader(sampleNetrc))
+	assert.Nil(t, err)
+
+	login, ok := n.lookup("mirror1.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, netrcLogin{Login: "someguy", Password: "spaceballs12345"}, login)
+
+	login, ok = n.lookup("FTP.example.org")
+	assert.True(t, ok)
+	assert.Equal(t, netrcLogin{Login: "anonymous", Password: "guest@example.org"}, login)
+
+	login, ok = n.lookup("elsewhere.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "fallback", login.Login)
+
+	n, err = parseNetrc(strings.NewReader("machine only.example.com login someguy\n"))
+	assert.Nil(t, err)
+	_, ok = n.lookup("elsewhere.example.com")
+	assert.False(t, ok)
+
+	_, err = parseNetrc(strings.NewReader("machine\n"))
+	assert.NotNil(t, err)
+	_, err = parseNetrc(strings.NewReader("login someguy\n"))
+	assert.NotNil(t, err)
+	_, err = parseNetrc(strings.NewReader("machine a.example.com colour blue\n"))
+	assert.NotNil(t, err)
+
+}
+
+func TestApplyNetrc(t *testing.T) {
+
+	n, err := parseNetrc(strings.NewReader("machine mirror1.example.com login someguy password spaceballs12345\n"))
+	assert.Nil(t, err)
+
+	configs := []siteConfig{
+		{URL: "http://mirror1.example.com:8080/pub/"},
+		{URL: "ftp://mirror1.example.com/", User: "given"},
+		{URL: "http://other:pw@mirror1.example.com/"},
+		{URL: "/local/path"},
+		{URL: "http://mirror2.example.com/"},
+	}
+	applyNetrc(n, configs)
+
+	assert.Equal(t, "someguy", configs[0].User)
+	assert.Equal(t, "spaceballs12345", configs[0].Pass)
+	assert.Equal(t, "given", configs[1].User)
+	assert.Equal(t, "", configs[1].Pass)
+	assert.Equal(t, "", configs[2].User)
+	assert.Equal(t, "", configs[3].User)
+	assert.Equal(t, "", configs[4].User)
+
+}
+
+func TestLoadNetrc(t *testing.T) {
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("NETRC", "")
+
+	// no ~/.netrc is fine, but a missing file that was asked for isn't
+	n, err := loadNetrc("")
+	assert.Nil(t, err)
+	assert.Nil(t, n)
+	_, err = loadNetrc(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, ".netrc"), []byte(sampleNetrc), 0600))
+	n, err = loadNetrc("")
+	assert.Nil(t, err)
+	_, ok := n.lookup("mirror1.example.com")
+	assert.True(t, ok)
+
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/davexre/sitescan/webhandler"
+)
+
+// notifyTimeout is how long the webhook has to answer, so a server that's
+// down can't hold up the end of the run for long.
+const notifyTimeout = 30 * time.Second
+
+// notify sends report to the --notify-webhook, if there is one. It only warns
+// if it can't - the run's over by now, either way.
+func notify(report runReport) {
+
+	if notifyURL == "" {
+		return
+	}
+
+	body, err := notifyBody(report, notifyTemplate)
+	if err != nil {
+		slog.Warn("unable to build the notification", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	res, err := webhandler.PostHandler(ctx, notifyURL, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}, int64(len(body)), notifyOpts)
+	if err != nil {
+		slog.Warn("unable to send the notification", "url", redactURL(notifyURL), "err", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		slog.Warn("the notification wasn't accepted", "url", redactURL(notifyURL), "status", res.Status)
+	}
+
+}
+
+// notifyBody is what's sent to the webhook for report: the report as JSON, or
+// if there's a --notify-template, whatever that makes of it.
+func notifyBody(report runReport, tmpl *template.Template) ([]byte, error) {
+
+	if tmpl == nil {
+		return json.Marshal(report)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, report); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+
+}
+
+// parseNotifyTemplate parses a --notify-template. It has a json function as
+// well as the usual ones, for quoting strings safely inside a JSON payload.
+func parseNotifyTemplate(text string) (*template.Template, error) {
+
+	return template.New("notify").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+
+}
+
+// redactURL hides the password in a URL, if it has one, for logging it.
+func redactURL(u string) string {
+
+	if parsed, err := url.Parse(u); err == nil {
+		return parsed.Redacted()
+	}
+
+	return u
+
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// siteSummary identifies one of the sites in a comparison.
+type siteSummary struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// diffEntry is a file or directory that only exists at one of the sites.
+type diffEntry struct {
+	Path string `json:"path"`
+	fileEntry
+}
+
+// comparison holds everything we found out by comparing the two sites. It's
+// built once by compareSites, and then handed to one of the render functions,
+// so every output format reports exactly the same thing.
+type comparison struct {
+	Site1         siteSummary    `json:"site1"`
+	Site2         siteSummary    `json:"site2"`
+	Site1Only     []diffEntry    `json:"site1_only"`
+	Site2Only     []diffEntry    `json:"site2_only"`
+	SizeDiffs     []sizeDiff     `json:"size_differences,omitempty"`
+	NewerDiffs    []newerDiff    `json:"newer_at_site2,omitempty"`
+	ChecksumDiffs []checksumDiff `json:"checksum_differences,omitempty"`
+	FetchErrors   []fetchError   `json:"fetch_errors,omitempty"`
+	Summary       totals         `json:"summary"`
+
+	// Common is what's at both sites - it's only filled in for --sync-preview
+	Common []string `json:"-"`
+}
+
+// totals has the totals for a comparison: how many entries were found at each
+// site, how many of them are at both, and how many at just the one. With
+// --suppress, directories aren't counted, just as they aren't listed. It covers
+// everything at both sites, even when --state leaves just the new differences
+// in the lists.
+type totals struct {
+	Site1Entries int `json:"site1_entries"`
+	Site2Entries int `json:"site2_entries"`
+	InCommon     int `json:"in_common"`
+	Site1Only    int `json:"site1_only"`
+	Site2Only    int `json:"site2_only"`
+}
+
+// downloadTotals has the totals for a --download (or an --upload): how many
+// files there were to fetch, how many were, how many failed, and how many were
+// never got to - by a dry run, or because we were interrupted.
+type downloadTotals struct {
+	Queued    int `json:"queued"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+
+	// Deleted and DeletedDirs count what --delete deleted
+	Deleted     int `json:"deleted,omitempty"`
+	DeletedDirs int `json:"deleted_dirs,omitempty"`
+}
+
+// exitDiff is the exit status for a comparison that found differences, with
+// --fail-on-diff. 1 is for errors, and 130 for being interrupted.
+const exitDiff = 2
+
+// hasDifferences reports whether the comparison found anything different
+// between the two sites - fetch errors don't count, since they're not
+// differences as such.
+func (c comparison) hasDifferences() bool {
+	return c.differences() > 0
+}
+
+// differences counts everything the comparison found different.
+func (c comparison) differences() int {
+	return len(c.Site1Only) + len(c.Site2Only) + len(c.SizeDiffs) + len(c.NewerDiffs) + len(c.ChecksumDiffs)
+}
+
+// missingFrom lists the entries that exist at one or more of the other sites,
+// but not at this one.
+type missingFrom struct {
+	Site    siteSummary `json:"site"`
+	Missing []diffEntry `json:"missing"`
+}
+
+// multiComparison is what we found out by comparing more than two sites at once.
+// With that many, "only at" stops being useful, so instead each site gets the
+// list of what it's missing compared to all the others put together.
+type multiComparison struct {
+	Sites       []siteSummary `json:"sites"`
+	Missing     []missingFrom `json:"missing"`
+	FetchErrors []fetchError  `json:"fetch_errors,omitempty"`
+}
+
+// hasDifferences reports whether any of the sites is missing anything.
+func (c multiComparison) hasDifferences() bool {
+	return c.differences() > 0
+}
+
+// differences counts the entries missing from each site, all added together.
+func (c multiComparison) differences() int {
+	n := 0
+	for _, m := range c.Missing {
+		n += len(m.Missing)
+	}
+	return n
+}
+
+// compareSites compares the two site maps in every way that's been asked for.
+// If the checksum comparison fails part way through, whatever was found up to
+// that point is still returned, along with the error.
+func compareSites(ctx context.Context, sm1, sm2 *fileMap) (comparison, error) {
+
+	var err error
+
+	result := comparison{
+		Site1:       siteSummary{Name: site1Name, URL: url1},
+		Site2:       siteSummary{Name: site2Name, URL: url2},
+		Site1Only:   diffEntries(sm1, compareMaps(sm1, sm2)),
+		Site2Only:   diffEntries(sm2, compareMaps(sm2, sm1)),
+		FetchErrors: walkErrors.List(),
+	}
+	result.Summary = summarize(sm1, sm2, len(result.Site1Only), len(result.Site2Only))
+
+	if outputFormat == "sync" {
+		result.Common = commonKeys(sm1, sm2)
+	}
+
+	if sizeCompare {
+		result.SizeDiffs = compareSizes(sm1, sm2)
+	}
+
+	if newerThan {
+		result.NewerDiffs = compareTimes(sm1, sm2)
+	}
+
+	if checksumAlgo != "" {
+		result.ChecksumDiffs, err = compareChecksums(ctx, url1, url2, sm1, sm2, checksumAlgo)
+	}
+
+	return result, err
+
+}
+
+// commonKeys lists the entries in sm1 that are in sm2, too - sorted, and
+// matched the same way compareMaps matches them.
+func commonKeys(sm1, sm2 *fileMap) []string {
+
+	var common []string
+	index := caseIndex(sm2)
+
+	keys := sm1.Keys()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if suppress && strings.HasSuffix(k, "/") {
+			continue
+		}
+		if _, exists := lookupEntry(sm2, index, k); exists {
+			common = append(common, k)
+		}
+	}
+
+	return common
+
+}
+
+// summarize totals up a comparison of sm1 and sm2, given how many entries are
+// only at each.
+func summarize(sm1, sm2 *fileMap, only1, only2 int) totals {
+
+	count := func(sm *fileMap) int {
+		n := 0
+		for _, k := range sm.Keys() {
+			if !suppress || !strings.HasSuffix(k, "/") {
+				n++
+			}
+		}
+		return n
+	}
+
+	s := totals{Site1Entries: count(sm1), Site2Entries: count(sm2), Site1Only: only1, Site2Only: only2}
+	s.InCommon = s.Site1Entries - only1
+
+	return s
+
+}
+
+// compareAllSites works out, for each site, what it's missing relative to the
+// union of all the other sites.
+func compareAllSites(all []*site) multiComparison {
+
+	result := multiComparison{FetchErrors: walkErrors.List()}
+
+	for i, s := range all {
+		result.Sites = append(result.Sites, siteSummary{Name: s.Name, URL: s.URL})
+
+		others := new(fileMap)
+		for j, other := range all {
+			if j == i {
+				continue
+			}
+			for k, entry := range other.Map.Snapshot() {
+				if _, exists := others.Get(k); !exists {
+					others.Set(k, entry)
+				}
+			}
+		}
+
+		result.Missing = append(result.Missing, missingFrom{
+			Site:    siteSummary{Name: s.Name, URL: s.URL},
+			Missing: diffEntries(others, compareMaps(others, s.Map)),
+		})
+	}
+
+	return result
+
+}
+
+// diffEntries looks up the details for each of the named entries in siteMap.
+func diffEntries(siteMap *fileMap, names []string) []diffEntry {
+
+	entries := make([]diffEntry, 0, len(names))
+	for _, name := range names {
+		entry, _ := siteMap.Get(name)
+		entries = append(entries, diffEntry{Path: name, fileEntry: entry})
+	}
+
+	return entries
+
+}
+
+// render writes the comparison in whichever outputFormat was asked for.
+func render(w io.Writer, result comparison) error {
+
+	switch outputFormat {
+	case "json":
+		return renderJSON(w, result)
+	case "csv":
+		return renderCSV(w, result)
+	case "quiet":
+		renderQuiet(w, result)
+		renderFetchErrors(statusOut, result.FetchErrors)
+		return nil
+	case "sync":
+		renderSyncPreview(w, result)
+		return nil
+	default:
+		renderText(w, result)
+		return nil
+	}
+
+}
+
+// renderQuiet writes just the paths that differ, one per line, for piping into
+// other commands. Unless markers are turned off, each starts with "<" if it's
+// only at Site 1, ">" if it's only at Site 2, or "~" if it's at both but
+// different (in size, time or contents), and a space. Each path ends with
+// pathEnd.
+func renderQuiet(w io.Writer, result comparison) {
+
+	line := func(marker, path string) {
+		if markers {
+			fmt.Fprintf(w, "%s %s%s", marker, path, pathEnd)
+		} else {
+			fmt.Fprint(w, path, pathEnd)
+		}
+	}
+
+	for _, entry := range result.Site1Only {
+		line("<", entry.Path)
+	}
+	for _, entry := range result.Site2Only {
+		line(">", entry.Path)
+	}
+
+	// a file can differ in more than one way, but it's only listed once
+	var changed []string
+	for _, diff := range result.SizeDiffs {
+		changed = append(changed, diff.Name)
+	}
+	for _, diff := range result.NewerDiffs {
+		changed = append(changed, diff.Name)
+	}
+	for _, diff := range result.ChecksumDiffs {
+		changed = append(changed, diff.Name)
+	}
+	sort.Strings(changed)
+	for i, name := range changed {
+		if i == 0 || name != changed[i-1] {
+			line("~", name)
+		}
+	}
+
+}
+
+// renderText writes the comparison in the traditional human readable format -
+// a banner for each section, followed by the entries in it.
+func renderText(w io.Writer, result comparison) {
+
+	banner := "Files/directories only at "
+
+	writeBanner(w, banner+result.Site1.Name+":")
+	for _, entry := range result.Site1Only {
+		fmt.Fprintln(w, entry.Path)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	writeBanner(w, banner+result.Site2.Name+":")
+	for _, entry := range result.Site2Only {
+		fmt.Fprintln(w, entry.Path)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	if sizeCompare {
+		writeBanner(w, "Files with different sizes:")
+		for _, diff := range result.SizeDiffs {
+			fmt.Fprintf(w, "%s (%s: %s, %s: %s)\n", diff.Name, result.Site1.Name, formatSize(diff.Site1),
+				result.Site2.Name, formatSize(diff.Site2))
+		}
+		fmt.Fprintf(w, "\n\n")
+	}
+
+	if newerThan {
+		writeBanner(w, "Files newer at "+result.Site2.Name+":")
+		for _, diff := range result.NewerDiffs {
+			fmt.Fprintf(w, "%s (%s: %s, %s: %s)\n", diff.Name, result.Site1.Name, formatTime(diff.Site1),
+				result.Site2.Name, formatTime(diff.Site2))
+		}
+		fmt.Fprintf(w, "\n\n")
+	}
+
+	if checksumAlgo != "" {
+		writeBanner(w, "Files with different contents:")
+		for _, diff := range result.ChecksumDiffs {
+			fmt.Fprintf(w, "%s (%s: %s, %s: %s)\n", diff.Name, result.Site1.Name, diff.Site1,
+				result.Site2.Name, diff.Site2)
+		}
+		fmt.Fprintf(w, "\n\n")
+	}
+
+	renderFetchErrors(w, result.FetchErrors)
+	renderSummary(w, result)
+
+}
+
+// renderSyncPreview writes the comparison the way a two-way sync would see it:
+// what would be pulled from Site 2, what would be pushed to it (or deleted from
+// Site 1, for a one-way mirror), and what's already at both and would be left
+// alone. Files at both that differ (in size, time or contents, if those were
+// compared) are marked, since a sync would have to pick a side for them.
+func renderSyncPreview(w io.Writer, result comparison) {
+
+	writeBanner(w, "Would pull from "+result.Site2.Name+":")
+	for _, entry := range result.Site2Only {
+		fmt.Fprintln(w, entry.Path)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	writeBanner(w, "Would push to "+result.Site2.Name+" (or delete from "+result.Site1.Name+"):")
+	for _, entry := range result.Site1Only {
+		fmt.Fprintln(w, entry.Path)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	differs := make(map[string]bool)
+	for _, diff := range result.SizeDiffs {
+		differs[diff.Name] = true
+	}
+	for _, diff := range result.NewerDiffs {
+		differs[diff.Name] = true
+	}
+	for _, diff := range result.ChecksumDiffs {
+		differs[diff.Name] = true
+	}
+
+	writeBanner(w, "At both:")
+	for _, name := range result.Common {
+		if differs[name] {
+			fmt.Fprintf(w, "%s (differs)\n", name)
+		} else {
+			fmt.Fprintln(w, name)
+		}
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	renderFetchErrors(w, result.FetchErrors)
+	renderSummary(w, result)
+
+}
+
+// renderSummary writes the totals for the comparison, as a footer.
+func renderSummary(w io.Writer, result comparison) {
+
+	writeBanner(w, "Summary:")
+	fmt.Fprintf(w, "%-30s %d\n", "Entries at "+result.Site1.Name+":", result.Summary.Site1Entries)
+	fmt.Fprintf(w, "%-30s %d\n", "Entries at "+result.Site2.Name+":", result.Summary.Site2Entries)
+	fmt.Fprintf(w, "%-30s %d\n", "At both:", result.Summary.InCommon)
+	fmt.Fprintf(w, "%-30s %d\n", "Only at "+result.Site1.Name+":", result.Summary.Site1Only)
+	fmt.Fprintf(w, "%-30s %d\n", "Only at "+result.Site2.Name+":", result.Summary.Site2Only)
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// renderDownloadSummary writes the totals for a --download, as a footer.
+func renderDownloadSummary(w io.Writer, dl downloadTotals) {
+
+	writeBanner(w, "Download summary:")
+	fmt.Fprintf(w, "%-30s %d\n", "Files to download:", dl.Queued)
+	fmt.Fprintf(w, "%-30s %d\n", "Downloaded:", dl.Succeeded)
+	fmt.Fprintf(w, "%-30s %d\n", "Failed:", dl.Failed)
+	fmt.Fprintf(w, "%-30s %d\n", "Skipped:", dl.Skipped)
+	if deleteFiles {
+		fmt.Fprintf(w, "%-30s %d\n", "Files deleted:", dl.Deleted)
+		fmt.Fprintf(w, "%-30s %d\n", "Directories deleted:", dl.DeletedDirs)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// renderUploadSummary writes the totals for an --upload, as a footer.
+func renderUploadSummary(w io.Writer, up downloadTotals) {
+
+	writeBanner(w, "Upload summary:")
+	fmt.Fprintf(w, "%-30s %d\n", "Files to upload:", up.Queued)
+	fmt.Fprintf(w, "%-30s %d\n", "Uploaded:", up.Succeeded)
+	fmt.Fprintf(w, "%-30s %d\n", "Failed:", up.Failed)
+	fmt.Fprintf(w, "%-30s %d\n", "Skipped:", up.Skipped)
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// renderMulti writes a comparison of more than two sites in whichever
+// outputFormat was asked for.
+func renderMulti(w io.Writer, result multiComparison) error {
+
+	switch outputFormat {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "csv":
+		return renderMultiCSV(w, result)
+	case "quiet":
+		renderMultiQuiet(w, result)
+		renderFetchErrors(statusOut, result.FetchErrors)
+		return nil
+	default:
+		renderMultiText(w, result)
+		return nil
+	}
+
+}
+
+// renderMultiText writes a banner for each site, followed by what it's missing.
+func renderMultiText(w io.Writer, result multiComparison) {
+
+	for _, m := range result.Missing {
+		writeBanner(w, "Files/directories missing from "+m.Site.Name+":")
+		for _, entry := range m.Missing {
+			fmt.Fprintln(w, entry.Path)
+		}
+		fmt.Fprintf(w, "\n\n")
+	}
+
+	renderFetchErrors(w, result.FetchErrors)
+
+}
+
+// renderMultiQuiet writes each path that's missing from a site, one per line.
+// Unless markers are turned off, each starts with the name of the site it's
+// missing from and a tab; if they are, each path is only listed once, however
+// many sites it's missing from. Each path ends with pathEnd.
+func renderMultiQuiet(w io.Writer, result multiComparison) {
+
+	listed := make(map[string]bool)
+	for _, m := range result.Missing {
+		for _, entry := range m.Missing {
+			switch {
+			case markers:
+				fmt.Fprintf(w, "%s\t%s%s", m.Site.Name, entry.Path, pathEnd)
+			case !listed[entry.Path]:
+				listed[entry.Path] = true
+				fmt.Fprint(w, entry.Path, pathEnd)
+			}
+		}
+	}
+
+}
+
+// renderMultiCSV writes a row for every file missing from a site, naming the
+// site, and its size at the other sites (blank if unknown).
+func renderMultiCSV(w io.Writer, result multiComparison) error {
+
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"path", "missing_from", "size"})
+
+	for _, m := range result.Missing {
+		for _, entry := range m.Missing {
+			cw.Write([]string{entry.Path, m.Site.Name, csvSize(entry.Size)})
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+
+}
+
+// renderFetchErrors lists the URLs that couldn't be retrieved during the walk,
+// if there were any.
+func renderFetchErrors(w io.Writer, errs []fetchError) {
+
+	if len(errs) == 0 {
+		return
+	}
+
+	writeBanner(w, "URLs that couldn't be retrieved (results above may be incomplete):")
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s: %v\n", e.URL, e.Err)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// renderDownloadErrors lists the files that couldn't be downloaded, if there
+// were any.
+func renderDownloadErrors(w io.Writer, errs []fetchError) {
+
+	if len(errs) == 0 {
+		return
+	}
+
+	writeBanner(w, "Files that failed to download:")
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s: %v\n", e.URL, e.Err)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// renderUploadErrors lists the files that couldn't be uploaded, and why.
+func renderUploadErrors(w io.Writer, errs []fetchError) {
+
+	if len(errs) == 0 {
+		return
+	}
+
+	writeBanner(w, "Files that failed to upload:")
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s: %v\n", e.URL, e.Err)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// renderDryRun summarizes what a --dryrun download would fetch: how many files,
+// and how many bytes, as far as the listings told us. Directories and leftover
+// partial downloads are skipped, just as the download workers skip them.
+func renderDryRun(w io.Writer, siteMap *fileMap, filelist []string) {
+
+	var files, unknown int
+	var total int64
+	approx := false
+
+	for _, name := range filelist {
+		if strings.HasSuffix(name, "/") || strings.HasSuffix(name, dlSuffix) {
+			continue
+		}
+		files++
+
+		entry, ok := siteMap.Get(name)
+		if !ok || entry.Size < 0 {
+			unknown++
+			continue
+		}
+		total += entry.Size
+		approx = approx || entry.SizeApprox
+	}
+
+	writeBanner(w, "Dry run summary:")
+	fmt.Fprintf(w, "Files to download: %d\n", files)
+
+	switch {
+	case files == 0:
+	case unknown == files:
+		fmt.Fprintf(w, "Total size:        unknown (the listing didn't include sizes)\n")
+	default:
+		about := ""
+		if approx {
+			about = "about "
+		}
+		fmt.Fprintf(w, "Total size:        %s%s (%d bytes)\n", about, humanSize(total), total)
+		if unknown > 0 {
+			fmt.Fprintf(w, "                   plus %d files of unknown size\n", unknown)
+		}
+	}
+	fmt.Fprintf(w, "\n\n")
+
+}
+
+// humanSize formats a byte count in the largest binary unit that keeps it at
+// least 1, e.g. "1.5 GiB".
+func humanSize(size int64) string {
+
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+
+}
+
+// renderJSON writes the comparison as a single, indented JSON document.
+func renderJSON(w io.Writer, result comparison) error {
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(result)
+
+}
+
+// renderCSV writes the comparison as CSV, with a header row, for reviewing in a
+// spreadsheet. Every difference gets a row: only_at names the site a file was
+// found at when it's missing from the other, and difference says what kind of
+// difference it is ("missing", "size", "newer", or "contents"). Unknown sizes are left
+// blank. The summary comes last, as rows with "summary" for the difference,
+// what's being counted for the path, and the counts for each site as the sizes.
+func renderCSV(w io.Writer, result comparison) error {
+
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"path", "only_at", "size1", "size2", "difference"})
+
+	for _, entry := range result.Site1Only {
+		cw.Write([]string{entry.Path, result.Site1.Name, csvSize(entry.Size), "", "missing"})
+	}
+	for _, entry := range result.Site2Only {
+		cw.Write([]string{entry.Path, result.Site2.Name, "", csvSize(entry.Size), "missing"})
+	}
+	for _, diff := range result.SizeDiffs {
+		cw.Write([]string{diff.Name, "", csvSize(diff.Site1.Size), csvSize(diff.Site2.Size), "size"})
+	}
+	for _, diff := range result.NewerDiffs {
+		cw.Write([]string{diff.Name, "", csvSize(diff.Site1.Size), csvSize(diff.Site2.Size), "newer"})
+	}
+	for _, diff := range result.ChecksumDiffs {
+		cw.Write([]string{diff.Name, "", "", "", "contents"})
+	}
+
+	s := result.Summary
+	cw.Write([]string{"entries", "", strconv.Itoa(s.Site1Entries), strconv.Itoa(s.Site2Entries), "summary"})
+	cw.Write([]string{"in_common", "", strconv.Itoa(s.InCommon), strconv.Itoa(s.InCommon), "summary"})
+	cw.Write([]string{"only_at", "", strconv.Itoa(s.Site1Only), strconv.Itoa(s.Site2Only), "summary"})
+
+	cw.Flush()
+	return cw.Error()
+
+}
+
+// formatTime shows a last modified time to the minute, which is as much as most
+// listings give us.
+func formatTime(e fileEntry) string {
+	return e.ModTime.Format("2006-01-02 15:04")
+}
+
+func csvSize(size int64) string {
+	if size < 0 {
+		return ""
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// writeBanner writes a section title, underlined to match.
+func writeBanner(w io.Writer, title string) {
+
+	fmt.Fprintf(w, "%s\n", title)
+	for i := 0; i < len(title); i++ {
+		fmt.Fprintf(w, "=")
+	}
+	fmt.Fprintf(w, "\n\n")
+
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/davexre/sitescan/syncedmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func testComparison() comparison {
+	return comparison{
+		Site1:     siteSummary{Name: "X", URL: "/local/path"},
+		Site2:     siteSummary{Name: "Y", URL: "http://someurl.com/"},
+		Site1Only: []diffEntry{{Path: "string2", fileEntry: fileEntry{URL: "string2", Size: 10}}},
+		Site2Only: []diffEntry{},
+		Summary:   totals{Site1Entries: 3, Site2Entries: 2, InCommon: 2, Site1Only: 1, Site2Only: 0},
+	}
+}
+
+func TestRenderText(t *testing.T) {
+
+	var out bytes.Buffer
+	renderText(&out, testComparison())
+
+	expectedOutput := "Files/directories only at X:\n============================\n\nstring2\n\n\n" +
+		"Files/directories only at Y:\n============================\n\n\n\n" +
+		"Summary:\n========\n\n" +
+		"Entries at X:                  3\n" +
+		"Entries at Y:                  2\n" +
+		"At both:                       2\n" +
+		"Only at X:                     1\n" +
+		"Only at Y:                     0\n\n\n"
+
+	assert.Equal(t, expectedOutput, out.String())
+}
+
+func TestRenderQuiet(t *testing.T) {
+
+	defer func() { markers = true }()
+	result := testComparison()
+	result.Site2Only = []diffEntry{{Path: "dir1/"}}
+	result.SizeDiffs = []sizeDiff{{Name: "file2"}, {Name: "file1"}}
+	result.ChecksumDiffs = []checksumDiff{{Name: "file2"}}
+
+	var out bytes.Buffer
+	renderQuiet(&out, result)
+	assert.Equal(t, "< string2\n> dir1/\n~ file1\n~ file2\n", out.String())
+
+	out.Reset()
+	markers = false
+	renderQuiet(&out, result)
+	assert.Equal(t, "string2\ndir1/\nfile1\nfile2\n", out.String())
+
+	out.Reset()
+	pathEnd = "\x00"
+	defer func() { pathEnd = "\n" }()
+	result.Site1Only = []diffEntry{{Path: "a file\nwith a newline"}}
+	renderQuiet(&out, result)
+	assert.Equal(t, "a file\nwith a newline\x00dir1/\x00file1\x00file2\x00", out.String())
+}
+
+func TestCommonKeys(t *testing.T) {
+
+	defer func() { suppress = false }()
+
+	sm1 := syncedmap.New(map[string]fileEntry{"dir1/": {Size: -1}, "dir1/file1": {Size: 1}, "file2": {Size: 2}, "file3": {Size: 3}})
+	sm2 := syncedmap.New(map[string]fileEntry{"dir1/": {Size: -1}, "dir1/file1": {Size: 1}, "file3": {Size: 4}, "file4": {Size: 4}})
+
+	assert.Equal(t, []string{"dir1/", "dir1/file1", "file3"}, commonKeys(sm1, sm2))
+	suppress = true
+	assert.Equal(t, []string{"dir1/file1", "file3"}, commonKeys(sm1, sm2))
+}
+
+func TestRenderSyncPreview(t *testing.T) {
+
+	defer func() { outputFormat = "text" }()
+	outputFormat = "sync"
+
+	result := testComparison()
+	result.Site2Only = []diffEntry{{Path: "file4"}}
+	result.Common = []string{"file1", "file3"}
+	result.SizeDiffs = []sizeDiff{{Name: "file3"}}
+
+	var out bytes.Buffer
+	assert.Nil(t, render(&out, result))
+
+	assert.True(t, strings.HasPrefix(out.String(),
+		"Would pull from Y:\n==================\n\nfile4\n\n\n"+
+			"Would push to Y (or delete from X):\n===================================\n\nstring2\n\n\n"+
+			"At both:\n========\n\nfile1\nfile3 (differs)\n\n\n"+
+			"Summary:\n"), out.String())
+}
+
+func TestRenderTextFetchErrors(t *testing.T) {
+
+	result := testComparison()
+	result.FetchErrors = []fetchError{{URL: "http://someurl.com/dir1/", Err: fmt.Errorf("server returned 404 Not Found")}}
+
+	var out bytes.Buffer
+	renderText(&out, result)
+
+	assert.Contains(t, out.String(), "URLs that couldn't be retrieved")
+	assert.Contains(t, out.String(), "http://someurl.com/dir1/: server returned 404 Not Found\n")
+}
+
+func TestRenderJSON(t *testing.T) {
+
+	result := testComparison()
+	result.SizeDiffs = []sizeDiff{{Name: "file1", Site1: fileEntry{URL: "file1", Size: 1}, Site2: fileEntry{URL: "file1", Size: 2}}}
+	result.FetchErrors = []fetchError{{URL: "http://someurl.com/dir1/", Err: fmt.Errorf("timed out")}}
+
+	var out bytes.Buffer
+	assert.Nil(t, renderJSON(&out, result))
+
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+
+	assert.Equal(t, map[string]interface{}{"name": "X", "url": "/local/path"}, decoded["site1"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"path": "string2", "url": "string2", "size": float64(10)}}, decoded["site1_only"])
+	assert.Equal(t, []interface{}{}, decoded["site2_only"])
+	assert.Len(t, decoded["size_differences"], 1)
+	assert.NotContains(t, decoded, "checksum_differences")
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "http://someurl.com/dir1/", "error": "timed out"}}, decoded["fetch_errors"])
+	assert.Equal(t, map[string]interface{}{"site1_entries": float64(3), "site2_entries": float64(2), "in_common": float64(2),
+		"site1_only": float64(1), "site2_only": float64(0)}, decoded["summary"])
+}
+
+func TestRenderCSV(t *testing.T) {
+
+	result := testComparison()
+	result.Site1Only = append(result.Site1Only, diffEntry{Path: "dir, with comma/", fileEntry: fileEntry{URL: "dir/", Size: -1}})
+	result.Site2Only = []diffEntry{{Path: `say "hello".mp3`, fileEntry: fileEntry{URL: "hello.mp3", Size: 20}}}
+	result.SizeDiffs = []sizeDiff{{Name: "file1", Site1: fileEntry{Size: 1}, Site2: fileEntry{Size: 2}}}
+	result.ChecksumDiffs = []checksumDiff{{Name: "file2", Site1: "abc", Site2: "def"}}
+
+	var out bytes.Buffer
+	assert.Nil(t, renderCSV(&out, result))
+
+	expectedOutput := "path,only_at,size1,size2,difference\n" +
+		"string2,X,10,,missing\n" +
+		"\"dir, with comma/\",X,,,missing\n" +
+		"\"say \"\"hello\"\".mp3\",Y,,20,missing\n" +
+		"file1,,1,2,size\n" +
+		"file2,,,,contents\n" +
+		"entries,,3,2,summary\n" +
+		"in_common,,2,2,summary\n" +
+		"only_at,,1,0,summary\n"
+
+	assert.Equal(t, expectedOutput, out.String())
+}
+
+func TestRenderDownloadErrors(t *testing.T) {
+
+	var out bytes.Buffer
+	renderDownloadErrors(&out, nil)
+	assert.Equal(t, "", out.String())
+
+	renderDownloadErrors(&out, []fetchError{{URL: "http://someurl.com/file1", Err: fmt.Errorf("server returned 404 Not Found")}})
+	assert.Contains(t, out.String(), "Files that failed to download:\n")
+	assert.Contains(t, out.String(), "http://someurl.com/file1: server returned 404 Not Found\n")
+}
+
+func TestSummarize(t *testing.T) {
+
+	sm1 := syncedmap.New(map[string]fileEntry{"dir1/": {}, "dir1/file1": {}, "file2": {}, "file3": {}})
+	sm2 := syncedmap.New(map[string]fileEntry{"dir1/": {}, "dir1/file1": {}, "file4": {}})
+
+	assert.Equal(t, totals{Site1Entries: 4, Site2Entries: 3, InCommon: 2, Site1Only: 2, Site2Only: 1},
+		summarize(sm1, sm2, len(compareMaps(sm1, sm2)), len(compareMaps(sm2, sm1))))
+
+	// with --suppress, directories aren't counted
+	saved := suppress
+	defer func() { suppress = saved }()
+	suppress = true
+	assert.Equal(t, totals{Site1Entries: 3, Site2Entries: 2, InCommon: 1, Site1Only: 2, Site2Only: 1},
+		summarize(sm1, sm2, len(compareMaps(sm1, sm2)), len(compareMaps(sm2, sm1))))
+}
+
+func TestRenderDownloadSummary(t *testing.T) {
+
+	var out bytes.Buffer
+	renderDownloadSummary(&out, downloadTotals{Queued: 10, Succeeded: 7, Failed: 2, Skipped: 1})
+
+	assert.Contains(t, out.String(), "Download summary:\n")
+	assert.Contains(t, out.String(), "Files to download:             10\n")
+	assert.Contains(t, out.String(), "Downloaded:                    7\n")
+	assert.Contains(t, out.String(), "Failed:                        2\n")
+	assert.Contains(t, out.String(), "Skipped:                       1\n")
+	assert.NotContains(t, out.String(), "deleted")
+
+	defer func() { deleteFiles = false }()
+	deleteFiles = true
+	out.Reset()
+	renderDownloadSummary(&out, downloadTotals{Deleted: 3, DeletedDirs: 1})
+	assert.Contains(t, out.String(), "Files deleted:                 3\n")
+	assert.Contains(t, out.String(), "Directories deleted:           1\n")
+}
+
+func TestRenderDryRun(t *testing.T) {
+
+	siteMap := syncedmap.New(map[string]fileEntry{
+		"dir1/":            {URL: "dir1/", Size: -1},
+		"dir1/file1":       {URL: "dir1/file1", Size: 1024},
+		"file2":            {URL: "file2", Size: 512},
+		"file3":            {URL: "file3", Size: -1},
+		"file4" + dlSuffix: {URL: "file4" + dlSuffix, Size: 100},
+	})
+	filelist := []string{"dir1/", "dir1/file1", "file2", "file3", "file4" + dlSuffix}
+
+	var out bytes.Buffer
+	renderDryRun(&out, siteMap, filelist)
+
+	assert.Contains(t, out.String(), "Files to download: 3\n")
+	assert.Contains(t, out.String(), "Total size:        1.5 KiB (1536 bytes)\n")
+	assert.Contains(t, out.String(), "plus 1 files of unknown size\n")
+
+	out.Reset()
+	renderDryRun(&out, siteMap, []string{"file3"})
+	assert.Contains(t, out.String(), "Files to download: 1\n")
+	assert.Contains(t, out.String(), "Total size:        unknown")
+}
+
+func TestHumanSize(t *testing.T) {
+
+	assert.Equal(t, "0 B", humanSize(0))
+	assert.Equal(t, "1023 B", humanSize(1023))
+	assert.Equal(t, "1.0 KiB", humanSize(1024))
+	assert.Equal(t, "1.5 MiB", humanSize(1536*1024))
+	assert.Equal(t, "400.0 GiB", humanSize(400*1024*1024*1024))
+}
+
+func testSites() []*site {
+	return []*site{
+		{Name: "A", URL: "/a", Map: syncedmap.New(map[string]fileEntry{"file1": {URL: "file1", Size: 1}, "file2": {URL: "file2", Size: 2}})},
+		{Name: "B", URL: "/b", Map: syncedmap.New(map[string]fileEntry{"file1": {URL: "file1", Size: 1}, "file3": {URL: "file3", Size: 3}})},
+		{Name: "C", URL: "/c", Map: syncedmap.New(map[string]fileEntry{"file1": {URL: "file1", Size: 1}, "file2": {URL: "file2", Size: 2}, "file3": {URL: "file3", Size: 3}})},
+	}
+}
+
+func TestCompareAllSites(t *testing.T) {
+
+	result := compareAllSites(testSites())
+
+	assert.Equal(t, []siteSummary{{Name: "A", URL: "/a"}, {Name: "B", URL: "/b"}, {Name: "C", URL: "/c"}}, result.Sites)
+	if assert.Len(t, result.Missing, 3) {
+		assert.Equal(t, []diffEntry{{Path: "file3", fileEntry: fileEntry{URL: "file3", Size: 3}}}, result.Missing[0].Missing)
+		assert.Equal(t, []diffEntry{{Path: "file2", fileEntry: fileEntry{URL: "file2", Size: 2}}}, result.Missing[1].Missing)
+		assert.Equal(t, []diffEntry{}, result.Missing[2].Missing)
+	}
+}
+
+func TestRenderMulti(t *testing.T) {
+
+	defer func() { outputFormat = "text" }()
+	result := compareAllSites(testSites())
+
+	var out bytes.Buffer
+	outputFormat = "text"
+	assert.Nil(t, renderMulti(&out, result))
+	assert.Equal(t, "Files/directories missing from A:\n=================================\n\nfile3\n\n\n"+
+		"Files/directories missing from B:\n=================================\n\nfile2\n\n\n"+
+		"Files/directories missing from C:\n=================================\n\n\n\n", out.String())
+
+	out.Reset()
+	outputFormat = "csv"
+	assert.Nil(t, renderMulti(&out, result))
+	assert.Equal(t, "path,missing_from,size\nfile3,A,3\nfile2,B,2\n", out.String())
+
+	out.Reset()
+	outputFormat = "json"
+	assert.Nil(t, renderMulti(&out, result))
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Len(t, decoded["sites"], 3)
+	assert.Len(t, decoded["missing"], 3)
+
+	out.Reset()
+	outputFormat = "quiet"
+	assert.Nil(t, renderMulti(&out, result))
+	assert.Equal(t, "A\tfile3\nB\tfile2\n", out.String())
+}
+
+func TestHasDifferences(t *testing.T) {
+
+	assert.True(t, testComparison().hasDifferences())
+
+	same := comparison{Site1Only: []diffEntry{}, Site2Only: []diffEntry{},
+		FetchErrors: []fetchError{{URL: "http://someurl.com/dir1/", Err: fmt.Errorf("timed out")}}}
+	assert.False(t, same.hasDifferences())
+
+	same.ChecksumDiffs = []checksumDiff{{Name: "file2", Site1: "abc", Site2: "def"}}
+	assert.True(t, same.hasDifferences())
+
+	multi := compareAllSites(testSites())
+	assert.True(t, multi.hasDifferences())
+	multi.Missing = []missingFrom{{Missing: []diffEntry{}}}
+	assert.False(t, multi.hasDifferences())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// runReport is what --notify-webhook and --metrics-file are told once the
+// run's over, whichever way it ended: what was compared, what was different,
+// what was downloaded or uploaded, how long it all took, and the exit status -
+// with the last error, if there was one.
+type runReport struct {
+	Sites         []siteReport    `json:"sites"`
+	Differences   int             `json:"differences"`
+	Downloads     *downloadTotals `json:"downloads,omitempty"`
+	DownloadBytes int64           `json:"download_bytes,omitempty"`
+	Uploads       *downloadTotals `json:"uploads,omitempty"`
+	Finished      time.Time       `json:"finished,omitzero"`
+	Seconds       float64         `json:"duration_seconds"`
+	ExitStatus    int             `json:"exit_status"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// siteReport is the part of a runReport for one site.
+type siteReport struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Entries int    `json:"entries"`
+	Files   int    `json:"files"`
+}
+
+// exit ends the run with code, after finish.
+func exit(code int) {
+	finish(code)
+	os.Exit(code)
+}
+
+// finish completes the report, now the run's over with code, and sends it to
+// the --notify-webhook and the --metrics-file.
+func finish(code int) {
+
+	if notifyURL == "" && metricsFile == "" {
+		return
+	}
+
+	completeReport(code, time.Now())
+	notify(report)
+	if metricsFile != "" {
+		if err := writeMetrics(metricsFile, report); err != nil {
+			slog.Warn("unable to write metrics file", "path", metricsFile, "err", err)
+		}
+	}
+
+}
+
+// completeReport fills in the rest of report at the end of the run: how long
+// it took, how it ended, what's in each site's map, and how much was
+// downloaded.
+func completeReport(code int, now time.Time) {
+
+	report.Finished = now
+	report.Seconds = now.Sub(runStarted).Round(time.Millisecond).Seconds()
+	report.ExitStatus = code
+	if code != 0 && code != exitDiff {
+		report.Error, _ = lastError.Load().(string)
+	}
+	report.DownloadBytes = dlProgress.Bytes.Load()
+
+	report.Sites = nil
+	for _, s := range sites {
+		sr := siteReport{Name: s.Name, URL: redactURL(s.URL)}
+		if s.Map != nil {
+			sr.Entries = s.Map.Len()
+			for _, k := range s.Map.Keys() {
+				if !strings.HasSuffix(k, "/") {
+					sr.Files++
+				}
+			}
+		}
+		report.Sites = append(report.Sites, sr)
+	}
+
+}
+
+// Status is a word or two on how the run ended, for a --notify-template that
+// wants one.
+func (r runReport) Status() string {
+
+	switch r.ExitStatus {
+	case 0:
+		return "ok"
+	case exitDiff:
+		return "differences found"
+	case 130:
+		return "interrupted"
+	default:
+		return fmt.Sprintf("failed (exit status %d)", r.ExitStatus)
+	}
+
+}
@@ -0,0 +1,308 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ScanOptions change what ScanSite looks at, and what it keeps. The zero value
+// walks the whole of a site, one listing at a time, and keeps everything in it.
+type ScanOptions struct {
+	// IgnoreText holds link texts that aren't entries - the column headings
+	// and "Parent Directory" links of directory listings, and so on
+	IgnoreText map[string]bool
+
+	// IgnorePatterns leave out any link whose text or href matches one of them
+	IgnorePatterns []*regexp.Regexp
+
+	// Include and Exclude are glob patterns deciding which entries make it
+	// into the map - see Wanted
+	Include, Exclude []string
+
+	// Extensions, if it isn't empty, holds the only (lowercased, dot-less)
+	// extensions a file can have to make it into the map
+	Extensions map[string]bool
+
+	// MaxDepth is how many directories deep a walk goes. Directories at
+	// MaxDepth are still recorded, but nothing inside them is. 0 means there's
+	// no limit
+	MaxDepth int
+
+	// Concurrency is how many directory listings a web server's walk fetches
+	// at once. Anything less than 1 counts as 1
+	Concurrency int
+
+	// S3Region, S3Endpoint and S3Profile override what the standard AWS
+	// configuration (environment, shared config files, instance roles...)
+	// would otherwise pick for an S3 site. S3Endpoint is for S3 compatible
+	// services, like MinIO
+	S3Region, S3Endpoint, S3Profile string
+
+	// Failed is told about every URL a walk couldn't retrieve. The walk
+	// carries on with the rest of the tree, so one bad directory doesn't throw
+	// away everything else that's been found. If it's nil, they're only logged
+	Failed func(url string, err error)
+}
+
+// Fail deals with a URL that a walk couldn't retrieve, passing it on to Failed.
+// A request that was abandoned because ctx was cancelled didn't really fail,
+// so it isn't reported at all.
+func (o ScanOptions) Fail(url string, err error) {
+
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if o.Failed == nil {
+		slog.Debug("unable to retrieve, skipping it", "url", url, "err", err)
+		return
+	}
+
+	o.Failed(url, err)
+
+}
+
+// Wanted reports whether the entry with key (a trailing slash for directories)
+// belongs in the map - whether it passes the Include and Exclude patterns, and
+// if it's a file, the Extensions.
+func (o ScanOptions) Wanted(key string) bool {
+	return !o.Excluded(key) && o.included(key) && o.extensionAllowed(key)
+}
+
+// Excluded reports whether the entry with key, or any directory it's in,
+// matches one of the Exclude patterns. Nothing in an excluded directory is
+// wanted, so a walk needn't look inside it at all.
+func (o ScanOptions) Excluded(key string) bool {
+
+	for _, pattern := range o.Exclude {
+		for k := key; k != ""; k = parentKey(k) {
+			if globMatch(pattern, k) {
+				return true
+			}
+		}
+	}
+
+	return false
+
+}
+
+// included reports whether the entry with key passes the Include patterns -
+// with none, everything does.
+func (o ScanOptions) included(key string) bool {
+
+	if len(o.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range o.Include {
+		if globMatch(pattern, key) {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// parentKey is the key of the directory that the entry with key is in, or ""
+// at the top of the site.
+func parentKey(key string) string {
+	return key[:strings.LastIndex(strings.TrimSuffix(key, "/"), "/")+1]
+}
+
+// extensionAllowed reports whether the entry at relpath passes the Extensions
+// filter. Directories always pass, so the files inside them can still be found.
+func (o ScanOptions) extensionAllowed(relpath string) bool {
+
+	if len(o.Extensions) == 0 || strings.HasSuffix(relpath, "/") {
+		return true
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(relpath), "."))
+	return o.Extensions[ext]
+
+}
+
+// globMatch matches a pattern against the whole relative path of the entry
+// with key, or - when the pattern has no "/" in it - against just the last
+// element of the path. A pattern ending in "/" only matches directories.
+func globMatch(pattern, key string) bool {
+
+	if strings.HasSuffix(pattern, "/") {
+		if !strings.HasSuffix(key, "/") {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	relpath := strings.TrimSuffix(key, "/")
+	if !strings.Contains(pattern, "/") {
+		relpath = path.Base(relpath)
+	}
+
+	matched, _ := path.Match(pattern, relpath)
+	return matched
+
+}
+
+// ignoreLink reports whether an anchor should be left out of the site map,
+// either because its text is in IgnoreText, or because its text or href
+// matches one of the IgnorePatterns.
+func (o ScanOptions) ignoreLink(text, href string) bool {
+
+	if o.IgnoreText[text] {
+		return true
+	}
+
+	for _, re := range o.IgnorePatterns {
+		if re.MatchString(text) || re.MatchString(href) {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// Backend fills in a site's map by walking its tree. There's one for each kind
+// of site - a web server's HTML directory listings, a WebDAV share, an FTP
+// server, an S3 bucket, or a local filesystem - so ScanSite doesn't need to
+// know which it's dealing with. A Walk stops early once ctx is cancelled,
+// leaving the map with whatever it's found so far, and reports anything it
+// can't retrieve with opts.Fail.
+type Backend interface {
+	Walk(ctx context.Context, s *Site, opts ScanOptions)
+}
+
+// backends is the registry of Backends, keyed by URL scheme. Each one registers
+// itself, in an init function next to its code.
+var backends = make(map[string]Backend)
+
+// siteTypes maps the names that a site's type can be given as to the scheme of
+// the backend that handles it, for when the URL alone doesn't say (like WebDAV
+// shares, which have http:// URLs).
+var siteTypes = map[string]string{"html": "http", "webdav": "dav", "ftp": "ftp", "s3": "s3", "local": "file"}
+
+// RegisterBackend adds a Backend to the registry, to handle URLs with the given
+// scheme. It's meant to be called from an init function, before any walks
+// start.
+func RegisterBackend(scheme string, b Backend) {
+	backends[scheme] = b
+}
+
+// Schemes lists the URL schemes there are Backends for, other than local
+// paths, in order - the ones a site's URL can begin with.
+func Schemes() []string {
+
+	var schemes []string
+	for scheme := range backends {
+		if scheme != "file" {
+			schemes = append(schemes, scheme)
+		}
+	}
+	sort.Strings(schemes)
+
+	return schemes
+
+}
+
+// BackendFor looks up the Backend for a site - by its type, if it was given
+// one, or else by the scheme of its URL.
+func BackendFor(s *Site) (Backend, error) {
+
+	scheme := URLScheme(s.URL)
+	if s.Type != "" {
+		scheme = siteTypes[s.Type]
+	}
+
+	b, exists := backends[scheme]
+	if !exists {
+		return nil, fmt.Errorf("ERROR: don't know how to walk <%s> - no backend for %s://", s.URL, scheme)
+	}
+
+	return b, nil
+
+}
+
+// SiteType works out the type of a site from its configured type and URL. A
+// dav:// or davs:// URL means WebDAV, and is turned into the http:// or https://
+// URL that's actually used to talk to the server.
+func SiteType(configured, u string) (string, string, error) {
+
+	t := strings.ToLower(configured)
+	if _, exists := siteTypes[t]; t != "" && !exists {
+		return "", "", fmt.Errorf("ERROR: unknown site type <%s> - must be html, webdav, ftp, s3, or local", configured)
+	}
+
+	switch URLScheme(u) {
+	case "dav":
+		return "webdav", "http://" + u[len("dav://"):], nil
+	case "davs":
+		return "webdav", "https://" + u[len("davs://"):], nil
+	}
+
+	return t, u, nil
+
+}
+
+// ScanSite walks s with whichever Backend suits it, filling in s.Map (which it
+// makes, if s doesn't have one yet) and s.Counter as it goes, and recording how
+// long it took in s.Elapsed. Cancelling ctx stops the walk where it is. Any
+// part of the site that can't be retrieved is reported to opts.Failed, and the
+// walk carries on without it.
+func ScanSite(ctx context.Context, s *Site, opts ScanOptions) {
+
+	if s.Map == nil {
+		s.Map = new(Map)
+	}
+
+	started := time.Now()
+	b, err := BackendFor(s)
+	if err != nil {
+		opts.Fail(s.URL, err)
+	} else {
+		b.Walk(ctx, s, opts)
+	}
+	s.Elapsed = time.Since(started)
+
+}
+
+// listingFailed checks the result of asking for a directory listing. If it
+// didn't work, it's reported with opts.Fail (closing the response body, if
+// there is one), and true is returned.
+func listingFailed(opts ScanOptions, urltoget string, response *http.Response, err error) bool {
+
+	switch {
+	case err != nil:
+		opts.Fail(urltoget, err)
+		return true
+	case response == nil:
+		opts.Fail(urltoget, fmt.Errorf("response is empty"))
+		return true
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		response.Body.Close()
+		opts.Fail(urltoget, fmt.Errorf("authentication failed (%d %s) - check the user, password, or token for this site",
+			response.StatusCode, http.StatusText(response.StatusCode)))
+		return true
+	case response.StatusCode >= 300 && response.StatusCode <= 399:
+		response.Body.Close()
+		opts.Fail(urltoget, fmt.Errorf("server redirected to <%s>, and redirects aren't being followed", response.Header.Get("Location")))
+		return true
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		// an error page isn't a directory listing, so don't go looking for links in it
+		response.Body.Close()
+		opts.Fail(urltoget, fmt.Errorf("server returned %d %s", response.StatusCode, http.StatusText(response.StatusCode)))
+		return true
+	}
+
+	return false
+
+}
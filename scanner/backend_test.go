@@ -0,0 +1,181 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendFor(t *testing.T) {
+
+	tests := []struct {
+		s    *Site
+		want Backend
+	}{
+		{&Site{URL: "http://someurl.com/"}, htmlBackend{}},
+		{&Site{URL: "HTTPS://someurl.com/"}, htmlBackend{}},
+		{&Site{URL: "/some/path"}, fsBackend{}},
+		{&Site{URL: "httpdocs/"}, fsBackend{}},
+		{&Site{URL: "ftp://someurl.com/"}, ftpBackend{}},
+		{&Site{URL: "s3://bucket/prefix"}, s3Backend{}},
+		{&Site{URL: "http://someurl.com/", Type: "webdav"}, davBackend{}},
+		{&Site{URL: "http://someurl.com/", Type: "html"}, htmlBackend{}},
+	}
+
+	for _, test := range tests {
+		b, err := BackendFor(test.s)
+		assert.Nil(t, err, test.s.URL)
+		assert.IsType(t, test.want, b, test.s.URL)
+	}
+
+	_, err := BackendFor(&Site{URL: "gopher://someurl.com/"})
+	assert.NotNil(t, err)
+}
+
+func TestBackendSchemes(t *testing.T) {
+
+	assert.Equal(t, []string{"dav", "davs", "ftp", "http", "https", "s3"}, Schemes())
+
+	// and every one of them makes it past ValidateURL
+	for _, scheme := range Schemes() {
+		assert.Nil(t, webhandler.ValidateURL(scheme+"://someurl.com/path/", Schemes()...), scheme)
+	}
+}
+
+func TestIsHTTP(t *testing.T) {
+
+	assert.True(t, IsHTTP("http://someurl.com/"))
+	assert.True(t, IsHTTP("HTTPS://someurl.com/"))
+	assert.False(t, IsHTTP("httpdocs/"))
+	assert.False(t, IsHTTP("ftp://someurl.com/"))
+}
+
+// the same directory comes out with the same key, however the site describes it
+func TestEntryKey(t *testing.T) {
+
+	tests := []struct {
+		source string
+		parent string
+		name   string
+		isDir  bool
+		want   string
+	}{
+		// Apache puts the "/" in the anchor text, lighttpd leaves it off
+		{"apache", "", linkName("dir1/", "dir1/"), true, "dir1/"},
+		{"lighttpd", "", linkName("dir1/", "dir1"), true, "dir1/"},
+		{"apache nested", "dir1/", linkName("dir2/", "dir2/"), true, "dir1/dir2/"},
+		{"lighttpd nested", "dir1/", linkName("dir2/", "dir2"), true, "dir1/dir2/"},
+		{"apache file", "dir1/", linkName("file1.mp3", "file1.mp3"), false, "dir1/file1.mp3"},
+		// the filesystem gives relative paths, with no slash at all
+		{"filesystem", "", "dir1", true, "dir1/"},
+		{"filesystem nested", "", "dir1/dir2", true, "dir1/dir2/"},
+		{"filesystem file", "", "dir1/file1.mp3", false, "dir1/file1.mp3"},
+		// and there's never more than the one
+		{"doubled", "", "dir1//", true, "dir1/"},
+		{"file with a slash", "dir1/", "file1.mp3/", false, "dir1/file1.mp3"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, EntryKey(test.parent, test.name, test.isDir), test.source)
+	}
+}
+
+func TestIncluded(t *testing.T) {
+
+	// no filters - everything goes
+	var opts ScanOptions
+	assert.True(t, opts.included("anything.txt"))
+
+	opts.Include = []string{"*.mp4", "*.mkv"}
+	assert.True(t, opts.included("movie.mp4"))
+	assert.True(t, opts.included("dir1/dir2/movie.mkv"))
+	assert.False(t, opts.included("notes.txt"))
+	assert.False(t, opts.included("dir1/"))
+
+	// exclude wins over include
+	opts.Exclude = []string{"samples/*", "*.part.mp4"}
+	assert.False(t, opts.Wanted("samples/movie.mp4"))
+	assert.False(t, opts.Wanted("dir1/movie.part.mp4"))
+	assert.True(t, opts.Wanted("movies/movie.mp4"))
+
+	// exclude on its own leaves everything else in
+	opts.Include = nil
+	assert.True(t, opts.Wanted("notes.txt"))
+	assert.False(t, opts.Wanted("samples/notes.txt"))
+}
+
+func TestExcluded(t *testing.T) {
+
+	var opts ScanOptions
+	assert.False(t, opts.Excluded("tmp/"))
+
+	// a trailing slash only matches directories
+	opts.Exclude = []string{"tmp/"}
+	assert.True(t, opts.Excluded("tmp/"))
+	assert.True(t, opts.Excluded("dir1/tmp/"))
+	assert.False(t, opts.Excluded("tmp"))
+	assert.False(t, opts.Excluded("tmp.txt"))
+
+	// and everything in an excluded directory is excluded with it
+	assert.True(t, opts.Excluded("tmp/notes.txt"))
+	assert.True(t, opts.Excluded("dir1/tmp/dir2/"))
+	assert.False(t, opts.Wanted("tmp/notes.txt"))
+
+	opts.Exclude = []string{"dir1/cache"}
+	assert.True(t, opts.Excluded("dir1/cache/"))
+	assert.True(t, opts.Excluded("dir1/cache/file1.mp3"))
+	assert.False(t, opts.Excluded("dir2/cache/file1.mp3"))
+}
+
+func TestExtensionAllowed(t *testing.T) {
+
+	var opts ScanOptions
+	assert.True(t, opts.extensionAllowed("notes.txt"))
+
+	opts.Extensions = map[string]bool{"mp3": true, "flac": true, "jpg": true}
+	assert.True(t, opts.extensionAllowed("song.mp3"))
+	assert.True(t, opts.extensionAllowed("dir1/Song.MP3"))
+	assert.True(t, opts.extensionAllowed("cover.JPG"))
+	assert.False(t, opts.extensionAllowed("notes.txt"))
+	assert.False(t, opts.extensionAllowed("README"))
+
+	// directories are always walked
+	assert.True(t, opts.extensionAllowed("dir1/"))
+}
+
+func TestScanOptionsFail(t *testing.T) {
+
+	var errs ErrorList
+	opts := ScanOptions{Failed: errs.Add}
+
+	opts.Fail("http://someurl.com/dir1/", fmt.Errorf("connection reset by peer"))
+
+	// an abandoned request isn't a failure
+	opts.Fail("http://someurl.com/dir2/", context.Canceled)
+
+	if list := errs.List(); assert.Len(t, list, 1) {
+		assert.Equal(t, "http://someurl.com/dir1/", list[0].URL)
+	}
+}
+
+func TestScanSite(t *testing.T) {
+
+	base := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(base, "dir1"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(base, "dir1", "file1"), []byte("one"), 0644))
+
+	s := &Site{Name: "Site 1", URL: base}
+	ScanSite(context.Background(), s, ScanOptions{})
+
+	assert.ElementsMatch(t, []string{"dir1/", "dir1/file1"}, s.Map.Keys())
+	assert.Equal(t, "1 files, 1 dirs", s.Counter.Split())
+
+	var errs ErrorList
+	ScanSite(context.Background(), &Site{URL: "gopher://someurl.com/"}, ScanOptions{Failed: errs.Add})
+	assert.Len(t, errs.List(), 1)
+}
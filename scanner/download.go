@@ -0,0 +1,868 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/davexre/sitescan/checksum"
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/davexre/synceddata"
+	"golang.org/x/time/rate"
+)
+
+// The workers that Download's lines come from are numbered from 1. These are
+// the numbers given to DownloadOptions.Log for lines that don't come from one
+// of them.
+const (
+	// BatchWorker is a web server's downloads, which all go as one batch
+	BatchWorker = 0
+
+	// NoWorker is the download as a whole
+	NoWorker = -1
+)
+
+// DownloadOptions say how Download fetches files, and where it reports what
+// it's doing. The zero value isn't much use - at the least, Workers and the
+// modes need setting.
+type DownloadOptions struct {
+	// Source is the map of the site the files are coming from, for their
+	// sizes - to tell whether a file's already been downloaded, and how many
+	// bytes there are to go. Without it, everything is fetched
+	Source *Map
+
+	// Site carries everything webhandler needs to talk to the site
+	Site webhandler.Options
+
+	// Workers is how many files are fetched at once
+	Workers int
+
+	// PartialSuffix goes on the end of a file's name while it's being fetched,
+	// so an unfinished one is never mistaken for the real thing - and can be
+	// picked up where it left off, next time
+	PartialSuffix string
+
+	// FileMode and DirMode are the permissions downloaded files and the
+	// directories made for them get, less whatever Umask takes away
+	FileMode, DirMode, Umask os.FileMode
+
+	// Force fetches files that are already there. DryRun only says what would
+	// be fetched. Verify checks each file comes out the size the site says it
+	// should be, and PreserveTimes gives it the site's modification time
+	Force, DryRun, Verify, PreserveTimes bool
+
+	// Bandwidth, if it isn't nil, is shared by every download
+	Bandwidth *rate.Limiter
+
+	// UpdateInterval is how often the web server downloads in flight are
+	// checked on
+	UpdateInterval time.Duration
+
+	// VerifyChecksums checks each download against the checksum file published
+	// next to it, named with ChecksumSuffix - or against Manifest, if there is
+	// one, a file of ManifestFormat on the site. Either way, they're
+	// ChecksumAlgo checksums. A download that doesn't match is deleted, and
+	// fetched again up to ChecksumRetries times
+	VerifyChecksums bool
+	ChecksumSuffix  string
+	Manifest        string
+	ManifestFormat  string
+	ChecksumAlgo    string
+	ChecksumRetries int
+
+	// Downloaded, if it isn't nil, is called with the full path of each file
+	// once it's been downloaded
+	Downloaded func(ctx context.Context, file string)
+
+	// Log, if it isn't nil, is told what each worker is up to, a line at a
+	// time. Verbose has it told about the files that are passed over, too
+	Log     func(worker int, msg string)
+	Verbose bool
+
+	// Progress is kept up to date as the downloads go, for a progress display
+	// running alongside. If it's nil, Download keeps its own
+	Progress *Progress
+}
+
+// Progress is how far a Download has got. It's safe to read while the
+// downloads are running.
+type Progress struct {
+	// Total is how many files there are to download, and TotalBytes how many
+	// bytes - or -1 if the size of any of them isn't known
+	Total      atomic.Int64
+	TotalBytes atomic.Int64
+
+	// Finished counts the files that have been fetched, and Bytes how big they
+	// were. SkippedBytes counts the ones that were already there
+	Finished     synceddata.Counter
+	Bytes        atomic.Int64
+	SkippedBytes atomic.Int64
+
+	// Errors collects the files that couldn't be fetched
+	Errors ErrorList
+
+	inFlight inFlightList
+}
+
+// InFlight returns the web server downloads that have started, but not
+// finished yet.
+func (p *Progress) InFlight() []*grab.Response {
+	return p.inFlight.List()
+}
+
+// Totals is what became of the files a Download was given.
+type Totals struct {
+	Queued, Succeeded, Failed, Skipped int
+}
+
+// inFlightList holds the downloads that have started, but not finished yet.
+// Like ErrorList, it's protected by a Mutex.
+type inFlightList struct {
+	m     sync.Mutex
+	resps []*grab.Response
+}
+
+// Set replaces the list with resps.
+func (l *inFlightList) Set(resps []*grab.Response) {
+	l.m.Lock()
+	l.resps = append([]*grab.Response(nil), resps...)
+	l.m.Unlock()
+}
+
+// List returns the downloads in flight.
+func (l *inFlightList) List() []*grab.Response {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return append([]*grab.Response(nil), l.resps...)
+}
+
+// linkFile is how a local download tries to hard link a file before falling
+// back to copying it - a variable, so tests can make it fail
+var linkFile = os.Link
+
+// downloader is a Download under way - its options, where it's going from and
+// to, and the checksums from the manifest, if there is one.
+type downloader struct {
+	DownloadOptions
+
+	localpath, remotepath string
+	progress              *Progress
+	sums                  map[string]string
+}
+
+// Download fetches each of files (relative to remotepath, which may be a web
+// server, an FTP server or a local path) into localpath, until it's done or ctx
+// is cancelled. A file that fails is recorded in the Progress, and the rest
+// carry on. Anything that's only partly fetched when ctx is cancelled is kept,
+// with the PartialSuffix, so the next Download can pick up where this one left
+// off. The error is only for a manifest that can't be loaded - nothing is
+// downloaded without it.
+func Download(ctx context.Context, localpath, remotepath string, files []string, opts DownloadOptions) (Totals, error) {
+
+	if !strings.HasSuffix(localpath, "/") {
+		localpath = localpath + "/"
+	}
+	if !strings.HasSuffix(remotepath, "/") {
+		remotepath = remotepath + "/"
+	}
+
+	d := &downloader{DownloadOptions: opts, localpath: localpath, remotepath: remotepath, progress: opts.Progress}
+	if d.progress == nil {
+		d.progress = new(Progress)
+	}
+	d.Workers = max(d.Workers, 1)
+
+	files = InsideBase(localpath, files)
+
+	total, totalBytes := 0, int64(0)
+	for _, file := range files {
+		if !strings.HasSuffix(file, "/") && !strings.HasSuffix(file, d.PartialSuffix) {
+			total++
+			totalBytes = d.addSize(totalBytes, file)
+		}
+	}
+	d.progress.Total.Store(int64(total))
+	d.progress.TotalBytes.Store(totalBytes)
+
+	if d.Manifest != "" && !d.DryRun {
+		var err error
+		d.sums, err = d.loadManifest(ctx)
+		if err != nil {
+			return Totals{Queued: total}, err
+		}
+	}
+
+	d.run(ctx, files)
+
+	// a file that didn't match its checksum has been deleted, so it can just be
+	// downloaded again
+	for round := 1; round <= d.ChecksumRetries && ctx.Err() == nil; round++ {
+
+		corrupt := d.progress.Errors.Remove(func(e FetchError) bool { return errors.Is(e.Err, errChecksumMismatch) })
+		if len(corrupt) == 0 {
+			break
+		}
+
+		var retries []string
+		for _, e := range corrupt {
+			retries = append(retries, strings.TrimPrefix(e.URL, remotepath))
+		}
+		d.logf(NoWorker, "Retrying %d files that didn't match their checksums (retry %d of %d)", len(retries), round, d.ChecksumRetries)
+
+		d.run(ctx, retries)
+
+	}
+
+	slog.Debug("Download: exiting")
+
+	totals := Totals{Queued: total, Succeeded: d.progress.Finished.Read(), Failed: len(d.progress.Errors.List())}
+	totals.Skipped = totals.Queued - totals.Succeeded - totals.Failed
+
+	return totals, nil
+
+}
+
+// addSize adds the size of file at the Source to total, for Progress.TotalBytes.
+// If either isn't known, neither is the total, and it's -1.
+func (d *downloader) addSize(total int64, file string) int64 {
+
+	if total < 0 || d.Source == nil {
+		return -1
+	}
+	entry, exists := d.Source.Get(file)
+	if !exists || entry.Size < 0 || entry.SizeApprox {
+		return -1
+	}
+
+	return total + entry.Size
+
+}
+
+// logf hands a line about what worker is up to to Log, if there is one.
+func (d *downloader) logf(worker int, format string, args ...interface{}) {
+	if d.Log != nil {
+		d.Log(worker, fmt.Sprintf(format, args...))
+	}
+}
+
+// failed records that file couldn't be fetched.
+func (d *downloader) failed(file string, err error) {
+	d.progress.Errors.Add(d.remotepath+file, err)
+}
+
+// InsideBase drops anything from files that would be saved somewhere outside
+// localpath, with a warning. The names come from the other site - a server can
+// put "../" in a link - so without this, a broken or malicious one could have
+// us write files anywhere we're allowed to.
+func InsideBase(localpath string, files []string) []string {
+
+	var safe []string
+	for _, file := range files {
+		if _, ok := LocalTarget(localpath, file); !ok {
+			slog.Warn("skipping - it would be saved outside the local directory", "file", file, "dir", localpath)
+			continue
+		}
+		safe = append(safe, file)
+	}
+
+	return safe
+
+}
+
+// LocalTarget works out where file gets saved under localpath, and reports
+// whether that's actually inside it.
+func LocalTarget(localpath, file string) (string, bool) {
+
+	base := filepath.Clean(localpath)
+	target := filepath.Clean(filepath.Join(base, filepath.FromSlash(file)))
+
+	rel, err := filepath.Rel(base, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return target, false
+	}
+
+	return target, true
+
+}
+
+// run hands files to batch, for a web server, or else to a pool of Workers
+// workers, and waits for them to finish.
+func (d *downloader) run(ctx context.Context, files []string) {
+
+	if IsHTTP(d.remotepath) {
+
+		slog.Debug("Download: handing files to the batch", "files", len(files))
+		d.batch(ctx, files)
+
+	} else {
+
+		fileschan := make(chan string, len(files))
+
+		for _, file := range files {
+			slog.Debug("Download: adding to queue", "file", file)
+			fileschan <- file
+		}
+		close(fileschan)
+
+		var wg sync.WaitGroup
+		for i := 1; i <= d.Workers; i++ {
+			slog.Debug("Download: adding thread to worker pool", "thread", i)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.worker(ctx, i, fileschan)
+			}()
+		}
+
+		slog.Debug("Download: waiting")
+		wg.Wait()
+
+	}
+
+}
+
+// fetched is a file that's been fetched into its PartialSuffix file, along with
+// what its source told us about it, ready for finish.
+type fetched struct {
+	file string
+
+	// the size the finished file should be, for Verify. -1 if we don't know
+	expected int64
+
+	// when the source says the file was last modified, for PreserveTimes. Zero
+	// if we don't know
+	modTime time.Time
+
+	// the permissions the finished file gets. A local copy keeps the source's,
+	// and a hard link (linked) already has them
+	mode   os.FileMode
+	linked bool
+
+	// the checksum the source publishes for the file, with VerifyChecksums or
+	// a Manifest. "" if there isn't one
+	sum string
+}
+
+// worker fetches files from a local path or FTP server, one at a time, until
+// fileschan runs dry, or ctx is cancelled. Web servers are handled by batch
+// instead.
+func (d *downloader) worker(ctx context.Context, id int, fileschan <-chan string) {
+
+	localpath, remotepath := d.localpath, d.remotepath
+
+	// a file that fails is recorded, and we move on to the next one - there's
+	// no sense in abandoning the rest of the queue over it
+	failures := 0
+
+	for file := range fileschan {
+
+		if ctx.Err() != nil {
+			d.logf(id, "interrupted")
+			break
+		}
+
+		if strings.HasSuffix(file, "/") {
+			if d.Verbose {
+				d.logf(id, "skipping directory %s", file)
+			}
+			continue
+		}
+
+		if strings.HasSuffix(file, d.PartialSuffix) {
+			if d.Verbose {
+				d.logf(id, "skipping download file %s", file)
+			}
+			continue
+		}
+
+		if d.alreadyDownloaded(ctx, file) {
+			d.logf(id, "already downloaded: %s", file)
+			continue
+		}
+
+		d.logf(id, "starting %s", file)
+
+		if d.DryRun {
+			continue
+		}
+
+		f := fetched{file: file, expected: -1, mode: d.FileMode &^ d.Umask}
+
+		if IsFTP(remotepath) {
+
+			d.logf(id, "downloading: %s", file)
+
+			size, err := d.ftpDownload(ctx, remotepath, file, localpath+file+d.PartialSuffix)
+			if errors.Is(err, context.Canceled) {
+				d.logf(id, "interrupted: %s", file)
+				break
+			}
+			if err != nil {
+				d.logf(id, "error downloading: %s: %v", remotepath+file, err)
+				failures++
+				d.failed(file, err)
+				continue
+			}
+			d.logf(id, "finished: %s", file)
+			f.expected = size
+
+		} else {
+
+			targetfile := localpath + file
+			targetdir := filepath.Dir(targetfile)
+
+			if targetdir == "." {
+				d.logf(id, "target dir yields no path: %s", targetdir)
+				failures++
+				d.failed(file, fmt.Errorf("target dir yields no path: %s", targetdir))
+				continue
+			}
+
+			// since we're a local filesystem copy, and not HTTP, we can't trust
+			// a filecopy to pick up where we left off. So, remove the
+			// PartialSuffix file, if it exists. If it doesn't, no biggie - we
+			// can ignore the error
+			err := os.Remove(targetfile + d.PartialSuffix)
+			if d.Verbose && err == nil {
+				d.logf(id, "removed stale dl file %s", targetfile+d.PartialSuffix)
+			}
+
+			if d.Verbose {
+				d.logf(id, "stat'ing %s", targetdir)
+			}
+
+			_, err = os.Stat(targetdir)
+			if os.IsNotExist(err) {
+				err := os.MkdirAll(targetdir, d.DirMode)
+				if err != nil {
+					d.logf(id, "error making targetdir: %s", targetdir)
+					d.logf(id, "error: %s", err)
+					failures++
+					d.failed(file, err)
+					continue
+				}
+			}
+
+			// Can we link it? (a trick, if the file lives in this filesystem)
+			err = linkFile(remotepath+file, targetfile+d.PartialSuffix) // we should be so lucky...
+			if err == nil {
+				f.linked = true
+				if d.Verbose {
+					d.logf(id, "successfully linked %s", targetfile)
+				}
+			}
+			if err != nil {
+				// actually copy the file, then
+				err = d.copyFile(ctx, remotepath+file, targetfile+d.PartialSuffix)
+				if err != nil {
+					d.logf(id, "error copying file: %s", remotepath+file)
+					d.logf(id, "error: %s", err)
+					failures++
+					d.failed(file, err)
+					continue
+				}
+			}
+
+			if info, err := os.Stat(remotepath + file); err == nil {
+				f.expected = info.Size()
+				f.modTime = info.ModTime()
+				f.mode = info.Mode().Perm()
+			}
+
+		}
+
+		sum, err := d.expectedChecksum(ctx, file)
+		if err != nil {
+			d.logf(id, "error checking: %s: %v", remotepath+file, err)
+			failures++
+			d.failed(file, err)
+			continue
+		}
+		f.sum = sum
+
+		if err := d.finish(id, f); err != nil {
+			failures++
+			d.failed(file, err)
+			continue
+		}
+		if d.Downloaded != nil {
+			d.Downloaded(ctx, localpath+file)
+		}
+
+	}
+
+	d.logf(id, "done, %d failed", failures)
+
+}
+
+// batch fetches files from a web server. Rather than a new grab client for
+// every file, there's just the one, so connections are pooled and reused, and
+// its DoBatch runs up to Workers downloads at a time. Every request is made
+// with ctx, so cancelling it abandons them all - the ones under way included.
+func (d *downloader) batch(ctx context.Context, files []string) {
+
+	localpath, remotepath := d.localpath, d.remotepath
+
+	client := grab.NewClient()
+	client.HTTPClient = webhandler.NewSiteClient(d.Site)
+
+	failures := 0
+
+	var reqs []*grab.Request
+	for _, file := range files {
+
+		if strings.HasSuffix(file, "/") || strings.HasSuffix(file, d.PartialSuffix) {
+			if d.Verbose {
+				d.logf(BatchWorker, "skipping %s", file)
+			}
+			continue
+		}
+
+		if d.alreadyDownloaded(ctx, file) {
+			d.logf(BatchWorker, "already downloaded: %s", file)
+			continue
+		}
+
+		d.logf(BatchWorker, "starting %s", file)
+
+		if d.DryRun {
+			continue
+		}
+
+		req, err := grab.NewRequest(localpath+file+d.PartialSuffix, remotepath+EscapePath(file))
+		if err != nil {
+			d.logf(BatchWorker, "error downloading: %s: %v", remotepath+file, err)
+			failures++
+			d.failed(file, err)
+			continue
+		}
+		req = req.WithContext(ctx)
+		d.Site.Apply(req.HTTPRequest)
+		req.Tag = file
+
+		// if an earlier run left a partial download behind, grab asks the
+		// server for just the rest of it, with a Range header - provided
+		// the server says it accepts them. If not, it starts over. That's
+		// grab's default, so there's nothing to set for it.
+
+		// grab would set the file's time from Last-Modified on its own -
+		// leave that to PreserveTimes, like every other kind of site
+		req.IgnoreRemoteTime = true
+
+		// every download draws on the same Bandwidth
+		if d.Bandwidth != nil {
+			req.RateLimiter = d.Bandwidth
+		}
+
+		// grab would make any missing directories 0755 - make them
+		// ourselves, with DirMode
+		req.NoCreateDirectories = true
+		if err := os.MkdirAll(filepath.Dir(localpath+file), d.DirMode); err != nil {
+			d.logf(BatchWorker, "error making targetdir: %s", filepath.Dir(localpath+file))
+			d.logf(BatchWorker, "error: %s", err)
+			failures++
+			d.failed(file, err)
+			continue
+		}
+
+		if offset := partialSize(localpath + file + d.PartialSuffix); offset > 0 {
+			d.logf(BatchWorker, "resuming: %s from byte %d", file, offset)
+		} else {
+			d.logf(BatchWorker, "downloading: %s", file)
+		}
+
+		reqs = append(reqs, req)
+
+	}
+
+	if len(reqs) == 0 {
+		d.logf(BatchWorker, "done, %d failed", failures)
+		return
+	}
+
+	// responses arrive as each download starts - they're checked on every
+	// UpdateInterval, and finished off as they complete
+	respch := client.DoBatch(d.Workers, reqs...)
+	ticker := time.NewTicker(d.UpdateInterval)
+	defer ticker.Stop()
+
+	var active []*grab.Response
+	for respch != nil || len(active) > 0 {
+
+		select {
+		case resp, ok := <-respch:
+			if !ok {
+				respch = nil
+				continue
+			}
+			active = append(active, resp)
+		case <-ticker.C:
+		}
+
+		inFlight := active[:0]
+		for _, resp := range active {
+			if !resp.IsComplete() {
+				inFlight = append(inFlight, resp)
+				continue
+			}
+
+			file := resp.Request.Tag.(string)
+
+			if errors.Is(resp.Err(), context.Canceled) {
+				d.logf(BatchWorker, "interrupted: %s", file)
+				continue
+			}
+			if resp.Err() != nil {
+				d.logf(BatchWorker, "error downloading: %s: %v", resp.Request.URL(), resp.Err())
+				failures++
+				d.failed(file, resp.Err())
+				continue
+			}
+			d.logf(BatchWorker, "finished: %s", file)
+
+			f := fetched{file: file, expected: -1, mode: d.FileMode &^ d.Umask}
+			if resp.HTTPResponse != nil {
+				if resp.HTTPResponse.ContentLength >= 0 {
+					f.expected = resp.Size
+				}
+				f.modTime = lastModified(resp.HTTPResponse.Header)
+			}
+
+			sum, err := d.expectedChecksum(ctx, file)
+			if err != nil {
+				d.logf(BatchWorker, "error checking: %s: %v", remotepath+file, err)
+				failures++
+				d.failed(file, err)
+				continue
+			}
+			f.sum = sum
+
+			if err := d.finish(BatchWorker, f); err != nil {
+				failures++
+				d.failed(file, err)
+			} else if d.Downloaded != nil {
+				d.Downloaded(ctx, localpath+file)
+			}
+		}
+		active = inFlight
+		d.progress.inFlight.Set(active)
+
+	}
+
+	d.logf(BatchWorker, "done, %d failed", failures)
+
+}
+
+// finish turns a fetched file's PartialSuffix file into the real thing -
+// checking its size first, with Verify, and its checksum, if the source
+// published one - and then giving it its permissions and (with PreserveTimes)
+// its modification time. A file that's come up short stays as a PartialSuffix
+// file, so the next run can pick up where this one left off, but one with the
+// wrong checksum is deleted.
+func (d *downloader) finish(worker int, f fetched) error {
+
+	localpath, partial := d.localpath, d.localpath+f.file+d.PartialSuffix
+
+	if d.Verify {
+		if err := verifySize(partial, f.expected); err != nil {
+			d.logf(worker, "verify failed: %s: %v", f.file, err)
+			return err
+		}
+	}
+
+	if f.sum != "" {
+		if err := d.verifyChecksum(partial, f.sum); err != nil {
+			d.logf(worker, "checksum failed: %s: %v", f.file, err)
+			return err
+		}
+	} else if d.VerifyChecksums && d.Verbose {
+		d.logf(worker, "no checksum published for %s - not checking it", f.file)
+	}
+
+	err := os.Rename(partial, localpath+f.file)
+	if err != nil {
+		d.logf(worker, "error renaming %s", partial)
+		return err
+	}
+
+	d.progress.Finished.Incr()
+	if info, err := os.Stat(localpath + f.file); err == nil {
+		d.progress.Bytes.Add(info.Size())
+	}
+
+	if !f.linked {
+		_ = os.Chmod(localpath+f.file, f.mode)
+	}
+
+	if d.PreserveTimes && !f.modTime.IsZero() {
+		if err := os.Chtimes(localpath+f.file, f.modTime, f.modTime); err != nil {
+			d.logf(worker, "unable to set modification time on %s: %v", f.file, err)
+		}
+	} else if d.PreserveTimes && d.Verbose {
+		d.logf(worker, "no modification time for %s - leaving it as is", f.file)
+	}
+
+	return nil
+
+}
+
+// alreadyDownloaded reports whether file is already at localpath, complete,
+// from an earlier run that was interrupted before it finished - so there's no
+// need to fetch it again. It has to be the size the Source says it is, and with
+// Verify, match its published checksum, too (if there is one). A file whose
+// size isn't known exactly is fetched again, to be safe, and so is everything
+// with Force.
+func (d *downloader) alreadyDownloaded(ctx context.Context, file string) bool {
+
+	if d.Force || d.DryRun || d.Source == nil {
+		return false
+	}
+
+	entry, exists := d.Source.Get(file)
+	if !exists || entry.Size < 0 || entry.SizeApprox {
+		return false
+	}
+
+	info, err := os.Stat(d.localpath + file)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size {
+		return false
+	}
+
+	if d.Verify {
+		expected, err := d.expectedChecksum(ctx, file)
+		if err != nil {
+			return false
+		}
+		if expected != "" {
+			sum, err := checksum.File(d.localpath+file, d.ChecksumAlgo)
+			if err != nil || sum != expected {
+				return false
+			}
+		}
+	}
+
+	d.progress.SkippedBytes.Add(entry.Size)
+
+	return true
+
+}
+
+// lastModified parses a response's Last-Modified header. If the server didn't
+// send one, or sent one we can't make sense of, it returns the zero time.
+func lastModified(header http.Header) time.Time {
+
+	t, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+
+}
+
+// partialSize returns the size of the partial download at path, or 0 if there
+// isn't one.
+func partialSize(path string) int64 {
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return 0
+	}
+
+	return info.Size()
+
+}
+
+// verifySize checks that the file at path is the expected size. If the expected
+// size isn't known (< 0), there's nothing to check against, and it passes.
+func verifySize(path string, expected int64) error {
+
+	if expected < 0 {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Size() != expected {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d", info.Size(), expected)
+	}
+
+	return nil
+
+}
+
+// copyFile copies the contents of src into dst, creating or truncating dst as
+// needed, within the Bandwidth.
+func (d *downloader) copyFile(ctx context.Context, src, dst string) error {
+
+	source, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening source: %w", err)
+	}
+	defer source.Close()
+
+	target, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("creating target: %w", err)
+	}
+
+	_, err = io.Copy(target, LimitReader(ctx, source, d.Bandwidth))
+	if err != nil {
+		target.Close()
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	// a failed Close can mean the data never made it to disk
+	return target.Close()
+
+}
+
+// limitedReader reads from r no faster than limiter allows, waiting for its
+// share of the bandwidth after each read.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (lr limitedReader) Read(p []byte) (int, error) {
+
+	if len(p) > lr.limiter.Burst() {
+		p = p[:lr.limiter.Burst()]
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.WaitN(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+
+}
+
+// LimitReader wraps r so that it's held to limiter, if there is one. Waiting
+// for its share of the bandwidth stops when ctx is cancelled.
+func LimitReader(ctx context.Context, r io.Reader, limiter *rate.Limiter) io.Reader {
+
+	if limiter == nil {
+		return r
+	}
+
+	return limitedReader{ctx: ctx, r: r, limiter: limiter}
+
+}
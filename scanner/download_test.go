@@ -0,0 +1,575 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davexre/sitescan/syncedmap"
+	"github.com/davexre/sitescan/writable"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// testOptions are the sitescan command's default download settings, with the
+// log lines going to out, as "<worker> <line>".
+func testOptions(out io.Writer) DownloadOptions {
+	return DownloadOptions{
+		Workers:        1,
+		PartialSuffix:  ".sitescandl",
+		FileMode:       0644,
+		DirMode:        0755,
+		UpdateInterval: 10 * time.Millisecond,
+		ChecksumSuffix: ".sha256",
+		ManifestFormat: "gnu",
+		ChecksumAlgo:   "sha256",
+		Log:            func(worker int, msg string) { fmt.Fprintf(out, "%d %s\n", worker, msg) },
+	}
+}
+
+// testDownloader sets up a downloader from remotepath to localpath, as Download
+// would.
+func testDownloader(localpath, remotepath string, opts DownloadOptions) *downloader {
+	return &downloader{DownloadOptions: opts, localpath: localpath, remotepath: remotepath, progress: new(Progress)}
+}
+
+// runWorker has d's worker 1 fetch files.
+func runWorker(d *downloader, files ...string) {
+
+	fileschan := make(chan string, len(files))
+	for _, file := range files {
+		fileschan <- file
+	}
+	close(fileschan)
+
+	d.worker(context.Background(), 1, fileschan)
+
+}
+
+func TestLocalTarget(t *testing.T) {
+
+	var tests = []struct {
+		file   string
+		target string
+		ok     bool
+	}{
+		{"file1", "/tmp/base/file1", true},
+		{"dir1/file2", "/tmp/base/dir1/file2", true},
+		{"dir1/../file3", "/tmp/base/file3", true},
+		{"/etc/x", "/tmp/base/etc/x", true},
+		{"../../etc/x", "/etc/x", false},
+		{"dir1/../../x", "/tmp/x", false},
+		{"..", "/tmp", false},
+		{"../base2/x", "/tmp/base2/x", false},
+		{".", "/tmp/base", false},
+	}
+
+	for _, test := range tests {
+		target, ok := LocalTarget("/tmp/base/", test.file)
+		assert.Equal(t, filepath.FromSlash(test.target), target, test.file)
+		assert.Equal(t, test.ok, ok, test.file)
+	}
+}
+
+func TestInsideBase(t *testing.T) {
+
+	filelist := []string{"../../etc/x", "dir1/", "dir1/file1", "dir1/../../../etc/passwd", "file2"}
+	assert.Equal(t, []string{"dir1/", "dir1/file1", "file2"}, InsideBase("/tmp/base/", filelist))
+}
+
+func TestCopyFile(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	d := testDownloader(dstdir+"/", srcdir+"/", testOptions(ioutil.Discard))
+
+	content := []byte("some file contents\n")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file1"), content, 0644))
+
+	assert.Nil(t, d.copyFile(context.Background(), filepath.Join(srcdir, "file1"), filepath.Join(dstdir, "file1")))
+
+	copied, err := ioutil.ReadFile(filepath.Join(dstdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, content, copied)
+
+	// and the source is left alone
+	original, err := ioutil.ReadFile(filepath.Join(srcdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, content, original)
+
+	assert.NotNil(t, d.copyFile(context.Background(), filepath.Join(srcdir, "missing"), filepath.Join(dstdir, "missing")))
+}
+
+func TestLimitReader(t *testing.T) {
+
+	data := bytes.Repeat([]byte("x"), 80*1024)
+	r := bytes.NewReader(data)
+	assert.Equal(t, r, LimitReader(context.Background(), r, nil))
+
+	// the first 64KB burst is free, and the other 16KB take a quarter of a second
+	limiter := rate.NewLimiter(64*1024, 64*1024)
+	started := time.Now()
+	read, err := ioutil.ReadAll(LimitReader(context.Background(), bytes.NewReader(data), limiter))
+	assert.Nil(t, err)
+	assert.Equal(t, data, read)
+	assert.True(t, time.Since(started) >= 200*time.Millisecond, "read too fast: %v", time.Since(started))
+
+	// a cancelled read doesn't wait for its turn
+	limiter = rate.NewLimiter(1, 32*1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ioutil.ReadAll(LimitReader(ctx, bytes.NewReader(data), limiter))
+	assert.NotNil(t, err)
+}
+
+func TestDownloadWorkerLocal(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	content := []byte("some file contents\n")
+	os.MkdirAll(filepath.Join(srcdir, "dir1"), 0755)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "dir1", "file1"), content, 0644))
+
+	d := testDownloader(dstdir+"/", srcdir+"/", testOptions(ioutil.Discard))
+	runWorker(d, "dir1/file1")
+
+	copied, err := ioutil.ReadFile(filepath.Join(dstdir, "dir1", "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, content, copied)
+
+	_, err = os.Stat(filepath.Join(dstdir, "dir1", "file1"+d.PartialSuffix))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadWorkerPartialSuffix(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	var out bytes.Buffer
+	opts := testOptions(&out)
+	opts.Verbose = true
+	opts.PartialSuffix = ".part"
+
+	// another run's partial download, and a file that only looks like one of ours
+	// by default
+	for _, name := range []string{"file1.part", "file2.sitescandl"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, name), []byte(name), 0644))
+	}
+
+	runWorker(testDownloader(dstdir+"/", srcdir+"/", opts), "file1.part", "file2.sitescandl")
+
+	assert.Contains(t, out.String(), "skipping download file file1.part")
+	_, err := os.Stat(filepath.Join(dstdir, "file1.part"))
+	assert.True(t, os.IsNotExist(err))
+
+	copied, err := ioutil.ReadFile(filepath.Join(dstdir, "file2.sitescandl"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("file2.sitescandl"), copied)
+	_, err = os.Stat(filepath.Join(dstdir, "file2.sitescandl.part"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadWorkerAlreadyDownloaded(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	// file1 was downloaded by an earlier run, file2 only partly
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file1"), []byte("new contents"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file2"), []byte("new contents"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dstdir, "file1"), []byte("old contents"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dstdir, "file2"), []byte("old"), 0644))
+
+	opts := testOptions(ioutil.Discard)
+	opts.Source = syncedmap.New(map[string]Entry{"file1": {URL: "file1", Size: 12}, "file2": {URL: "file2", Size: 12}})
+
+	// the same size is good enough to leave file1 alone
+	d := testDownloader(dstdir+"/", srcdir+"/", opts)
+	runWorker(d, "file1", "file2")
+	for file, expected := range map[string]string{"file1": "old contents", "file2": "new contents"} {
+		contents, err := ioutil.ReadFile(filepath.Join(dstdir, file))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(contents), file)
+	}
+	assert.Equal(t, int64(12), d.progress.SkippedBytes.Load())
+
+	opts.Force = true
+	runWorker(testDownloader(dstdir+"/", srcdir+"/", opts), "file1", "file2")
+	contents, err := ioutil.ReadFile(filepath.Join(dstdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new contents", string(contents))
+}
+
+func TestDownloadWorkerStalePartial(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	var out bytes.Buffer
+	opts := testOptions(&out)
+	opts.Verbose = true
+
+	content := []byte("some file contents\n")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file1"), content, 0644))
+
+	// a partial left over from an earlier run, that's longer than the real thing
+	stale := filepath.Join(dstdir, "file1"+opts.PartialSuffix)
+	assert.Nil(t, ioutil.WriteFile(stale, []byte("stale partial download, much longer than the source file\n"), 0644))
+
+	runWorker(testDownloader(dstdir+"/", srcdir+"/", opts), "file1")
+
+	assert.Contains(t, out.String(), "removed stale dl file "+stale)
+
+	copied, err := ioutil.ReadFile(filepath.Join(dstdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, content, copied)
+
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadWorkerContinuesAfterFailure(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	var out bytes.Buffer
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file2"), []byte("file2\n"), 0644))
+
+	// file1 doesn't exist at the source, so it fails - file2 should still arrive
+	d := testDownloader(dstdir+"/", srcdir+"/", testOptions(&out))
+	runWorker(d, "file1", "file2")
+
+	_, err := os.Stat(filepath.Join(dstdir, "file2"))
+	assert.Nil(t, err)
+
+	errs := d.progress.Errors.List()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, srcdir+"/file1", errs[0].URL)
+	}
+	assert.Contains(t, out.String(), "1 done, 1 failed\n")
+}
+
+func TestVerifySize(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "sitescan-verify")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file1")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("12345"), 0644))
+
+	assert.Nil(t, verifySize(path, 5))
+	assert.Nil(t, verifySize(path, -1))
+	assert.NotNil(t, verifySize(path, 10))
+	assert.NotNil(t, verifySize(filepath.Join(dir, "missing"), 5))
+}
+
+func TestDownloadBatchVerify(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file contents"))
+	}))
+	defer ts.Close()
+
+	opts := testOptions(ioutil.Discard)
+	opts.Verify = true
+
+	d := testDownloader(dstdir+"/", ts.URL+"/", opts)
+	d.batch(context.Background(), []string{"file1"})
+
+	assert.Len(t, d.progress.Errors.List(), 0)
+
+	contents, err := ioutil.ReadFile(filepath.Join(dstdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "file contents", string(contents))
+}
+
+func TestDownloadBatchCancelled(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	// sends half the file, and then hangs until the request is abandoned
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("12345"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	d := testDownloader(dstdir+"/", ts.URL+"/", testOptions(ioutil.Discard))
+	partial := filepath.Join(dstdir, "file1"+d.PartialSuffix)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// interrupt once the first half has been written out
+		for partialSize(partial) < 5 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	d.batch(ctx, []string{"file1"})
+
+	// an interrupted download isn't a failure, and is left to be resumed
+	assert.Len(t, d.progress.Errors.List(), 0)
+	assert.Equal(t, 0, d.progress.Finished.Read())
+	_, err := os.Stat(filepath.Join(dstdir, "file1"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(5), partialSize(partial))
+}
+
+func TestDownloadBatch(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader("contents of "+r.URL.Path))
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	opts := testOptions(&out)
+	opts.Workers = 2
+
+	d := testDownloader(dstdir+"/", ts.URL+"/", opts)
+	d.batch(context.Background(), []string{"dir1/", "dir1/file1", "file2", "missing", "file3", "file4" + opts.PartialSuffix})
+
+	for _, file := range []string{"dir1/file1", "file2", "file3"} {
+		contents, err := ioutil.ReadFile(filepath.Join(dstdir, file))
+		assert.Nil(t, err, file)
+		assert.Equal(t, "contents of /"+file, string(contents))
+	}
+	assert.Equal(t, 3, d.progress.Finished.Read())
+
+	errs := d.progress.Errors.List()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, ts.URL+"/missing", errs[0].URL)
+	}
+
+	assert.Contains(t, out.String(), fmt.Sprintf("%d finished: file2\n", BatchWorker))
+	assert.Equal(t, int64(len("contents of /dir1/file1")+len("contents of /file2")+len("contents of /file3")), d.progress.Bytes.Load())
+	assert.Len(t, d.progress.InFlight(), 0)
+}
+
+func TestDownloadBatchEncoded(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader("contents of "+r.URL.Path))
+	}))
+	defer ts.Close()
+
+	files := []string{"café/it's here.mp3", "50% off #1.txt"}
+	d := testDownloader(dstdir+"/", ts.URL+"/", testOptions(ioutil.Discard))
+	d.batch(context.Background(), files)
+
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(filepath.Join(dstdir, file))
+		assert.Nil(t, err, file)
+		assert.Equal(t, "contents of /"+file, string(contents))
+	}
+	assert.Len(t, d.progress.Errors.List(), 0)
+}
+
+func TestDownloadBatchResume(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	content := "0123456789abcdefghij"
+	var ranges []string
+
+	// ServeContent advertises and honours range requests, like most servers
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, "file1", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	d := testDownloader(dstdir+"/", ts.URL+"/", testOptions(ioutil.Discard))
+	partial := filepath.Join(dstdir, "file1"+d.PartialSuffix)
+
+	// what a killed run would have left behind
+	assert.Nil(t, ioutil.WriteFile(partial, []byte(content[:8]), 0644))
+	assert.Equal(t, int64(8), partialSize(partial))
+
+	d.batch(context.Background(), []string{"file1"})
+
+	assert.Len(t, d.progress.Errors.List(), 0)
+	assert.Equal(t, []string{"bytes=8-"}, ranges)
+
+	contents, err := ioutil.ReadFile(filepath.Join(dstdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, content, string(contents))
+}
+
+func TestDownloadPreserveTimes(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	modTime := time.Date(2021, 6, 27, 15, 45, 0, 0, time.UTC)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file1":
+			http.ServeContent(w, r, "file1", modTime, strings.NewReader("file1 contents"))
+		default:
+			// no Last-Modified header
+			http.ServeContent(w, r, "file2", time.Time{}, strings.NewReader("file2 contents"))
+		}
+	}))
+	defer ts.Close()
+
+	opts := testOptions(ioutil.Discard)
+	opts.PreserveTimes = true
+
+	d := testDownloader(dstdir+"/", ts.URL+"/", opts)
+	d.batch(context.Background(), []string{"file1", "file2"})
+	assert.Len(t, d.progress.Errors.List(), 0)
+
+	info, err := os.Stat(filepath.Join(dstdir, "file1"))
+	if assert.Nil(t, err) {
+		assert.True(t, modTime.Equal(info.ModTime()), "file1 mtime %v", info.ModTime())
+	}
+	info, err = os.Stat(filepath.Join(dstdir, "file2"))
+	if assert.Nil(t, err) {
+		assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+	}
+
+	// and a local copy takes the source file's time
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file3"), []byte("file3 contents"), 0644))
+	assert.Nil(t, os.Chtimes(filepath.Join(srcdir, "file3"), modTime, modTime))
+
+	runWorker(testDownloader(dstdir+"/", srcdir+"/", opts), "file3")
+
+	info, err = os.Stat(filepath.Join(dstdir, "file3"))
+	if assert.Nil(t, err) {
+		assert.True(t, modTime.Equal(info.ModTime()), "file3 mtime %v", info.ModTime())
+	}
+}
+
+func TestDownloadBatchModes(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file1", time.Time{}, strings.NewReader("file1"))
+	}))
+	defer ts.Close()
+
+	opts := testOptions(ioutil.Discard)
+	opts.FileMode, opts.DirMode, opts.Umask = 0640, 0750, writable.Umask()
+
+	testDownloader(dstdir+"/", ts.URL+"/", opts).batch(context.Background(), []string{"dir1/file1"})
+
+	info, err := os.Stat(filepath.Join(dstdir, "dir1"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, opts.DirMode&^opts.Umask, info.Mode().Perm())
+	}
+	info, err = os.Stat(filepath.Join(dstdir, "dir1", "file1"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, opts.FileMode&^opts.Umask, info.Mode().Perm())
+	}
+}
+
+func TestDownloadWorkerSourceMode(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "file1"), []byte("file1"), 0600))
+	assert.Nil(t, os.Chmod(filepath.Join(srcdir, "file1"), 0600))
+
+	// make sure we're testing a real copy, not a hard link
+	defer func() { linkFile = os.Link }()
+	linkFile = func(oldname, newname string) error { return fmt.Errorf("no links here") }
+
+	runWorker(testDownloader(dstdir+"/", srcdir+"/", testOptions(ioutil.Discard)), "file1")
+
+	info, err := os.Stat(filepath.Join(dstdir, "file1"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestDownload(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	os.MkdirAll(filepath.Join(srcdir, "dir1"), 0755)
+	for _, file := range []string{"dir1/file1", "file2"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, file), []byte(file), 0644))
+	}
+
+	var downloaded []string
+	var progress Progress
+	opts := testOptions(ioutil.Discard)
+	opts.Workers = 2
+	opts.Source = syncedmap.New(map[string]Entry{"dir1/file1": {Size: 10}, "file2": {Size: 5}})
+	opts.Progress = &progress
+	opts.Downloaded = func(ctx context.Context, file string) { downloaded = append(downloaded, file) }
+
+	// the paths don't need their trailing slashes, and anything that would land
+	// outside dstdir is left out
+	totals, err := Download(context.Background(), dstdir, srcdir, []string{"dir1/", "dir1/file1", "file2", "missing", "../x"}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, Totals{Queued: 3, Succeeded: 2, Failed: 1}, totals)
+
+	for _, file := range []string{"dir1/file1", "file2"} {
+		contents, err := ioutil.ReadFile(filepath.Join(dstdir, file))
+		assert.Nil(t, err, file)
+		assert.Equal(t, file, string(contents))
+	}
+	assert.Equal(t, int64(3), progress.Total.Load())
+	assert.Equal(t, int64(-1), progress.TotalBytes.Load())
+	assert.Len(t, progress.Errors.List(), 1)
+	assert.ElementsMatch(t, []string{dstdir + "/dir1/file1", dstdir + "/file2"}, downloaded)
+}
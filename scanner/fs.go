@@ -0,0 +1,91 @@
+package scanner
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterBackend("file", fsBackend{})
+}
+
+// fsBackend walks a local directory tree.
+type fsBackend struct{}
+
+func (fsBackend) Walk(ctx context.Context, s *Site, opts ScanOptions) {
+	walkFS(ctx, s.URL, s.Map, &s.Counter, opts)
+}
+
+// walkFS fills in siteMap from the local directory tree at basepath, leaving out
+// hidden files and directories.
+func walkFS(ctx context.Context, basepath string, siteMap *Map, counter *Counter, scan ScanOptions) {
+
+	err := filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+
+		if err != nil {
+			if os.IsPermission(err) {
+				slog.Debug("skipping", "err", err)
+				return filepath.SkipDir
+			} else {
+				return err
+			}
+		}
+
+		if path == basepath {
+			slog.Debug("skipping - seems to be our base path", "name", info.Name())
+			return nil
+		}
+
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			slog.Debug("skipping dir", "name", info.Name())
+			return filepath.SkipDir
+		}
+
+		if !info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			slog.Debug("skipping file", "name", info.Name())
+			return nil
+		}
+
+		relpath, err := filepath.Rel(basepath, path)
+		if err != nil {
+			return err
+		}
+		relpath = filepath.ToSlash(relpath)
+		counter.Reached(strings.Count(relpath, "/") + 1)
+
+		if info.IsDir() {
+			dirname := EntryKey("", relpath, true)
+			if scan.Excluded(dirname) {
+				slog.Debug("not descending - excluded", "dir", dirname)
+				return filepath.SkipDir
+			}
+			if scan.Wanted(dirname) {
+				counter.Incr(true)
+				siteMap.Set(dirname, Entry{URL: relpath, Size: -1})
+			}
+
+			// keep the same depth limit as walkLink, so both sites stay comparable
+			if scan.MaxDepth > 0 && strings.Count(dirname, "/") >= scan.MaxDepth {
+				slog.Debug("not descending - max depth reached", "dir", dirname, "max-depth", scan.MaxDepth)
+				return filepath.SkipDir
+			}
+		} else if scan.Wanted(relpath) {
+			counter.Incr(false)
+			siteMap.Set(relpath, Entry{URL: relpath, Size: info.Size(), ModTime: info.ModTime()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		// like a listing that can't be fetched, it's reported at the end, and
+		// the rest of the comparison goes ahead
+		scan.Fail(basepath, err)
+	}
+
+}
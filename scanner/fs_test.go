@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalkFSMaxDepth(t *testing.T) {
+
+	base, err := ioutil.TempDir("", "sitescan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	if err := os.MkdirAll(filepath.Join(base, "dir1/dir2/dir3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"file1", "dir1/file2", "dir1/dir2/file3"} {
+		if err := ioutil.WriteFile(filepath.Join(base, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var testmap = new(Map)
+	var counter Counter
+
+	walkFS(context.Background(), base, testmap, &counter, ScanOptions{MaxDepth: 2})
+
+	assert.Contains(t, testmap.Snapshot(), "file1")
+	assert.Contains(t, testmap.Snapshot(), "dir1/file2")
+	assert.Contains(t, testmap.Snapshot(), "dir1/dir2/")
+	assert.NotContains(t, testmap.Snapshot(), "dir1/dir2/file3")
+	assert.NotContains(t, testmap.Snapshot(), "dir1/dir2/dir3/")
+	assert.Equal(t, int64(len("dir1/file2")), testmap.Snapshot()["dir1/file2"].Size)
+}
+
+func TestWalkFSIncludeExclude(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "sitescan-filter")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "dir1", "samples"), 0755)
+	for _, name := range []string{"a.mp4", "b.txt", "dir1/c.mkv", "dir1/samples/d.mp4"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+
+	opts := ScanOptions{Include: []string{"*.mp4", "*.mkv"}, Exclude: []string{"dir1/samples/*"}}
+
+	var counter Counter
+	testmap := new(Map)
+	walkFS(context.Background(), dir, testmap, &counter, opts)
+
+	assert.ElementsMatch(t, []string{"a.mp4", "dir1/c.mkv"}, testmap.Keys())
+}
+
+func TestWalkFSExcludedDir(t *testing.T) {
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "tmp", "dir2"), 0755)
+	for _, name := range []string{"a.mp4", "tmp/b.mp4", "tmp/dir2/c.mp4"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+
+	var counter Counter
+	testmap := new(Map)
+	walkFS(context.Background(), dir, testmap, &counter, ScanOptions{Exclude: []string{"tmp/"}})
+
+	// nothing under tmp/ is listed or counted
+	assert.ElementsMatch(t, []string{"a.mp4"}, testmap.Keys())
+	assert.Equal(t, 1, counter.Files.Read())
+	assert.Equal(t, 0, counter.Dirs.Read())
+	assert.Equal(t, int64(1), counter.Depth.Load())
+}
+
+func TestWalkFSMissing(t *testing.T) {
+
+	var walkErrors ErrorList
+
+	// a local site that isn't there is reported, rather than being the end of
+	// the run
+	var testmap = new(Map)
+	var counter Counter
+	walkFS(context.Background(), filepath.Join(t.TempDir(), "missing"), testmap, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	assert.Len(t, walkErrors.List(), 1)
+	assert.Empty(t, testmap.Snapshot())
+
+}
@@ -0,0 +1,215 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/jlaffaye/ftp"
+)
+
+// ftpDialTimeout is how long we'll wait to connect to an FTP server.
+const ftpDialTimeout = 30 * time.Second
+
+// ftpLister is the part of an FTP connection that walkFTP needs.
+type ftpLister interface {
+	List(path string) ([]*ftp.Entry, error)
+}
+
+// ftpBackend walks an FTP server's directory tree.
+type ftpBackend struct{}
+
+func init() {
+	RegisterBackend("ftp", ftpBackend{})
+}
+
+func (ftpBackend) Walk(ctx context.Context, s *Site, opts ScanOptions) {
+
+	conn, dir, err := ftpConnect(ctx, s.URL, s.Opts)
+	if err != nil {
+		opts.Fail(s.URL, err)
+		return
+	}
+	defer conn.Quit()
+
+	walkFTP(ctx, conn, dir, "", 1, s.Map, &s.Counter, opts)
+
+}
+
+// ftpConnect logs in to the FTP server in u, and returns the connection, along
+// with the path on the server that u points to. Without a user, we log in as
+// "anonymous", which most public mirrors expect. Cancelling ctx gives up on
+// connecting.
+func ftpConnect(ctx context.Context, u string, opts webhandler.Options) (*ftp.ServerConn, string, error) {
+
+	parsed, err := neturl.Parse(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host += ":21"
+	}
+
+	conn, err := ftp.Dial(host, ftp.DialWithTimeout(ftpDialTimeout), ftp.DialWithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, pass := opts.User, opts.Pass
+	if user == "" {
+		user, pass = "anonymous", "anonymous"
+	}
+	if err := conn.Login(user, pass); err != nil {
+		conn.Quit()
+		return nil, "", fmt.Errorf("authentication failed (%v) - check the user and password for this site", err)
+	}
+
+	dir := parsed.Path
+	if dir == "" {
+		dir = "/"
+	}
+
+	return conn, dir, nil
+
+}
+
+// walkFTP builds the site map for an FTP server, just as walkLink does for a web
+// server, listing dir and calling itself for each directory inside it. The
+// library asks for an MLSD listing if the server supports it, and falls back to
+// LIST (and parsing its Unix or DOS style output) if not. depth and scan.MaxDepth
+// work as they do for walkLink. Once ctx is cancelled, nothing more is listed.
+func walkFTP(ctx context.Context, conn ftpLister, dir string, currentName string, depth int, siteMap *Map, counter *Counter, scan ScanOptions) {
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	entries, err := conn.List(dir)
+	if err != nil {
+		scan.Fail("ftp:"+dir, err)
+		return
+	}
+
+	counter.Reached(depth)
+
+	for _, e := range entries {
+
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+
+		// symlinks could point anywhere - including back up the tree - so we
+		// leave them alone
+		if e.Type != ftp.EntryTypeFile && e.Type != ftp.EntryTypeFolder {
+			continue
+		}
+
+		ourname := EntryKey(currentName, e.Name, e.Type == ftp.EntryTypeFolder)
+
+		if e.Type == ftp.EntryTypeFolder {
+			if scan.Excluded(ourname) {
+				slog.Debug("not descending - excluded", "dir", ourname)
+				continue
+			}
+			if scan.Wanted(ourname) {
+				counter.Incr(true)
+				siteMap.Set(ourname, Entry{URL: ourname, Size: -1})
+			}
+
+			if scan.MaxDepth > 0 && depth >= scan.MaxDepth {
+				slog.Debug("not descending - max depth reached", "dir", ourname, "max-depth", scan.MaxDepth)
+			} else {
+				walkFTP(ctx, conn, path.Join(dir, e.Name), ourname, depth+1, siteMap, counter, scan)
+			}
+			continue
+		}
+
+		if scan.Wanted(ourname) {
+			counter.Incr(false)
+			siteMap.Set(ourname, Entry{URL: ourname, Size: int64(e.Size)})
+		}
+
+	}
+
+}
+
+// ftpDownload fetches file (a path relative to the FTP site at base) into
+// target. If target already holds part of the file from an earlier run, the
+// download carries on from where it left off. It returns the size the server
+// says the file is (or -1 if it won't say). Cancelling ctx stops the download,
+// leaving what's arrived so far in target, ready to be resumed.
+func (d *downloader) ftpDownload(ctx context.Context, base, file, target string) (int64, error) {
+
+	conn, dir, err := ftpConnect(ctx, base, d.Site)
+	if err != nil {
+		return -1, err
+	}
+	defer conn.Quit()
+
+	file = path.Join(dir, file)
+
+	if err := os.MkdirAll(filepath.Dir(target), d.DirMode); err != nil {
+		return -1, err
+	}
+
+	expected, err := conn.FileSize(file)
+	if err != nil {
+		expected = -1
+	}
+
+	offset := partialSize(target)
+	if offset > 0 && expected >= 0 && offset > expected {
+		// it's bigger than the real thing, so it's no use to us
+		offset = 0
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	out, err := os.OpenFile(target, flags, 0644)
+	if err != nil {
+		return expected, err
+	}
+
+	resp, err := conn.RetrFrom(file, uint64(offset))
+	if err != nil {
+		out.Close()
+		return expected, err
+	}
+
+	_, err = io.Copy(out, LimitReader(ctx, contextReader{ctx, resp}, d.Bandwidth))
+	resp.Close()
+	if err != nil {
+		out.Close()
+		return expected, err
+	}
+
+	return expected, out.Close()
+
+}
+
+// contextReader reads from r until ctx is cancelled, and then returns ctx's
+// error instead. The FTP library can't be interrupted in the middle of a
+// transfer, so this is how we stop one between reads.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
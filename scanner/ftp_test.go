@@ -0,0 +1,70 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFTP is an ftpLister serving canned listings, keyed by directory.
+type fakeFTP map[string][]*ftp.Entry
+
+func (f fakeFTP) List(dir string) ([]*ftp.Entry, error) {
+	entries, exists := f[dir]
+	if !exists {
+		return nil, fmt.Errorf("550 %s: No such file or directory", dir)
+	}
+	return entries, nil
+}
+
+func TestWalkFTP(t *testing.T) {
+
+	conn := fakeFTP{
+		"/pub": {
+			{Name: ".", Type: ftp.EntryTypeFolder},
+			{Name: "..", Type: ftp.EntryTypeFolder},
+			{Name: "file1.txt", Type: ftp.EntryTypeFile, Size: 1234},
+			{Name: "dir1", Type: ftp.EntryTypeFolder},
+			{Name: "latest", Type: ftp.EntryTypeLink},
+			{Name: "missing", Type: ftp.EntryTypeFolder},
+		},
+		"/pub/dir1": {
+			{Name: "file 2.iso", Type: ftp.EntryTypeFile, Size: 42},
+		},
+	}
+
+	var walkErrors ErrorList
+	var counter Counter
+	testmap := new(Map)
+	walkFTP(context.Background(), conn, "/pub", "", 1, testmap, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	assert.Equal(t, map[string]Entry{
+		"file1.txt":       {URL: "file1.txt", Size: 1234},
+		"dir1/":           {URL: "dir1/", Size: -1},
+		"dir1/file 2.iso": {URL: "dir1/file 2.iso", Size: 42},
+		"missing/":        {URL: "missing/", Size: -1},
+	}, testmap.Snapshot())
+	assert.Equal(t, "2 files, 2 dirs", counter.Split())
+
+	errs := walkErrors.List()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "ftp:/pub/missing", errs[0].URL)
+	}
+}
+
+func TestWalkFTPMaxDepth(t *testing.T) {
+
+	conn := fakeFTP{
+		"/":     {{Name: "dir1", Type: ftp.EntryTypeFolder}},
+		"/dir1": {{Name: "file1", Type: ftp.EntryTypeFile, Size: 1}},
+	}
+
+	var counter Counter
+	testmap := new(Map)
+	walkFTP(context.Background(), conn, "/", "", 1, testmap, &counter, ScanOptions{MaxDepth: 1})
+
+	assert.Equal(t, map[string]Entry{"dir1/": {URL: "dir1/", Size: -1}}, testmap.Snapshot())
+}
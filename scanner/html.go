@@ -0,0 +1,484 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/davexre/sitescan/webhandler"
+	xhtml "golang.org/x/net/html"
+)
+
+func init() {
+	RegisterBackend("http", htmlBackend{})
+	RegisterBackend("https", htmlBackend{})
+}
+
+// htmlBackend scrapes the links out of a web server's directory listings.
+type htmlBackend struct{}
+
+func (htmlBackend) Walk(ctx context.Context, s *Site, opts ScanOptions) {
+	var visited VisitedSet
+	walkLink(ctx, s.URL, "", "", 1, s.Map, s.Opts, &visited, &s.Counter, opts)
+}
+
+// walkPool lets a walkLink fetch the listings of sibling directories at the
+// same time - up to ScanOptions.Concurrency of them, counting the walk's own goroutine.
+type walkPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWalkPool(concurrency int) *walkPool {
+	return &walkPool{slots: make(chan struct{}, concurrency-1)}
+}
+
+// descend runs walk in a goroutine of its own, if there's a free slot - or
+// right here, if there isn't, so a full pool never leaves a walk waiting on
+// itself. With a concurrency of 1, there are never any free slots.
+func (p *walkPool) descend(walk func()) {
+
+	select {
+	case p.slots <- struct{}{}:
+		p.wg.Add(1)
+		go func() {
+			defer func() {
+				<-p.slots
+				p.wg.Done()
+			}()
+			walk()
+		}()
+	default:
+		walk()
+	}
+
+}
+
+// walkLink builds a map of the URLs and plain text names for all the files
+// stored at the indicated site. This is intended to be called in a recursive
+// fashion. With opts.Concurrency above 1, it fetches several directories'
+// listings at once (see walkPool).
+//
+// So, why are names taken from the decoded href, rather than the anchor tag
+// text? Different web servers encode data differently, and present the text in
+// the anchor tags differently. For instance, lighthttpd does not include the
+// trailing "/" in the anchor tag text, but apache does - and apache cuts long
+// names short in its anchor text. lighthttpd encodes apostrophes (%27), but
+// apache leaves them as bare apostrophes. Decoding the href (see linkName)
+// gives the same name either way, which is what lets two different servers be
+// compared. The URL that's stored is the href as the server gave it, and
+// downloads, which go by name, encode it again (see EscapePath).
+//
+// The primary work is done in the doc.Find block - it looks at each anchor
+// tag in the document, and processes it accordingly. We're expecting to find
+// a file listing there. Any directory needs to be explored, so walkLink calls
+// itself recursively to handle that.
+//
+// Most servers also list a size and a last modified time for each file after the
+// anchor. We record them when we can find them (see listingSize and listingTime)
+// so that sizes and times can be compared, too.
+//
+// depth is how many directories deep the listing at url is - the top level is
+// 1. Once depth reaches opts.MaxDepth, directories are still recorded in the map,
+// but we don't descend into them.
+//
+// Some servers have links that point back up the tree (or to themselves), which
+// would have us recursing forever. visited holds every URL this site's walk has
+// fetched so far, and we won't fetch one twice.
+//
+// Once ctx is cancelled, any listing in flight is abandoned, and no more are
+// fetched.
+func walkLink(ctx context.Context, urlprefix string, url string, currentName string, depth int, siteMap *Map,
+	opts webhandler.Options, visited *VisitedSet, counter *Counter, scan ScanOptions) {
+
+	pool := newWalkPool(max(scan.Concurrency, 1))
+	walkListing(ctx, urlprefix, url, currentName, depth, siteMap, opts, visited, counter, pool, scan)
+	pool.wg.Wait()
+
+}
+
+// walkListing does the work for walkLink, one listing at a time, handing the
+// subdirectories it finds to pool.
+func walkListing(ctx context.Context, urlprefix string, url string, currentName string, depth int, siteMap *Map,
+	opts webhandler.Options, visited *VisitedSet, counter *Counter, pool *walkPool, scan ScanOptions) {
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	urltoget := fmt.Sprintf("%s%s", urlprefix, url)
+
+	if !visited.Add(normalizeURL(urltoget)) {
+		slog.Debug("already visited - skipping, check the server for links that loop", "url", urltoget)
+		return
+	}
+
+	response, err := webhandler.HTTPHandlerWithOptions(ctx, urltoget, opts)
+	if listingFailed(scan, urltoget, response, err) {
+		return
+	}
+
+	defer response.Body.Close()
+
+	// if we were redirected (from "dir" to "dir/", say), the links in the
+	// listing are relative to where we ended up, so carry on from there
+	if response.Request != nil {
+		if final := response.Request.URL.String(); final != urltoget {
+			if !strings.HasPrefix(final, urlprefix) {
+				scan.Fail(urltoget, fmt.Errorf("redirected outside of the site, to <%s>", final))
+				return
+			}
+			if !visited.Add(normalizeURL(final)) {
+				return
+			}
+			url = strings.TrimPrefix(final, urlprefix)
+		}
+	}
+
+	counter.Reached(depth)
+
+	doc, err := goquery.NewDocumentFromReader(countingReader{response.Body, &counter.HTMLBytes})
+	if err != nil {
+		scan.Fail(urltoget, err)
+		return
+	}
+
+	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+		href, exists := s.Attr("href")
+		if !scan.ignoreLink(s.Text(), href) {
+			if exists {
+
+				// a query string or fragment isn't part of what's linked to -
+				// and a link that's nothing but one just points back here
+				linkPath := hrefPath(href)
+				if linkPath == "" {
+					return
+				}
+
+				// and only links to somewhere under this listing are ours
+				if externalLink(href) {
+					slog.Debug("skipping external link", "href", href)
+					return
+				}
+
+				// one from the root of the server is too, if it leads under
+				// here - and then it's made relative, like the rest
+				if strings.HasPrefix(href, "/") {
+					rel, ok := underListing(href, urlprefix+url)
+					if !ok {
+						slog.Debug("skipping link outside of the listing", "href", href)
+						return
+					}
+					href, linkPath = rel, hrefPath(rel)
+				}
+
+				isDir := strings.HasSuffix(linkPath, "/")
+				ourname := EntryKey(currentName, linkName(linkPath, s.Text()), isDir)
+				oururl := fmt.Sprintf("%s%s", url, linkPath)
+
+				if isDir && scan.Excluded(ourname) {
+					slog.Debug("not descending - excluded", "dir", ourname)
+					return
+				}
+
+				if scan.Wanted(ourname) {
+					counter.Incr(isDir)
+
+					// a file keeps its href as it was, in case the query
+					// matters for fetching it, but a directory's URL is
+					// what its own links get added to, so it has to go
+					entry := Entry{URL: url + href, Size: -1}
+					if isDir {
+						entry.URL = oururl
+					}
+					if !strings.HasSuffix(ourname, "/") {
+						columns := listingColumns(s)
+						var from, to int
+						entry.ModTime, from, to = listingTime(columns)
+						entry.Size, entry.SizeApprox = listingSize(columns, from, to)
+					}
+					siteMap.Set(ourname, entry)
+				}
+
+				if isDir {
+					if scan.MaxDepth > 0 && depth >= scan.MaxDepth {
+						slog.Debug("not descending - max depth reached", "dir", ourname, "max-depth", scan.MaxDepth)
+					} else {
+						pool.descend(func() {
+							walkListing(ctx, urlprefix, oururl, ourname, depth+1, siteMap, opts, visited, counter, pool, scan)
+						})
+					}
+				}
+
+			}
+
+		}
+
+	})
+
+}
+
+// hrefPath returns href without any query string or fragment, which servers add
+// to links for things like sort orders and forcing downloads. A "?" or "#" that's
+// really part of a name comes encoded, so it stays.
+func hrefPath(href string) string {
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	u.RawQuery, u.ForceQuery = "", false
+	u.Fragment, u.RawFragment = "", ""
+
+	return u.String()
+
+}
+
+// externalLink reports whether href leads off the site, rather than to something
+// in the listing it's in - a full URL, one to another host, or a link with a
+// scheme like mailto: or javascript: that isn't to a file at all.
+func externalLink(href string) bool {
+
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme != "" || u.Host != ""
+
+}
+
+// underListing works out whether a root-relative href, like "/pub/dir/", leads
+// to somewhere under the listing at listingURL, and if so, returns it relative
+// to the listing. One that leads to the listing itself, or anywhere outside it,
+// isn't under it.
+func underListing(href, listingURL string) (string, bool) {
+
+	u, err := url.Parse(listingURL)
+	if err != nil {
+		return "", false
+	}
+
+	dir := u.EscapedPath()
+	if !strings.HasSuffix(dir, "/") {
+		dir = dir[:strings.LastIndex(dir, "/")+1]
+	}
+
+	if !strings.HasPrefix(href, dir) || hrefPath(strings.TrimPrefix(href, dir)) == "" {
+		return "", false
+	}
+
+	return strings.TrimPrefix(href, dir), true
+
+}
+
+// linkName works out the name of whatever a listing's link points to, from its
+// href. Servers disagree about what to percent-encode, so it's decoded - that
+// way "it's.mp3" and "it%27s.mp3" get the same name, whichever server they came
+// from. If the href won't decode to a plain name, the anchor text is used.
+// Either way, it's just the name - EntryKey sorts out any trailing "/".
+func linkName(href, text string) string {
+
+	name, err := url.PathUnescape(strings.TrimSuffix(href, "/"))
+	if err != nil || name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		name = text
+	}
+
+	return name
+
+}
+
+// EscapePath percent-encodes each part of a path made from file names, like
+// the ones linkName returns, so it can go on the end of a URL.
+func EscapePath(p string) string {
+
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+
+}
+
+// normalizeURL cleans up a URL so that different spellings of the same location
+// compare equal - "dir1/../dir1/", "dir1/./" and "dir1//" all become "dir1/".
+// The scheme and host are lowercased, fragments dropped, and a trailing slash
+// kept, since that's what marks a directory.
+func normalizeURL(u string) string {
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+
+	cleaned := path.Clean("/" + parsed.Path)
+	if strings.HasSuffix(parsed.Path, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	parsed.Path = cleaned
+	parsed.RawPath = ""
+
+	return parsed.String()
+
+}
+
+// listingColumns collects the directory listing text that follows an anchor.
+// Apache and lighttpd table style listings put each column in a following <td>,
+// while Apache and nginx <pre> style listings put them all in the text node
+// right after the anchor, separated by spaces.
+func listingColumns(s *goquery.Selection) []string {
+
+	var columns []string
+
+	if td := s.Closest("td"); td.Length() > 0 {
+		td.NextAll().Each(func(i int, col *goquery.Selection) {
+			columns = append(columns, strings.TrimSpace(col.Text()))
+		})
+	} else if next := s.Nodes[0].NextSibling; next != nil && next.Type == xhtml.TextNode {
+		line := strings.SplitN(next.Data, "\n", 2)[0]
+		columns = strings.Fields(line)
+	}
+
+	return columns
+
+}
+
+// listingSize digs the size of a file out of the columns that follow its anchor
+// in a directory listing. The first column that parses as a size wins, leaving
+// out columns[from:to], which listingTime found the date in - "27 Jun 2021"
+// would otherwise give a size of 27. A number followed by a column that's just
+// a unit, as in "2 KiB", is taken as one size. If there's no size to be found,
+// -1 is returned.
+func listingSize(columns []string, from, to int) (int64, bool) {
+
+	skip := func(i int) bool { return i >= from && i < to }
+
+	for i, col := range columns {
+		if skip(i) {
+			continue
+		}
+		if i+1 < len(columns) && !skip(i+1) && !strings.ContainsAny(columns[i+1], "0123456789") {
+			if size, approx, ok := ParseSize(col + columns[i+1]); ok {
+				return size, approx
+			}
+		}
+		if size, approx, ok := ParseSize(col); ok {
+			return size, approx
+		}
+	}
+
+	return -1, false
+
+}
+
+// listingTimeLayouts are the last modified date formats that directory listings
+// use - Apache, nginx, lighttpd, IIS and friends all have their own ideas.
+var listingTimeLayouts = []string{
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"02-Jan-2006 15:04",
+	"02-Jan-2006 15:04:05",
+	"2006-Jan-02 15:04",
+	"2006-Jan-02 15:04:05",
+	"02 Jan 2006 15:04",
+	"Jan 02 2006 15:04",
+	"01/02/2006 15:04",
+	time.RFC3339,
+}
+
+// listingTime digs the last modified time of a file out of the columns that
+// follow its anchor in a directory listing. In <pre> style listings the date
+// and time are split up into separate columns, so each column is tried both on
+// its own and joined up with the next few. The times are taken to be UTC, since
+// the listing doesn't say. columns[from:to] are the columns the time came from.
+// If no column parses, the zero time is returned (with from and to both 0) and
+// the file is left out of --newer-than comparisons.
+func listingTime(columns []string) (t time.Time, from, to int) {
+
+	for i := range columns {
+		for n := 1; n <= 4 && i+n <= len(columns); n++ {
+			if t, ok := parseListingTime(strings.Join(columns[i:i+n], " ")); ok {
+				return t, i, i + n
+			}
+		}
+	}
+
+	return time.Time{}, 0, 0
+
+}
+
+// parseListingTime tries each of listingTimeLayouts in turn.
+func parseListingTime(col string) (time.Time, bool) {
+
+	for _, layout := range listingTimeLayouts {
+		if t, err := time.Parse(layout, col); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+
+}
+
+// ParseSize parses a size as presented in a directory listing. That's either a
+// plain number of bytes ("1234"), or a human readable size with a unit suffix
+// ("1.2K", "45M", "3.1GB", "2KiB"). Human readable sizes are flagged as
+// approximate.
+func ParseSize(col string) (size int64, approx bool, ok bool) {
+
+	col = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(col), "B"), "I")
+	if col == "" {
+		return -1, false, false
+	}
+
+	multiplier := int64(1)
+	if i := strings.IndexByte("KMGTP", col[len(col)-1]); i >= 0 {
+		for ; i >= 0; i-- {
+			multiplier *= 1024
+		}
+		col = col[:len(col)-1]
+		approx = true
+	}
+
+	if !approx {
+		size, err := strconv.ParseInt(col, 10, 64)
+		if err != nil || size < 0 {
+			return -1, false, false
+		}
+		return size, false, true
+	}
+
+	f, err := strconv.ParseFloat(col, 64)
+	if err != nil || f < 0 {
+		return -1, false, false
+	}
+
+	return int64(f * float64(multiplier)), true, true
+
+}
+
+// countingReader adds up how many bytes are read from r in n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,680 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davexre/sitescan/mocks"
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		input  string
+		size   int64
+		approx bool
+		ok     bool
+	}{
+		{"1234", 1234, false, true},
+		{"0", 0, false, true},
+		{"1.5K", 1536, true, true},
+		{"2M", 2 * 1024 * 1024, true, true},
+		{"1.0G", 1024 * 1024 * 1024, true, true},
+		{"3KiB", 3 * 1024, true, true},
+		{"-", -1, false, false},
+		{"", -1, false, false},
+		{"2021-06-27", -1, false, false},
+		{"15:45", -1, false, false},
+	}
+	for _, test := range tests {
+		size, approx, ok := ParseSize(test.input)
+		assert.Equal(test.size, size, test.input)
+		assert.Equal(test.approx, approx, test.input)
+		assert.Equal(test.ok, ok, test.input)
+	}
+}
+
+func TestListingSize(t *testing.T) {
+
+	var tests = []struct {
+		columns  []string
+		from, to int
+		size     int64
+		approx   bool
+	}{
+		{[]string{"2021-06-27", "15:45", "1.2K"}, 0, 2, 1228, true},
+		{[]string{"27", "Jun", "2021", "15:45", "5678"}, 0, 4, 5678, false},
+		{[]string{"2021-06-27", "15:45", "2", "KiB"}, 0, 2, 2048, true},
+		{[]string{"2", "KiB", "2021-06-27", "15:45"}, 2, 4, 2048, true},
+		{[]string{"512", "B"}, 0, 0, 512, false},
+		{[]string{"5678", "audio/mpeg"}, 0, 0, 5678, false},
+		{[]string{"2021-06-27", "15:45", "-"}, 0, 2, -1, false},
+		{nil, 0, 0, -1, false},
+	}
+	for _, test := range tests {
+		size, approx := listingSize(test.columns, test.from, test.to)
+		assert.Equal(t, test.size, size, "%q", test.columns)
+		assert.Equal(t, test.approx, approx, "%q", test.columns)
+	}
+}
+
+// Test site structure
+// someurl.com/
+//
+//	"Name"
+//	dir1/
+//	dir1/file11
+//	dir2/
+//	dir2/file21
+//	file3
+func TestWalkLink(t *testing.T) {
+
+	response := ""
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		urlReq := req.URL.String()
+		switch {
+		case urlReq == url:
+			response = `<a href="name">Name</a><a href="dir1/">dir1</a><a href="dir2/">dir2/</a><a href="file3.mp4">file3.mp4</a>`
+		case urlReq == url+"dir1/":
+			response = `<a href="file11.mp3">file11.mp3</a>`
+		case urlReq == url+"dir2/":
+			response = `<a href="file21.jpg">file21.jpg</a>`
+		default:
+			fmt.Printf("TestWalkLink - Invalid test URL - exiting\n")
+			os.Exit(1)
+		}
+		r := ioutil.NopCloser(bytes.NewReader([]byte(response)))
+		return &http.Response{
+			StatusCode: 200,
+			Body:       r,
+		}, nil
+	}
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	/// now, check our map!
+	assert.Equal(t, testmap.Snapshot()["dir1/"].URL, "dir1/", "map entry incorrect")
+	assert.Equal(t, testmap.Snapshot()["dir1/file11.mp3"].URL, "dir1/file11.mp3", "map entry incorrect")
+	assert.Equal(t, testmap.Snapshot()["dir2/"].URL, "dir2/", "map entry incorrect")
+	assert.Equal(t, testmap.Snapshot()["dir2/file21.jpg"].URL, "dir2/file21.jpg", "map entry incorrect")
+	assert.Equal(t, testmap.Snapshot()["file3.mp4"].URL, "file3.mp4", "map entry incorrect")
+	assert.Equal(t, testmap.Snapshot()["file3.mp4"].Size, int64(-1), "size should be unknown")
+
+}
+
+// Apache style <pre> listings put the size after the anchor, and lighttpd style
+// listings put it in its own table column
+func TestWalkLinkSizes(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		response := ""
+		switch req.URL.String() {
+		case url:
+			response = `<pre><a href="dir1/">dir1/</a>           2021-06-27 15:45    -
+<a href="file1.mp4">file1.mp4</a>       2021-06-27 15:45  1.2K
+<a href="file2.mp4">file2.mp4</a>       2021-06-27 15:45  
+<a href="file3.mp4">file3.mp4</a>       27 Jun 2021 15:45  1.2K
+<a href="file4.mp4">file4.mp4</a>       Jun 27 2021 15:45  5678</pre>`
+		case url + "dir1/":
+			response = `<table><tr><td class="n"><a href="file11.mp3">file11.mp3</a></td>` +
+				`<td class="m">2021-Jun-27 15:45:00</td><td class="s">5678</td><td class="t">audio/mpeg</td></tr></table>`
+		default:
+			fmt.Printf("TestWalkLinkSizes - Invalid test URL - exiting\n")
+			os.Exit(1)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(response))),
+		}, nil
+	}
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	modTime := time.Date(2021, 6, 27, 15, 45, 0, 0, time.UTC)
+	assert.Equal(t, Entry{URL: "dir1/", Size: -1}, testmap.Snapshot()["dir1/"])
+	assert.Equal(t, Entry{URL: "file1.mp4", Size: 1228, SizeApprox: true, ModTime: modTime}, testmap.Snapshot()["file1.mp4"])
+	assert.Equal(t, Entry{URL: "file2.mp4", Size: -1, ModTime: modTime}, testmap.Snapshot()["file2.mp4"])
+	assert.Equal(t, Entry{URL: "file3.mp4", Size: 1228, SizeApprox: true, ModTime: modTime}, testmap.Snapshot()["file3.mp4"])
+	assert.Equal(t, Entry{URL: "file4.mp4", Size: 5678, ModTime: modTime}, testmap.Snapshot()["file4.mp4"])
+	assert.Equal(t, Entry{URL: "dir1/file11.mp3", Size: 5678, ModTime: modTime}, testmap.Snapshot()["dir1/file11.mp3"])
+
+}
+
+func TestListingTime(t *testing.T) {
+
+	want := time.Date(2021, 6, 27, 15, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		columns  []string
+		want     time.Time
+		from, to int
+	}{
+		{[]string{"2021-06-27", "15:45", "1.2K"}, want, 0, 2},
+		{[]string{"27-Jun-2021", "15:45", "5678"}, want, 0, 2},
+		{[]string{"2021-Jun-27 15:45:00", "5678", "audio/mpeg"}, want, 0, 1},
+		{[]string{"27", "Jun", "2021", "15:45", "-"}, want, 0, 4},
+		{[]string{"-", "Jun", "27", "2021", "15:45"}, want, 1, 5},
+		{[]string{"2021-06-27T15:45:00Z"}, want, 0, 1},
+		{[]string{"yesterday", "5678"}, time.Time{}, 0, 0},
+		{nil, time.Time{}, 0, 0},
+	}
+
+	for _, test := range tests {
+		got, from, to := listingTime(test.columns)
+		assert.Equal(t, test.want, got, "%q", test.columns)
+		assert.Equal(t, []int{test.from, test.to}, []int{from, to}, "%q", test.columns)
+	}
+}
+
+func TestIgnoreLink(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := ScanOptions{
+		IgnoreText:     map[string]bool{"Name": true, "Parent Directory": true},
+		IgnorePatterns: []*regexp.Regexp{regexp.MustCompile(`^\?C=[NMSD];O=[AD]$`), regexp.MustCompile(`^Thumbs\.db$`)},
+	}
+
+	assert.True(opts.ignoreLink("Name", "name"))
+	assert.True(opts.ignoreLink("Parent Directory", "../"))
+	assert.True(opts.ignoreLink("Last modified", "?C=M;O=A"))
+	assert.True(opts.ignoreLink("sort", "?C=S;O=A"))
+	assert.True(opts.ignoreLink("Thumbs.db", "Thumbs.db"))
+	assert.False(opts.ignoreLink("file.mp4", "file.mp4"))
+	assert.False(opts.ignoreLink("dir1/", "dir1/"))
+}
+
+// serveListings points the mock client at a set of canned directory listings,
+// keyed by URL. Anything else gets a 404. The URLs that were requested are
+// recorded in the returned slice.
+func serveListings(listings map[string]string) *[]string {
+
+	var requested []string
+	var m sync.Mutex
+
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		m.Lock()
+		requested = append(requested, req.URL.String())
+		m.Unlock()
+		response, exists := listings[req.URL.String()]
+		status := 200
+		if !exists {
+			status = 404
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(response))),
+		}, nil
+	}
+
+	return &requested
+}
+
+func TestWalkLinkConcurrent(t *testing.T) {
+
+	url := "http://someurl.com/"
+	listings := map[string]string{url: ""}
+	for _, dir := range []string{"dir1/", "dir2/", "dir3/", "dir4/"} {
+		listings[url] += `<a href="` + dir + `">` + dir + `</a>`
+		listings[url+dir] = `<a href="sub/">sub/</a><a href="file1">file1</a><a href="file2">file2</a>`
+		listings[url+dir+"sub/"] = `<a href="file3">file3</a>`
+	}
+	requested := serveListings(listings)
+
+	var testmap = new(Map)
+	var counter Counter
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{Concurrency: 3})
+
+	assert.Len(t, testmap.Snapshot(), 4*5)
+	assert.Contains(t, testmap.Snapshot(), "dir3/sub/file3")
+	assert.Equal(t, 4*5, counter.Read())
+	assert.Equal(t, int64(3), counter.Depth.Load())
+
+	var html int
+	for _, listing := range listings {
+		html += len(listing)
+	}
+	assert.Equal(t, int64(html), counter.HTMLBytes.Load())
+	assert.Len(t, *requested, 1+4*2)
+}
+
+func TestWalkLinkMaxDepth(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	requested := serveListings(map[string]string{
+		url:                     `<a href="dir1/">dir1/</a><a href="file1">file1</a>`,
+		url + "dir1/":           `<a href="dir2/">dir2/</a><a href="file2">file2</a>`,
+		url + "dir1/dir2/":      `<a href="dir3/">dir3/</a><a href="file3">file3</a>`,
+		url + "dir1/dir2/dir3/": `<a href="file4">file4</a>`,
+	})
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{MaxDepth: 2})
+
+	assert.Equal(t, []string{url, url + "dir1/"}, *requested)
+	assert.Contains(t, testmap.Snapshot(), "dir1/dir2/")
+	assert.Contains(t, testmap.Snapshot(), "dir1/file2")
+	assert.NotContains(t, testmap.Snapshot(), "dir1/dir2/file3")
+}
+
+func TestWalkLinkExcluded(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	requested := serveListings(map[string]string{
+		url:           `<a href="tmp/">tmp/</a><a href="dir1/">dir1/</a><a href="file1.mp4">file1.mp4</a><a href="file2.txt">file2.txt</a>`,
+		url + "tmp/":  `<a href="file3.mp4">file3.mp4</a>`,
+		url + "dir1/": `<a href="file4.mp4">file4.mp4</a>`,
+	})
+
+	opts := ScanOptions{Include: []string{"*.mp4"}, Exclude: []string{"tmp/"}}
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, opts)
+
+	// the excluded directory's listing is never fetched, and only what's
+	// wanted is counted
+	assert.Equal(t, []string{url, url + "dir1/"}, *requested)
+	assert.ElementsMatch(t, []string{"file1.mp4", "dir1/file4.mp4"}, testmap.Keys())
+	assert.Equal(t, 2, counter.Files.Read())
+	assert.Equal(t, 0, counter.Dirs.Read())
+}
+
+func TestNormalizeURL(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"http://someurl.com/", "http://someurl.com/"},
+		{"HTTP://SomeURL.com/dir1/", "http://someurl.com/dir1/"},
+		{"http://someurl.com/dir1/../", "http://someurl.com/"},
+		{"http://someurl.com/dir1/../dir1/", "http://someurl.com/dir1/"},
+		{"http://someurl.com/dir1/./", "http://someurl.com/dir1/"},
+		{"http://someurl.com/dir1//", "http://someurl.com/dir1/"},
+		{"http://someurl.com/dir1/file#top", "http://someurl.com/dir1/file"},
+		{"http://someurl.com", "http://someurl.com/"},
+	}
+	for _, test := range tests {
+		assert.Equal(test.expected, normalizeURL(test.input), test.input)
+	}
+}
+
+func TestLinkName(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		href     string
+		text     string
+		expected string
+	}{
+		{"file1.mp3", "file1.mp3", "file1.mp3"},
+		{"dir1/", "dir1/", "dir1"},
+		{"it's%20here.mp3", "it's here.mp3", "it's here.mp3"},
+		{"it%27s%20here.mp3", "it's here.mp3", "it's here.mp3"},
+		{"caf%C3%A9/", "café/", "café"},
+		{"a-very-long-file-name.mp3", "a-very-long-file-n..>", "a-very-long-file-name.mp3"},
+		{"50%25%20off.txt", "50% off.txt", "50% off.txt"},
+		{"bad%zzescape", "bad escape", "bad escape"},
+		{"../dir1/", "again", "again"},
+		{"./", "here", "here"},
+	}
+	for _, test := range tests {
+		assert.Equal(test.expected, linkName(test.href, test.text), test.href)
+	}
+}
+
+func TestHrefPath(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		href     string
+		expected string
+	}{
+		{"file1.mp3", "file1.mp3"},
+		{"file1.mp3?download=1", "file1.mp3"},
+		{"dir1/?C=M;O=A", "dir1/"},
+		{"notes.txt#top", "notes.txt"},
+		{"notes.txt?", "notes.txt"},
+		{"odd%3Fname%23.txt", "odd%3Fname%23.txt"},
+		{"it's%20here.mp3?x=1", "it's%20here.mp3"},
+		{"?C=N;O=D", ""},
+		{"#top", ""},
+	}
+	for _, test := range tests {
+		assert.Equal(test.expected, hrefPath(test.href), test.href)
+	}
+}
+
+func TestWalkLinkQueries(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	requested := serveListings(map[string]string{
+		url: `<a href="?C=N;O=D">Sort by name</a><a href="#content">Skip</a>` +
+			`<a href="file1.mp3?download=1">file1.mp3</a><a href="notes.txt#top">notes.txt</a>` +
+			`<a href="odd%3Fname.txt">odd?name.txt</a><a href="dir1/?C=M;O=A">dir1/</a>`,
+		url + "dir1/": `<a href="file11.mp3?download=1">file11.mp3</a>`,
+	})
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	assert.Equal(t, []string{"dir1/", "dir1/file11.mp3", "file1.mp3", "notes.txt", "odd?name.txt"}, CompareMaps(testmap, new(Map), Options{}))
+	assert.Equal(t, []string{url, url + "dir1/"}, *requested)
+
+	// a file keeps the href it was given, but a directory can't
+	entry, _ := testmap.Get("dir1/file11.mp3")
+	assert.Equal(t, "dir1/file11.mp3?download=1", entry.URL)
+	entry, _ = testmap.Get("dir1/")
+	assert.Equal(t, "dir1/", entry.URL)
+}
+
+func TestWalkLinkExternal(t *testing.T) {
+
+	url := "http://someurl.com/a/"
+	var testmap = new(Map)
+	var counter Counter
+
+	// links from the root of the server only count if they lead under the
+	// listing they're in
+	requested := serveListings(map[string]string{
+		url: `<a href="file1.mp3">file1.mp3</a><a href="http://other-site.com/">Other site</a>` +
+			`<a href="https://other-site.com/dir1/">dir1/</a><a href="//cdn.example.com/file2.mp3">file2.mp3</a>` +
+			`<a href="mailto:admin@someurl.com">Contact</a><a href="javascript:void(0)">Menu</a>` +
+			`<a href="dir2/">dir2/</a><a href="/">Root</a><a href="/other/dir/">dir/</a><a href="/a/">a/</a>` +
+			`<a href="/a/dir3/">dir3/</a>`,
+		url + "dir2/": `<a href="file21.jpg">file21.jpg</a><a href="http://someurl.com/">Home</a>` +
+			`<a href="/a/dir2/file22.jpg">file22.jpg</a><a href="/a/">Up</a>`,
+		url + "dir3/": `<a href="/a/">Up</a>`,
+	})
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	assert.Equal(t, []string{"dir2/", "dir2/file21.jpg", "dir2/file22.jpg", "dir3/", "file1.mp3"}, CompareMaps(testmap, new(Map), Options{}))
+	assert.ElementsMatch(t, []string{url, url + "dir2/", url + "dir3/"}, *requested)
+	assert.Equal(t, 5, counter.Read())
+
+	entry, _ := testmap.Get("dir2/file22.jpg")
+	assert.Equal(t, "dir2/file22.jpg", entry.URL)
+}
+
+func TestExternalLink(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, href := range []string{"http://other-site.com/", "HTTPS://other-site.com/file", "//cdn.example.com/file",
+		"mailto:someone@example.com", "javascript:void(0)", "ftp://files.example.com/", " http://spaced.com/"} {
+		assert.True(externalLink(href), href)
+	}
+	for _, href := range []string{"file1.mp3", "dir1/", "it's%20here.mp3", "../dir1/", "#top", "?C=N;O=D"} {
+		assert.False(externalLink(href), href)
+	}
+}
+
+func TestUnderListing(t *testing.T) {
+	assert := assert.New(t)
+
+	for href, want := range map[string]string{"/pub/file1.mp3": "file1.mp3", "/pub/dir1/": "dir1/",
+		"/pub/dir1/file.txt?download=1": "dir1/file.txt?download=1"} {
+		rel, ok := underListing(href, "http://someurl.com/pub/")
+		assert.True(ok, href)
+		assert.Equal(want, rel, href)
+	}
+	for _, href := range []string{"/", "/pub/", "/pub/?C=N;O=D", "/other/dir/", "/public/file1.mp3"} {
+		_, ok := underListing(href, "http://someurl.com/pub/")
+		assert.False(ok, href)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("dir1/file1.mp3", EscapePath("dir1/file1.mp3"))
+	assert.Equal("caf%C3%A9/it%27s%20here.mp3", EscapePath("café/it's here.mp3"))
+	assert.Equal("50%25%20off%3F%23.txt", EscapePath("50% off?#.txt"))
+}
+
+// Apache and lighttpd encode the same names differently, but they come out the
+// same either way
+func TestWalkLinkEncoded(t *testing.T) {
+
+	apache, lighttpd := new(Map), new(Map)
+	var counter Counter
+
+	serveListings(map[string]string{
+		"http://apache.com/":             `<a href="it's%20here.mp3">it's here.mp3</a><a href="caf%C3%A9/">café/</a>`,
+		"http://apache.com/caf%C3%A9/":   `<a href="song.mp3">song.mp3</a>`,
+		"http://lighttpd.com/":           `<a href="it%27s%20here.mp3">it's here.mp3</a><a href="caf%C3%A9/">café</a>`,
+		"http://lighttpd.com/caf%C3%A9/": `<a href="song.mp3">song.mp3</a>`,
+	})
+
+	walkLink(context.Background(), "http://apache.com/", "", "", 1, apache, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+	walkLink(context.Background(), "http://lighttpd.com/", "", "", 1, lighttpd, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	assert.Equal(t, []string{"café/", "café/song.mp3", "it's here.mp3"}, CompareMaps(apache, new(Map), Options{}))
+	assert.Len(t, CompareMaps(apache, lighttpd, Options{}), 0)
+	assert.Len(t, CompareMaps(lighttpd, apache, Options{}), 0)
+
+	// the URL is still the one the server gave
+	entry, _ := lighttpd.Get("it's here.mp3")
+	assert.Equal(t, "it%27s%20here.mp3", entry.URL)
+}
+
+// a web server and a local copy of the same tree give the same keys, even with
+// the local path given with a trailing slash
+func TestWalkKeysMatch(t *testing.T) {
+
+	base, _ := ioutil.TempDir("", "sitescan")
+	defer os.RemoveAll(base)
+	assert.Nil(t, os.MkdirAll(filepath.Join(base, "dir1", "dir2"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(base, "dir1", "file1.mp3"), []byte("one"), 0644))
+
+	local, web := new(Map), new(Map)
+	var counter Counter
+
+	walkFS(context.Background(), base+"/", local, &counter, ScanOptions{})
+
+	serveListings(map[string]string{
+		"http://someurl.com/":           `<a href="dir1/">dir1</a>`,
+		"http://someurl.com/dir1/":      `<a href="dir2/">dir2/</a><a href="file1.mp3">file1.mp3</a>`,
+		"http://someurl.com/dir1/dir2/": ``,
+	})
+	walkLink(context.Background(), "http://someurl.com/", "", "", 1, web, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	assert.Equal(t, []string{"dir1/", "dir1/dir2/", "dir1/file1.mp3"}, CompareMaps(local, new(Map), Options{}))
+	assert.Len(t, CompareMaps(local, web, Options{}), 0)
+	assert.Len(t, CompareMaps(web, local, Options{}), 0)
+}
+
+func TestWalkLinkCycle(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	requested := serveListings(map[string]string{
+		url:           `<a href="dir1/">dir1/</a><a href="./">here</a>`,
+		url + "dir1/": `<a href="../">up</a><a href="../dir1/">again</a><a href="file1">file1</a>`,
+	})
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	assert.Equal(t, []string{url, url + "dir1/"}, *requested)
+	assert.Contains(t, testmap.Snapshot(), "dir1/file1")
+}
+
+func TestWalkLinkFetchErrors(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	var walkErrors ErrorList
+
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		response := ""
+		switch req.URL.String() {
+		case url:
+			response = `<a href="dir1/">dir1/</a><a href="dir2/">dir2/</a>`
+		case url + "dir1/":
+			return nil, fmt.Errorf("connection reset by peer")
+		case url + "dir2/":
+			response = `<a href="file21">file21</a>`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(response))),
+		}, nil
+	}
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	// the failure in dir1 shouldn't stop us finding what's in dir2
+	assert.Contains(t, testmap.Snapshot(), "dir2/file21")
+
+	errs := walkErrors.List()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, url+"dir1/", errs[0].URL)
+	}
+}
+
+func TestWalkLinkBadStatus(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	var walkErrors ErrorList
+
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		status, response := 200, ""
+		switch req.URL.String() {
+		case url:
+			response = `<a href="missing/">missing/</a><a href="private/">private/</a><a href="file1">file1</a>`
+		case url + "missing/":
+			status, response = 404, `<a href="/">Back to the home page</a>`
+		case url + "private/":
+			status, response = 401, `<a href="/login">Log in</a>`
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(response))),
+		}, nil
+	}
+
+	walkLink(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	// nothing from the error pages should end up in the map
+	assert.Equal(t, []string{"file1", "missing/", "private/"}, CompareMaps(testmap, new(Map), Options{}))
+
+	errs := walkErrors.List()
+	if assert.Len(t, errs, 2) {
+		assert.Contains(t, errs[0].Err.Error(), "404")
+		assert.Contains(t, errs[1].Err.Error(), "authentication failed")
+	}
+}
+
+func TestWalkLinkCancelled(t *testing.T) {
+
+	url := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	var walkErrors ErrorList
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requested []string
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		if req.URL.String() == url+"dir1/" {
+			// interrupted while fetching dir1
+			cancel()
+			return nil, req.Context().Err()
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(`<a href="dir1/">dir1/</a><a href="dir2/">dir2/</a>`))),
+		}, nil
+	}
+
+	walkLink(ctx, url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	// what was found before is kept, but dir2 is never asked for, and the
+	// abandoned request isn't an error
+	assert.Equal(t, []string{url, url + "dir1/"}, requested)
+	assert.Equal(t, []string{"dir1/", "dir2/"}, CompareMaps(testmap, new(Map), Options{}))
+	assert.Len(t, walkErrors.List(), 0)
+}
+
+func TestWalkLinkRedirect(t *testing.T) {
+
+	site := "http://someurl.com/"
+	var testmap = new(Map)
+	var counter Counter
+
+	var walkErrors ErrorList
+
+	// old/ has moved to new/, and away/ to another host altogether
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		final, response := req.URL.String(), ""
+		switch final {
+		case site:
+			response = `<a href="old/">old/</a><a href="away/">away/</a>`
+		case site + "old/":
+			final, response = site+"new/", `<a href="file1">file1</a>`
+		case site + "away/":
+			final, response = "http://elsewhere.com/away/", `<a href="file2">file2</a>`
+		}
+		finalURL, _ := url.Parse(final)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(response))),
+			Request:    &http.Request{URL: finalURL},
+		}, nil
+	}
+
+	walkLink(context.Background(), site, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	assert.Equal(t, []string{"away/", "old/", "old/file1"}, CompareMaps(testmap, new(Map), Options{}))
+	entry, _ := testmap.Get("old/file1")
+	assert.Equal(t, "new/file1", entry.URL)
+
+	errs := walkErrors.List()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, site+"away/", errs[0].URL)
+		assert.Contains(t, errs[0].Err.Error(), "elsewhere.com")
+	}
+}
@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/davexre/sitescan/checksum"
+	"github.com/davexre/sitescan/webhandler"
+)
+
+// errChecksumMismatch is what a download that doesn't match its published
+// checksum fails with, so that Download can pick those out to retry.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// loadManifest fetches the Manifest from the site and returns the checksums in
+// it, keyed by path relative to remotepath. A manifest lists its files relative
+// to wherever it lives, so one in a subdirectory has that directory put in
+// front of them. A Manifest that's a relative path is on the site, under
+// remotepath.
+func (d *downloader) loadManifest(ctx context.Context) (map[string]string, error) {
+
+	manifest, remotepath := d.Manifest, d.remotepath
+
+	location, dir := manifest, ""
+	if URLScheme(manifest) == "file" && !filepath.IsAbs(manifest) {
+		location = remotepath + manifest
+		dir = path.Dir(manifest)
+	} else if strings.HasPrefix(manifest, remotepath) {
+		dir = path.Dir(strings.TrimPrefix(manifest, remotepath))
+	}
+
+	data, err := d.fetchRemote(ctx, location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch manifest <%s>: %w", location, err)
+	}
+
+	listed, err := checksum.ParseManifest(bytes.NewReader(data), d.ManifestFormat)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest <%s>: %w", location, err)
+	}
+
+	sums := make(map[string]string, len(listed))
+	for name, sum := range listed {
+		sums[path.Join(dir, name)] = sum
+	}
+
+	return sums, nil
+
+}
+
+// expectedChecksum finds the published checksum for file (relative to
+// remotepath) - from the manifest, if there is one, or otherwise from its
+// sidecar file, with ChecksumSuffix on the end of its name. It's "" if the file
+// isn't in the manifest, or has no sidecar, since there's nothing to check it
+// against - or if checksums aren't being verified at all.
+func (d *downloader) expectedChecksum(ctx context.Context, file string) (string, error) {
+
+	switch {
+	case d.sums != nil:
+		return d.sums[file], nil
+	case !d.VerifyChecksums:
+		return "", nil
+	}
+
+	remotepath := d.remotepath
+	location := remotepath + file + d.ChecksumSuffix
+	if IsHTTP(remotepath) {
+		location = remotepath + EscapePath(file+d.ChecksumSuffix)
+	}
+
+	data, err := d.fetchRemote(ctx, location)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("unable to fetch checksum file: %w", err)
+	}
+
+	return checksum.ParseSidecar(bytes.NewReader(data))
+
+}
+
+// verifyChecksum checks the file at target against the checksum expected for
+// it. One that doesn't match is no use to anybody - not even to resume - so
+// it's deleted.
+func (d *downloader) verifyChecksum(target, expected string) error {
+
+	sum, err := checksum.File(target, d.ChecksumAlgo)
+	if err != nil {
+		return err
+	}
+
+	if sum != expected {
+		os.Remove(target)
+		return fmt.Errorf("%w: got %s, expected %s", errChecksumMismatch, sum, expected)
+	}
+
+	return nil
+
+}
+
+// fetchRemote reads the whole of a (small) file from the site - a web server,
+// FTP server or local path. A file that isn't there comes back as os.ErrNotExist,
+// whichever kind of site it's missing from.
+func (d *downloader) fetchRemote(ctx context.Context, location string) ([]byte, error) {
+
+	switch {
+
+	case IsHTTP(location):
+		response, err := webhandler.HTTPHandlerWithOptions(ctx, location, d.Site)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode == http.StatusNotFound {
+			return nil, os.ErrNotExist
+		} else if response.StatusCode < 200 || response.StatusCode > 299 {
+			return nil, fmt.Errorf("server returned %d %s", response.StatusCode, http.StatusText(response.StatusCode))
+		}
+		return io.ReadAll(response.Body)
+
+	case IsFTP(location):
+		tmp, err := os.CreateTemp("", "sitescan-checksum")
+		if err != nil {
+			return nil, err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		dir, file := path.Split(location)
+		var ftpErr *textproto.Error
+		if _, err := d.ftpDownload(ctx, dir, file, tmp.Name()); errors.As(err, &ftpErr) && ftpErr.Code == 550 {
+			return nil, os.ErrNotExist
+		} else if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(tmp.Name())
+
+	default:
+		return os.ReadFile(location)
+
+	}
+
+}
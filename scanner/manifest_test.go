@@ -0,0 +1,157 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davexre/sitescan/checksum"
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func sha256Of(t *testing.T, s string) string {
+	sum, err := checksum.Reader(strings.NewReader(s), "sha256")
+	assert.Nil(t, err)
+	return sum
+}
+
+func TestLoadManifest(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	assert.Nil(t, os.MkdirAll(filepath.Join(srcdir, "sub"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, "sub", "SHA256SUMS"),
+		[]byte(sha256Of(t, "one")+"  file1\n"+sha256Of(t, "two")+" *./dir/file2\n"), 0644))
+
+	d := testDownloader("", srcdir+"/", testOptions(ioutil.Discard))
+
+	// the files it lists are relative to the manifest's own directory
+	d.Manifest = "sub/SHA256SUMS"
+	sums, err := d.loadManifest(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"sub/file1": sha256Of(t, "one"), "sub/dir/file2": sha256Of(t, "two")}, sums)
+
+	d.Manifest = "sub/missing"
+	_, err = d.loadManifest(context.Background())
+	assert.NotNil(t, err)
+
+	d.ManifestFormat = "bsd"
+	d.Manifest = "sub/SHA256SUMS"
+	_, err = d.loadManifest(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestDownloadBatchChecksums(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	// file1's checksum file is right, file2's is wrong, and file3 doesn't have one
+	sidecars := map[string]string{
+		"/file1.sha256": sha256Of(t, "contents of /file1") + "  file1\n",
+		"/file2.sha256": sha256Of(t, "something else") + "  file2\n",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			if sum, exists := sidecars[r.URL.Path]; exists {
+				w.Write([]byte(sum))
+			} else {
+				http.NotFound(w, r)
+			}
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader("contents of "+r.URL.Path))
+	}))
+	defer ts.Close()
+
+	savedClient := webhandler.Client
+	defer func() { webhandler.Client = savedClient }()
+	webhandler.Client = webhandler.NewClient()
+
+	opts := testOptions(ioutil.Discard)
+	opts.VerifyChecksums = true
+
+	d := testDownloader(dstdir+"/", ts.URL+"/", opts)
+	d.batch(context.Background(), []string{"file1", "file2", "file3"})
+
+	for _, file := range []string{"file1", "file3"} {
+		contents, err := ioutil.ReadFile(filepath.Join(dstdir, file))
+		assert.Nil(t, err, file)
+		assert.Equal(t, "contents of /"+file, string(contents))
+	}
+
+	// the corrupt download is thrown away altogether
+	for _, file := range []string{"file2", "file2" + opts.PartialSuffix} {
+		_, err := os.Stat(filepath.Join(dstdir, file))
+		assert.True(t, os.IsNotExist(err), file)
+	}
+
+	errs := d.progress.Errors.List()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, ts.URL+"/file2", errs[0].URL)
+		assert.True(t, errors.Is(errs[0].Err, errChecksumMismatch))
+	}
+}
+
+func TestDownloadChecksumRetries(t *testing.T) {
+
+	dstdir, _ := ioutil.TempDir("", "sitescan-dst")
+	defer os.RemoveAll(dstdir)
+
+	// file1 comes through garbled the first time
+	var m sync.Mutex
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/SHA256SUMS":
+			w.Write([]byte(sha256Of(t, "good") + "  file1\n"))
+		case "/file1":
+			m.Lock()
+			fetches++
+			contents := "good"
+			if fetches == 1 {
+				contents = "bad!"
+			}
+			m.Unlock()
+			w.Write([]byte(contents))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	savedClient := webhandler.Client
+	defer func() { webhandler.Client = savedClient }()
+	webhandler.Client = webhandler.NewClient()
+
+	var progress Progress
+	opts := testOptions(ioutil.Discard)
+	opts.Manifest = "SHA256SUMS"
+	opts.ChecksumRetries = 1
+	opts.Progress = &progress
+
+	totals, err := Download(context.Background(), dstdir, ts.URL, []string{"file1"}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, Totals{Queued: 1, Succeeded: 1}, totals)
+
+	contents, err := ioutil.ReadFile(filepath.Join(dstdir, "file1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "good", string(contents))
+	assert.Equal(t, 2, fetches)
+	assert.Len(t, progress.Errors.List(), 0)
+	assert.Equal(t, 1, progress.Finished.Read())
+
+	// and a manifest that can't be loaded stops the run before it starts
+	opts.Manifest = "missing"
+	_, err = Download(context.Background(), dstdir, ts.URL, []string{"file1"}, opts)
+	assert.NotNil(t, err)
+}
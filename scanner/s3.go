@@ -0,0 +1,157 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/davexre/sitescan/webhandler"
+)
+
+// s3Backend lists the objects in an S3 bucket, under a prefix.
+type s3Backend struct{}
+
+func init() {
+	RegisterBackend("s3", s3Backend{})
+}
+
+func (s3Backend) Walk(ctx context.Context, s *Site, opts ScanOptions) {
+
+	bucket, prefix, err := parseS3URL(s.URL)
+	if err != nil {
+		opts.Fail(s.URL, err)
+		return
+	}
+
+	client, err := s3Client(s.Opts, opts)
+	if err != nil {
+		opts.Fail(s.URL, err)
+		return
+	}
+
+	walkS3(ctx, client, bucket, prefix, s.Map, &s.Counter, opts)
+
+}
+
+// parseS3URL splits s3://bucket/some/prefix into the bucket and the prefix. A
+// prefix always ends in "/", so it only matches whole "directories".
+func parseS3URL(u string) (string, string, error) {
+
+	parsed, err := neturl.Parse(u)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Host == "" {
+		return "", "", fmt.Errorf("no bucket given in <%s>", u)
+	}
+
+	prefix := strings.TrimPrefix(parsed.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return parsed.Host, prefix, nil
+
+}
+
+// s3Client sets up an S3 client with the standard AWS credential chain, and any
+// overrides in scan. A user and password on the site are taken as an access key
+// ID and secret access key.
+func s3Client(opts webhandler.Options, scan ScanOptions) (*s3.Client, error) {
+
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if scan.S3Region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(scan.S3Region))
+	}
+	if scan.S3Profile != "" {
+		loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(scan.S3Profile))
+	}
+	if opts.User != "" {
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(opts.User, opts.Pass, opts.Token)))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), loadOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.HTTPClient = webhandler.NewSiteClient(opts)
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if scan.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(scan.S3Endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+
+}
+
+// walkS3 builds the site map from every object under prefix, one page of
+// ListObjectsV2 at a time. S3 doesn't really have directories, just keys with
+// "/" in them, so we make up a directory entry for each "/" in a key, to match
+// what the other walkers find. scan.MaxDepth is applied the same way, too - a
+// directory at scan.MaxDepth is listed, but nothing inside it is.
+func walkS3(ctx context.Context, client s3.ListObjectsV2APIClient, bucket, prefix string, siteMap *Map, counter *Counter, scan ScanOptions) {
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			scan.Fail(fmt.Sprintf("s3://%s/%s", bucket, prefix), err)
+			return
+		}
+
+		for _, object := range page.Contents {
+
+			relpath := strings.TrimPrefix(aws.ToString(object.Key), prefix)
+			if relpath == "" {
+				continue
+			}
+
+			// every "directory" leading up to the object
+			for i, c := range relpath {
+				if c != '/' {
+					continue
+				}
+				dirname := EntryKey("", relpath[:i], true)
+				if scan.MaxDepth > 0 && strings.Count(dirname, "/") > scan.MaxDepth {
+					break
+				}
+				if _, exists := siteMap.Get(dirname); !exists && scan.Wanted(dirname) {
+					counter.Incr(true)
+					siteMap.Set(dirname, Entry{URL: dirname, Size: -1})
+				}
+			}
+
+			// a key ending in "/" is just a placeholder for an empty directory
+			if strings.HasSuffix(relpath, "/") {
+				continue
+			}
+			if scan.MaxDepth > 0 && strings.Count(relpath, "/") >= scan.MaxDepth {
+				continue
+			}
+
+			counter.Reached(strings.Count(relpath, "/") + 1)
+			if scan.Wanted(relpath) {
+				counter.Incr(false)
+				siteMap.Set(relpath, Entry{URL: relpath, Size: aws.ToInt64(object.Size)})
+			}
+
+		}
+
+	}
+
+}
@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 serves a canned ListObjectsV2 result, a page at a time.
+type fakeS3 struct {
+	pages    [][]types.Object
+	requests []*s3.ListObjectsV2Input
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+
+	f.requests = append(f.requests, in)
+
+	page := 0
+	if in.ContinuationToken != nil {
+		page = int(aws.ToString(in.ContinuationToken)[0] - '0')
+	}
+
+	out := &s3.ListObjectsV2Output{Contents: f.pages[page]}
+	if page+1 < len(f.pages) {
+		out.IsTruncated = aws.Bool(true)
+		out.NextContinuationToken = aws.String(string(rune('0' + page + 1)))
+	}
+
+	return out, nil
+}
+
+func object(key string, size int64) types.Object {
+	return types.Object{Key: aws.String(key), Size: aws.Int64(size)}
+}
+
+func TestWalkS3(t *testing.T) {
+
+	client := &fakeS3{pages: [][]types.Object{
+		{object("releases/v1/app.tar.gz", 100), object("releases/v1/notes.txt", 10)},
+		{object("releases/v2/", 0), object("releases/v2/linux/app.tar.gz", 200), object("releases/README", 5)},
+	}}
+
+	var counter Counter
+	testmap := new(Map)
+	walkS3(context.Background(), client, "bucket", "releases/", testmap, &counter, ScanOptions{})
+
+	assert.Equal(t, map[string]Entry{
+		"v1/":                 {URL: "v1/", Size: -1},
+		"v1/app.tar.gz":       {URL: "v1/app.tar.gz", Size: 100},
+		"v1/notes.txt":        {URL: "v1/notes.txt", Size: 10},
+		"v2/":                 {URL: "v2/", Size: -1},
+		"v2/linux/":           {URL: "v2/linux/", Size: -1},
+		"v2/linux/app.tar.gz": {URL: "v2/linux/app.tar.gz", Size: 200},
+		"README":              {URL: "README", Size: 5},
+	}, testmap.Snapshot())
+
+	// both pages were asked for
+	if assert.Len(t, client.requests, 2) {
+		assert.Equal(t, "bucket", aws.ToString(client.requests[0].Bucket))
+		assert.Equal(t, "releases/", aws.ToString(client.requests[0].Prefix))
+		assert.Equal(t, "1", aws.ToString(client.requests[1].ContinuationToken))
+	}
+}
+
+func TestWalkS3MaxDepth(t *testing.T) {
+
+	client := &fakeS3{pages: [][]types.Object{
+		{object("file1", 1), object("dir1/file2", 2), object("dir1/dir2/file3", 3)},
+	}}
+
+	var counter Counter
+	testmap := new(Map)
+	walkS3(context.Background(), client, "bucket", "", testmap, &counter, ScanOptions{MaxDepth: 1})
+
+	assert.Equal(t, map[string]Entry{
+		"file1": {URL: "file1", Size: 1},
+		"dir1/": {URL: "dir1/", Size: -1},
+	}, testmap.Snapshot())
+}
+
+func TestParseS3URL(t *testing.T) {
+
+	bucket, prefix, err := parseS3URL("s3://my-bucket/some/prefix")
+	assert.Nil(t, err)
+	assert.Equal(t, "my-bucket", bucket)
+	assert.Equal(t, "some/prefix/", prefix)
+
+	bucket, prefix, err = parseS3URL("s3://my-bucket")
+	assert.Nil(t, err)
+	assert.Equal(t, "my-bucket", bucket)
+	assert.Equal(t, "", prefix)
+
+	_, _, err = parseS3URL("s3:///no-bucket")
+	assert.NotNil(t, err)
+}
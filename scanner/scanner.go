@@ -0,0 +1,219 @@
+// Package scanner is the part of sitescan that other Go programs can use:
+// ScanSite walks a site into a Map, the Compare functions find the differences
+// between two of them, and Download fetches what's missing. Each takes its
+// settings in an options struct, so nothing depends on the sitescan command,
+// which is a thin layer over this package.
+package scanner
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davexre/sitescan/syncedmap"
+)
+
+// Entry records what we know about a single file or directory at a site. Size
+// is -1 when the site doesn't tell us, and SizeApprox is set when the size came
+// from a human readable listing column like "1.2K". ModTime is the zero time
+// when the site doesn't tell us when the file was last modified.
+type Entry struct {
+	URL        string    `json:"url"`
+	Size       int64     `json:"size"`
+	SizeApprox bool      `json:"size_approx,omitempty"`
+	ModTime    time.Time `json:"mod_time,omitzero"`
+}
+
+// Map maps the relative name of every file and directory at a site to what we
+// know about it. Directory names always end in "/". It's a syncedmap.Map, so a
+// walk can fill it in from any number of goroutines.
+type Map = syncedmap.Map[string, Entry]
+
+// SizeDiff describes a file that exists at both sites, but with different
+// sizes.
+type SizeDiff struct {
+	Name  string `json:"path"`
+	Site1 Entry  `json:"site1"`
+	Site2 Entry  `json:"site2"`
+}
+
+// NewerDiff describes a file that exists at both sites, but has been modified
+// more recently at site 2.
+type NewerDiff struct {
+	Name  string `json:"path"`
+	Site1 Entry  `json:"site1"`
+	Site2 Entry  `json:"site2"`
+}
+
+// Options change how two maps are compared. The zero value compares keys
+// exactly, and lists directories along with files.
+type Options struct {
+	// IgnoreCase matches up keys that only differ in upper/lower case
+	IgnoreCase bool
+
+	// SuppressDirs leaves directories out of what CompareMaps lists
+	SuppressDirs bool
+}
+
+// CompareMaps lists the entries in sm1 that have no match in sm2, sorted. With
+// IgnoreCase, an entry matches regardless of case, but is still listed with the
+// case it has in sm1.
+func CompareMaps(sm1, sm2 *Map, opts Options) []string {
+
+	var filelist []string
+	index := CaseIndex(sm2, opts.IgnoreCase)
+
+	keys := sm1.Keys()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if _, exists := Lookup(sm2, index, k); exists {
+			continue
+		}
+		if strings.HasSuffix(k, "/") && opts.SuppressDirs {
+			continue
+		}
+		filelist = append(filelist, k)
+	}
+
+	return filelist
+
+}
+
+// CompareSizes finds the files that exist in both maps, but whose sizes don't
+// match. Directories, and any file that one of the sites didn't give us a size
+// for, are skipped.
+func CompareSizes(sm1, sm2 *Map, opts Options) []SizeDiff {
+
+	var diffs []SizeDiff
+	index := CaseIndex(sm2, opts.IgnoreCase)
+
+	keys := sm1.Keys()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if strings.HasSuffix(k, "/") {
+			continue
+		}
+		e1, _ := sm1.Get(k)
+		e2, exists := Lookup(sm2, index, k)
+		if !exists || e1.Size < 0 || e2.Size < 0 {
+			continue
+		}
+		if SizesDiffer(e1, e2) {
+			diffs = append(diffs, SizeDiff{Name: k, Site1: e1, Site2: e2})
+		}
+	}
+
+	return diffs
+
+}
+
+// SizesDiffer decides whether two sizes are really different. Exact sizes have
+// to match exactly, but a human readable size like "1.2K" has been rounded by the
+// server, so we allow some slop (5%) when either side is approximate.
+func SizesDiffer(e1, e2 Entry) bool {
+
+	if !e1.SizeApprox && !e2.SizeApprox {
+		return e1.Size != e2.Size
+	}
+
+	diff, largest := e1.Size-e2.Size, e1.Size
+	if diff < 0 {
+		diff = -diff
+	}
+	if e2.Size > largest {
+		largest = e2.Size
+	}
+
+	return diff*20 > largest
+
+}
+
+// CompareTimes finds the files that exist in both maps, but were modified more
+// recently at site 2 - the ones worth downloading again. Listings usually only
+// give the time to the minute, so both times are truncated to the minute before
+// they're compared. Directories, and any file that one of the sites didn't give
+// us a time for, are skipped.
+func CompareTimes(sm1, sm2 *Map, opts Options) []NewerDiff {
+
+	var diffs []NewerDiff
+	index := CaseIndex(sm2, opts.IgnoreCase)
+
+	keys := sm1.Keys()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if strings.HasSuffix(k, "/") {
+			continue
+		}
+		e1, _ := sm1.Get(k)
+		e2, exists := Lookup(sm2, index, k)
+		if !exists || e1.ModTime.IsZero() || e2.ModTime.IsZero() {
+			continue
+		}
+		if e2.ModTime.Truncate(time.Minute).After(e1.ModTime.Truncate(time.Minute)) {
+			diffs = append(diffs, NewerDiff{Name: k, Site1: e1, Site2: e2})
+		}
+	}
+
+	return diffs
+
+}
+
+// CaseIndex maps each key in a site map, folded to lower case, to the keys that
+// fold to it - sorted, so lookups are repeatable. It's only needed when
+// ignoreCase is set, so otherwise it's nil.
+func CaseIndex(sm *Map, ignoreCase bool) map[string][]string {
+
+	if !ignoreCase {
+		return nil
+	}
+
+	index := make(map[string][]string)
+	for _, k := range sm.Keys() {
+		folded := strings.ToLower(k)
+		index[folded] = append(index[folded], k)
+	}
+	for _, keys := range index {
+		sort.Strings(keys)
+	}
+
+	return index
+
+}
+
+// Lookup finds the entry for key in a site map. An exact match always wins;
+// failing that, if there's a CaseIndex, any key that matches ignoring case will
+// do.
+func Lookup(sm *Map, index map[string][]string, key string) (Entry, bool) {
+
+	if entry, exists := sm.Get(key); exists {
+		return entry, true
+	}
+
+	if keys := index[strings.ToLower(key)]; len(keys) > 0 {
+		return sm.Get(keys[0])
+	}
+
+	return Entry{}, false
+
+}
+
+// CaseCollisions finds the groups of keys in a site map that are the same apart
+// from case. With IgnoreCase, each of them matches the same entry at the other
+// site, which the user ought to know about.
+func CaseCollisions(sm *Map) [][]string {
+
+	var collisions [][]string
+
+	for _, keys := range CaseIndex(sm, true) {
+		if len(keys) > 1 {
+			collisions = append(collisions, keys)
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i][0] < collisions[j][0] })
+
+	return collisions
+
+}
@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davexre/sitescan/syncedmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareMaps(t *testing.T) {
+
+	sm1 := syncedmap.New(map[string]Entry{"dir1/": {}, "dir1/a.txt": {}, "B.txt": {}, "c.txt": {}})
+	sm2 := syncedmap.New(map[string]Entry{"dir1/": {}, "b.txt": {}, "c.txt": {}, "d.txt": {}})
+
+	assert.Equal(t, []string{"B.txt", "dir1/a.txt"}, CompareMaps(sm1, sm2, Options{}))
+	assert.Equal(t, []string{"dir1/a.txt"}, CompareMaps(sm1, sm2, Options{IgnoreCase: true}))
+	assert.Equal(t, []string{"b.txt", "d.txt"}, CompareMaps(sm2, sm1, Options{}))
+
+	sm1.Set("only1/", Entry{})
+	assert.Equal(t, []string{"B.txt", "dir1/a.txt", "only1/"}, CompareMaps(sm1, sm2, Options{}))
+	assert.Equal(t, []string{"B.txt", "dir1/a.txt"}, CompareMaps(sm1, sm2, Options{SuppressDirs: true}))
+
+}
+
+func TestCompareSizes(t *testing.T) {
+
+	sm1 := syncedmap.New(map[string]Entry{"a": {Size: 100}, "b": {Size: 100}, "c": {Size: -1}, "d": {Size: 1000, SizeApprox: true}})
+	sm2 := syncedmap.New(map[string]Entry{"a": {Size: 100}, "B": {Size: 200}, "c": {Size: 5}, "d": {Size: 1040}})
+
+	assert.Nil(t, CompareSizes(sm1, sm2, Options{}))
+	assert.Equal(t, []SizeDiff{{Name: "b", Site1: Entry{Size: 100}, Site2: Entry{Size: 200}}}, CompareSizes(sm1, sm2, Options{IgnoreCase: true}))
+
+	assert.True(t, SizesDiffer(Entry{Size: 1000, SizeApprox: true}, Entry{Size: 1100}))
+	assert.False(t, SizesDiffer(Entry{Size: 1000, SizeApprox: true}, Entry{Size: 1040}))
+
+}
+
+func TestCompareTimes(t *testing.T) {
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sm1 := syncedmap.New(map[string]Entry{"old": {ModTime: now}, "same": {ModTime: now}, "unknown": {}})
+	sm2 := syncedmap.New(map[string]Entry{"old": {ModTime: now.Add(time.Hour)}, "same": {ModTime: now.Add(30 * time.Second)}, "unknown": {ModTime: now}})
+
+	assert.Equal(t, []NewerDiff{{Name: "old", Site1: Entry{ModTime: now}, Site2: Entry{ModTime: now.Add(time.Hour)}}}, CompareTimes(sm1, sm2, Options{}))
+
+}
+
+func TestCaseCollisions(t *testing.T) {
+
+	sm := syncedmap.New(map[string]Entry{"a.txt": {}, "A.txt": {}, "b.txt": {}})
+	assert.Equal(t, [][]string{{"A.txt", "a.txt"}}, CaseCollisions(sm))
+
+	entry, ok := Lookup(sm, CaseIndex(sm, true), "B.TXT")
+	assert.True(t, ok)
+	assert.Equal(t, Entry{}, entry)
+	_, ok = Lookup(sm, CaseIndex(sm, false), "B.TXT")
+	assert.False(t, ok)
+
+}
@@ -0,0 +1,201 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/davexre/synceddata"
+)
+
+// Site is one of the trees being scanned - a local path, a web server, an FTP
+// server, and so on - and everything ScanSite finds out about it.
+type Site struct {
+	Name string
+	URL  string
+
+	// Type says what kind of site it is, when its URL doesn't (see SiteType).
+	// Strip is a path inside it that its keys are made relative to, once it's
+	// been walked, for comparing trees kept at different depths.
+	Type  string
+	Strip string
+
+	// Opts carry everything webhandler needs to talk to the site -
+	// user/password, bearer token, extra headers and so on
+	Opts webhandler.Options
+
+	Map     *Map
+	Counter Counter
+
+	// Elapsed is how long the walk took
+	Elapsed time.Duration
+}
+
+// Counter keeps count of the files and directories a walk finds, apart, so a
+// progress display can show the shape of a site as well as its size. It also
+// keeps the deepest listing the walk got to, and how many bytes of HTML
+// listings it read. The zero value is ready to go, and it's safe for
+// concurrent use.
+type Counter struct {
+	Files     synceddata.Counter
+	Dirs      synceddata.Counter
+	Depth     atomic.Int64
+	HTMLBytes atomic.Int64
+}
+
+// Incr counts one more directory, if isDir, or file.
+func (c *Counter) Incr(isDir bool) {
+	if isDir {
+		c.Dirs.Incr()
+	} else {
+		c.Files.Incr()
+	}
+}
+
+// Read is how many files and directories have been found altogether.
+func (c *Counter) Read() int {
+	return c.Files.Read() + c.Dirs.Read()
+}
+
+// Split is how many of each have been found, as "12000 files, 340 dirs".
+func (c *Counter) Split() string {
+	return fmt.Sprintf("%d files, %d dirs", c.Files.Read(), c.Dirs.Read())
+}
+
+// Reached records that the walk has got to a listing depth directories deep,
+// if that's deeper than it's been so far.
+func (c *Counter) Reached(depth int) {
+	for {
+		deepest := c.Depth.Load()
+		if int64(depth) <= deepest || c.Depth.CompareAndSwap(deepest, int64(depth)) {
+			return
+		}
+	}
+}
+
+// VisitedSet tracks the URLs a walk has already fetched, so that links pointing
+// back up the tree don't send it around in circles. Like synceddata.Counter,
+// it's protected by a Mutex so it's safe for concurrent use, and the zero value
+// is ready to go.
+type VisitedSet struct {
+	m    sync.Mutex
+	urls map[string]bool
+}
+
+// Add records u as visited, and reports whether it's new - false means we've
+// been there before.
+func (vs *VisitedSet) Add(u string) bool {
+	vs.m.Lock()
+	defer vs.m.Unlock()
+	if vs.urls == nil {
+		vs.urls = make(map[string]bool)
+	}
+	if vs.urls[u] {
+		return false
+	}
+	vs.urls[u] = true
+	return true
+}
+
+// FetchError records a URL that couldn't be retrieved - by a walk, or a
+// download - and why.
+type FetchError struct {
+	URL string
+	Err error
+}
+
+// MarshalJSON writes a FetchError with its error as a plain message, since an
+// error value on its own doesn't have anything for encoding/json to work with.
+func (e FetchError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		URL   string `json:"url"`
+		Error string `json:"error"`
+	}{e.URL, e.Err.Error()})
+}
+
+// ErrorList collects FetchErrors from any number of goroutines at once. It's
+// protected by a Mutex, the same as VisitedSet.
+type ErrorList struct {
+	m    sync.Mutex
+	errs []FetchError
+}
+
+// Add records that url couldn't be retrieved.
+func (l *ErrorList) Add(url string, err error) {
+	l.m.Lock()
+	l.errs = append(l.errs, FetchError{URL: url, Err: err})
+	l.m.Unlock()
+}
+
+// List returns everything recorded so far.
+func (l *ErrorList) List() []FetchError {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return append([]FetchError(nil), l.errs...)
+}
+
+// Remove takes the errors that match out of the list, and returns them.
+func (l *ErrorList) Remove(match func(FetchError) bool) []FetchError {
+	l.m.Lock()
+	defer l.m.Unlock()
+	var removed, kept []FetchError
+	for _, e := range l.errs {
+		if match(e) {
+			removed = append(removed, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	l.errs = kept
+	return removed
+}
+
+// EntryKey makes the map key for something called name, found in the directory
+// parent (a key itself, or "" for the top of the site). Every backend builds its
+// keys here, so the same tree gets the same keys whatever kind of site it's on:
+// a directory's key always ends in exactly one "/", and a file's never does, no
+// matter how many slashes the name came with.
+func EntryKey(parent, name string, isDir bool) string {
+
+	name = strings.Trim(name, "/")
+	if isDir {
+		return parent + name + "/"
+	}
+
+	return parent + name
+
+}
+
+// URLScheme returns the (lowercased) scheme of a site's URL. Anything without a
+// "://" is a local path, and gets "file" - so neither "httpdocs/" nor "C:\files"
+// is mistaken for something else.
+func URLScheme(u string) string {
+
+	i := strings.Index(u, "://")
+	if i <= 0 {
+		return "file"
+	}
+
+	return strings.ToLower(u[:i])
+
+}
+
+// IsHTTP reports whether a site URL is an http:// or https:// one.
+func IsHTTP(u string) bool {
+	scheme := URLScheme(u)
+	return scheme == "http" || scheme == "https"
+}
+
+// IsFTP reports whether a site URL is an FTP one.
+func IsFTP(u string) bool {
+	return URLScheme(u) == "ftp"
+}
+
+// IsS3 reports whether a site URL is an S3 one.
+func IsS3(u string) bool {
+	return URLScheme(u) == "s3"
+}
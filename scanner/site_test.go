@@ -0,0 +1,33 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+
+	var c Counter
+	c.Incr(false)
+	c.Incr(true)
+	c.Incr(false)
+	c.Reached(3)
+	c.Reached(2)
+
+	assert.Equal(t, 3, c.Read())
+	assert.Equal(t, 2, c.Files.Read())
+	assert.Equal(t, 1, c.Dirs.Read())
+	assert.Equal(t, "2 files, 1 dirs", c.Split())
+	assert.Equal(t, int64(3), c.Depth.Load())
+
+}
+
+func TestVisitedSet(t *testing.T) {
+
+	var vs VisitedSet
+	assert.True(t, vs.Add("http://someurl.com/"))
+	assert.True(t, vs.Add("http://someurl.com/dir1/"))
+	assert.False(t, vs.Add("http://someurl.com/"))
+
+}
@@ -0,0 +1,134 @@
+package scanner
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/davexre/sitescan/webhandler"
+)
+
+// davBackend walks a WebDAV share, using PROPFIND listings instead of HTML.
+type davBackend struct{}
+
+func init() {
+	RegisterBackend("dav", davBackend{})
+	RegisterBackend("davs", davBackend{})
+}
+
+func (davBackend) Walk(ctx context.Context, s *Site, opts ScanOptions) {
+	var visited VisitedSet
+	walkDAV(ctx, s.URL, "", "", 1, s.Map, s.Opts, &visited, &s.Counter, opts)
+}
+
+// davMultistatus is the part of a PROPFIND response we care about - for each
+// resource, where it is, whether it's a collection (a directory), and its size.
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Prop struct {
+				Collection    *struct{} `xml:"DAV: resourcetype>collection"`
+				ContentLength string    `xml:"DAV: getcontentlength"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// walkDAV does for a WebDAV share what walkLink does for HTML listings, and
+// builds the same map. Each directory is listed with a Depth 1 PROPFIND (plenty
+// of servers refuse "Depth: infinity"), and walkDAV calls itself for each
+// directory it finds. depth, scan.MaxDepth, and visited work just as for walkLink.
+//
+// The server gives us each resource's href as an absolute (escaped) path. We
+// name entries with the unescaped last element of it, and keep the escaped one
+// for the URL.
+func walkDAV(ctx context.Context, urlprefix string, url string, currentName string, depth int, siteMap *Map,
+	opts webhandler.Options, visited *VisitedSet, counter *Counter, scan ScanOptions) {
+
+	urltoget := fmt.Sprintf("%s%s", urlprefix, url)
+
+	if !visited.Add(normalizeURL(urltoget)) {
+		slog.Debug("already visited - skipping", "url", urltoget)
+		return
+	}
+
+	response, err := webhandler.PropfindHandler(ctx, urltoget, opts)
+	if listingFailed(scan, urltoget, response, err) {
+		return
+	}
+
+	defer response.Body.Close()
+
+	counter.Reached(depth)
+
+	var listing davMultistatus
+	if err := xml.NewDecoder(response.Body).Decode(&listing); err != nil {
+		scan.Fail(urltoget, fmt.Errorf("unable to parse PROPFIND response: %v", err))
+		return
+	}
+
+	base, err := neturl.Parse(urltoget)
+	if err != nil {
+		scan.Fail(urltoget, err)
+		return
+	}
+
+	for _, r := range listing.Responses {
+
+		href, err := base.Parse(strings.TrimSpace(r.Href))
+		if err != nil {
+			continue
+		}
+
+		// the listing includes the directory itself
+		if strings.TrimSuffix(href.Path, "/") == strings.TrimSuffix(base.Path, "/") {
+			continue
+		}
+
+		isDir := false
+		size := int64(-1)
+		for _, ps := range r.Propstats {
+			if ps.Prop.Collection != nil {
+				isDir = true
+			}
+			if n, err := strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64); err == nil {
+				size = n
+			}
+		}
+
+		escaped := path.Base(strings.TrimSuffix(href.EscapedPath(), "/"))
+		ourname := EntryKey(currentName, path.Base(strings.TrimSuffix(href.Path, "/")), isDir)
+		oururl := url + escaped
+
+		if isDir {
+			oururl += "/"
+			size = -1
+		}
+
+		if isDir && scan.Excluded(ourname) {
+			slog.Debug("not descending - excluded", "dir", ourname)
+			continue
+		}
+
+		if scan.Wanted(ourname) {
+			counter.Incr(isDir)
+			siteMap.Set(ourname, Entry{URL: oururl, Size: size})
+		}
+
+		if isDir {
+			if scan.MaxDepth > 0 && depth >= scan.MaxDepth {
+				slog.Debug("not descending - max depth reached", "dir", ourname, "max-depth", scan.MaxDepth)
+			} else {
+				walkDAV(ctx, urlprefix, oururl, ourname, depth+1, siteMap, opts, visited, counter, scan)
+			}
+		}
+
+	}
+
+}
@@ -0,0 +1,127 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/davexre/sitescan/mocks"
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/stretchr/testify/assert"
+)
+
+const davRoot = `<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/share/</D:href>
+    <D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+  </D:response>
+  <D:response>
+    <D:href>/share/My%20Music/</D:href>
+    <D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+  </D:response>
+  <D:response>
+    <D:href>http://someurl.com/share/file1.txt</D:href>
+    <D:propstat><D:prop><D:resourcetype/><D:getcontentlength>1234</D:getcontentlength></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+  </D:response>
+</D:multistatus>`
+
+const davMusic = `<?xml version="1.0" encoding="utf-8"?>
+<multistatus xmlns="DAV:">
+  <response>
+    <href>/share/My%20Music/</href>
+    <propstat><prop><resourcetype><collection/></resourcetype></prop></propstat>
+  </response>
+  <response>
+    <href>/share/My%20Music/song%231.mp3</href>
+    <propstat><prop><resourcetype/><getcontentlength>42</getcontentlength></prop></propstat>
+  </response>
+</multistatus>`
+
+// serveDAV answers PROPFIND requests for the given URLs with their multistatus
+// listings (404 for anything else), and returns the methods that were used.
+func serveDAV(listings map[string]string) *[]string {
+
+	var methods []string
+
+	webhandler.Client = &mocks.MockClient{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		methods = append(methods, req.Method)
+		response, exists := listings[req.URL.String()]
+		status := 207
+		if !exists || req.Header.Get("Depth") != "1" {
+			status = 404
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(response))),
+		}, nil
+	}
+
+	return &methods
+}
+
+func TestWalkDAV(t *testing.T) {
+
+	url := "http://someurl.com/share/"
+	var testmap = new(Map)
+	var counter Counter
+
+	methods := serveDAV(map[string]string{url: davRoot, url + "My%20Music/": davMusic})
+
+	walkDAV(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{})
+
+	assert.Equal(t, map[string]Entry{
+		"My Music/":           {URL: "My%20Music/", Size: -1},
+		"My Music/song#1.mp3": {URL: "My%20Music/song%231.mp3", Size: 42},
+		"file1.txt":           {URL: "file1.txt", Size: 1234},
+	}, testmap.Snapshot())
+	assert.Equal(t, []string{"PROPFIND", "PROPFIND"}, *methods)
+	assert.Equal(t, 3, counter.Read())
+}
+
+func TestWalkDAVBadResponse(t *testing.T) {
+
+	url := "http://someurl.com/share/"
+	var testmap = new(Map)
+	var counter Counter
+
+	var walkErrors ErrorList
+
+	serveDAV(map[string]string{url: "this isn't XML"})
+
+	walkDAV(context.Background(), url, "", "", 1, testmap, webhandler.Options{}, &VisitedSet{}, &counter, ScanOptions{Failed: walkErrors.Add})
+
+	assert.Empty(t, testmap)
+	assert.Len(t, walkErrors.List(), 1)
+}
+
+func TestSiteType(t *testing.T) {
+
+	var tests = []struct {
+		configured, url string
+		wantType        string
+		wantURL         string
+		wantErr         bool
+	}{
+		{"", "http://someurl.com/", "", "http://someurl.com/", false},
+		{"WebDAV", "https://someurl.com/", "webdav", "https://someurl.com/", false},
+		{"", "dav://someurl.com/share/", "webdav", "http://someurl.com/share/", false},
+		{"", "davs://someurl.com/share/", "webdav", "https://someurl.com/share/", false},
+		{"local", "/some/path", "local", "/some/path", false},
+		{"gopher", "gopher://someurl.com/", "", "", true},
+	}
+
+	for _, test := range tests {
+		gotType, gotURL, err := SiteType(test.configured, test.url)
+		if test.wantErr {
+			assert.NotNil(t, err, test.configured)
+			continue
+		}
+		assert.Nil(t, err, test.configured)
+		assert.Equal(t, test.wantType, gotType, test.url)
+		assert.Equal(t, test.wantURL, gotURL, test.url)
+	}
+}
@@ -12,112 +12,516 @@
 // Note that the download option requires that Site 1 be a valid location in a local
 // filesystem, not a remote URL.
 //
-// The timeout option will cause the program to exit after a specified period of time.
-// Not that the download mechanism will pick up where it left off.
+// The timeout option will cause the program to stop after a specified period of time,
+// whether it's still scanning or downloading. A scan that's cut short still reports
+// the differences in what it found up to then (although the state file isn't
+// saved, and nothing is downloaded). Note that the download mechanism will pick up
+// where it left off - a partially downloaded file is resumed from where it
+// stopped, as long as the web server supports range requests (otherwise it's
+// downloaded again from the start).
 //
 // Command Line Usage:
 //
 //	-c, --config string      path to alternate configuration file
-//	-d, --debug              output debugging info
+//	    --credentials-file string
+//	                         a separate config file with the passwords and tokens
+//	                         in it, so the main one can be shared (see Credentials
+//	                         File)
+//	    --netrc string       read the logins for sites that haven't been given a
+//	                         user or password from this .netrc file (default
+//	                         $NETRC, or ~/.netrc)
+//	-d, --debug              output debugging info (the same as --log-level debug)
+//	    --log-level string   only show messages at this level or above - error,
+//	                         warn, info or debug (default "info")
+//	    --log-format string  how to write messages - text, or json for a log
+//	                         pipeline (see Logging) (default "text")
+//	    --version            print the version of sitescan, and exit
+//	    --print-config       print every setting, wherever it came from (config
+//	                         file, environment or command line), and exit -
+//	                         passwords, tokens, headers and cookies are masked
+//	    --completion string  print a tab completion script for this shell - bash,
+//	                         zsh or fish - and exit (see Shell Completion)
+//	    --init               write a starter sitescan_config.yaml, with every
+//	                         setting and its default, to the current directory, and
+//	                         exit (--force replaces one that's already there)
 //	-s, --suppress           suppress output of directories
+//	    --size-compare       also report files that exist on both sites, but
+//	                         have different sizes
+//	    --newer-than         also report files that exist on both sites, but
+//	                         were modified more recently on Site 2
+//	    --ignore-regex       skip links whose text or href matches this regular
+//	                         expression (may be repeated)
+//	    --include string     only compare files whose path (or name) matches this
+//	                         glob pattern (may be repeated)
+//	    --exclude string     never compare files whose path (or name) matches this
+//	                         glob pattern - wins over --include (may be repeated)
+//	    --extensions string  only compare files with one of these extensions, given
+//	                         as a comma separated list like "mp3,flac,jpg"
+//	                         (case doesn't matter)
+//	    --ignore-case        treat paths that only differ by upper/lower case as the
+//	                         same file (for comparing against macOS or Windows)
+//	    --fail-fast          stop the whole scan as soon as any page of a listing
+//	                         can't be retrieved, instead of reporting it at the end
+//	    --max-depth int      don't descend more than this many directories deep
+//	                         (0 means no limit)
+//	    --walk-concurrency int
+//	                         how many directory listings to fetch at once from
+//	                         each web server while walking it (default 1)
+//	    --stats              once the scan's done, report the requests made, HTML
+//	                         fetched, request latency, deepest directory, and
+//	                         time taken at each site (see Scan Statistics)
+//	    --notify-webhook string
+//	                         when the run's over, POST a JSON summary of it to
+//	                         this URL (see Notifications)
+//	    --notify-template string
+//	                         a Go template for what --notify-webhook sends, in
+//	                         place of the JSON summary
+//	    --notify-user string user for basic authentication with --notify-webhook
+//	    --notify-pass string password for basic authentication with
+//	                         --notify-webhook
+//	    --metrics-file string
+//	                         when the run's over, write Prometheus metrics about
+//	                         it to this file, for node_exporter's textfile
+//	                         collector (see Metrics)
+//	    --output-json        write the comparison as JSON instead of text (progress
+//	                         and status messages go to stderr, so it stays clean)
+//	    --output-csv         write the comparison as CSV (path, only_at, size1, size2,
+//	                         difference) instead of text, for spreadsheets
+//	    --sync-preview       show what a two-way sync would do - what would be
+//	                         pulled from Site 2, pushed to it, or left alone -
+//	                         without doing any of it (see Sync Preview)
+//	-q, --quiet              just list the paths that differ, one per line, with no
+//	                         headings or progress bar (see Quiet Output)
+//	    --no-markers         with --quiet, leave off the "<", ">" and "~" that say
+//	                         how each path differs
+//	-0, --print0             like --quiet, but end each path with a NUL instead of
+//	                         a newline, for xargs -0
+//	-f, --output-file string write the comparison to this file instead of stdout
+//	                         (the progress display stays on the terminal)
+//	    --append             add to the end of --output-file, rather than
+//	                         replacing it
+//	    --fail-on-diff       exit with status 2 if any differences are found
+//	    --checksum string    also report files that exist on both sites, but have
+//	                         different contents, using md5 or sha256 (default
+//	                         sha256 if no algorithm is given)
 //	    --download           automatically download files that exist on Site 2 that
 //	                         are missing for Site 1
 //	    --dryrun             requires --download, runs process without actually
-//	                         performing any downloads
-//	-n, --noprogress         don't show the progress bar (for unattended use)
-//	-t, --throttle           Number of concurrent download threads
-//	-o, --timeout            number of hours to run downloads before exiting
+//	                         performing any downloads, and summarizes how many
+//	                         files (and bytes) would have been downloaded
+//	    --upload             upload files that exist on Site 1 that are missing from
+//	                         Site 2, which has to accept PUT (see Uploading)
+//	    --verify             after each download, check that the file's size matches
+//	                         what the server (or source file) reported, leaving
+//	                         short files as partials to resume next time
+//	    --force              download files again, even if an earlier run already
+//	                         downloaded them and they match (in size, and with
+//	                         --verify, checksum)
+//	    --delete             after downloading, delete the local files that aren't
+//	                         at Site 2 any more (see Deleting Local Files)
+//	    --delete-dirs        with --delete, delete the local directories that
+//	                         aren't at Site 2 any more, too
+//	-y, --yes                don't ask before deleting anything with --delete
+//	    --preserve-times     give each downloaded file the modification time it
+//	                         has at the source (from the Last-Modified header, for
+//	                         a web server), rather than the time it was downloaded
+//	    --on-download-complete string
+//	                         run this command for each file that's downloaded,
+//	                         with {} replaced by its path (see Download Hooks)
+//	    --verify-checksums   after each download, check it against the checksum
+//	                         file published next to it (see --checksum-suffix)
+//	    --checksum-suffix string
+//	                         what's added to a file's name to find its checksum
+//	                         file (default ".sha256")
+//	    --manifest string    check each download against this checksum manifest
+//	                         instead, like "SHA256SUMS" - a path relative to Site 2,
+//	                         or a full URL
+//	    --manifest-format string
+//	                         how the manifest is laid out - gnu ("<sum>  <path>",
+//	                         as from sha256sum) or bsd ("SHA256 (<path>) = <sum>")
+//	                         (default "gnu")
+//	    --manifest-algo string
+//	                         the checksum algorithm the checksum files and manifest
+//	                         use - md5 or sha256 (default "sha256")
+//	    --checksum-retries int
+//	                         how many more times to download a file that doesn't
+//	                         match its checksum (default 0)
+//	    --state string       save what was found at each site to this file, and
+//	                         only report differences that weren't there the last
+//	                         time it was saved
+//	    --refresh            with --state, report every difference, as if there
+//	                         were no saved state (it's still saved afterwards)
+//	    --save-snapshot string
+//	                         save what was found at each site to a snapshot file
+//	                         in this directory, to compare against later as a
+//	                         snapshot:// site (see Snapshots)
+//	    --diff-snapshots strings
+//	                         compare two snapshot files, older first (as
+//	                         "old.json,new.json"), without going near the sites
+//	-n, --noprogress         don't show the progress bar (for unattended use) -
+//	                         a one line summary is logged every --heartbeat instead
+//	    --progress-interval duration
+//	                         how often the progress display is redrawn - longer
+//	                         is easier on a slow terminal or ssh link (default
+//	                         200ms)
+//	    --heartbeat duration with --noprogress, how often to log a line saying
+//	                         how the scan or downloads are going - 0 for never
+//	                         (default 1m0s)
+//	-t, --throttle           Number of concurrent download threads - requires
+//	                         --download or --upload (see --walk-concurrency for
+//	                         scanning)
+//	    --bandwidth-limit string
+//	                         cap how fast downloads can go, in bytes per second,
+//	                         like "500K" or "5MB" - shared by all the download
+//	                         threads, not each (default no limit)
+//	    --file-mode string   permissions for downloaded files, in octal - the
+//	                         umask still applies (default "0644"). Files copied
+//	                         from a local Site 2 keep their own permissions
+//	    --dir-mode string    permissions for directories created by downloads, in
+//	                         octal - the umask still applies (default "0755")
+//	-o, --timeout string     how long to run (scanning and downloading) before
+//	                         stopping, like "30m" or "2h30m" - a plain number is
+//	                         taken as hours
+//	    --log-file string    write each download worker's progress and errors to
+//	                         this file (with timestamps), leaving just a summary on
+//	                         the console
+//	    --partial-suffix string
+//	                         what to add to the name of a file while it's being
+//	                         downloaded, until it's complete (default ".sitescandl")
+//	    --http-timeout int   seconds to wait for any single page of a listing
+//	                         before giving up on it (0 means wait forever,
+//	                         default 30)
+//	    --request-rate float most requests per second to make to each web server
+//	                         while walking it, like 5 or 0.5 - each site has its
+//	                         own limit, so a slow one doesn't hold up the other
+//	                         (0 means no limit)
+//	    --retries int        how many times to retry a request after a network
+//	                         error, 5xx, or 429 response (default 2)
+//	    --retry-delay duration
+//	                         how long to wait before the first retry - each retry
+//	                         after that waits twice as long (default 1s)
+//	    --proxy string       send all HTTP(S) requests through this proxy URL
+//	                         (default uses HTTP_PROXY / HTTPS_PROXY)
+//	    --max-redirects int  how many redirects in a row to follow before giving up
+//	                         on a request (0 means no limit) (default 10)
+//	    --no-redirect        don't follow redirects at all
+//	    --insecure           don't verify the sites' TLS certificates, for
+//	                         self-signed test servers - never use this in
+//	                         production
+//	    --skip-tls-verify    same as --insecure
+//	    --ca-cert string     PEM file with CA certificates to trust, on top of
+//	                         the system's, for servers they signed
+//	    --s3-region string   AWS region for S3 sites (default from the standard AWS
+//	                         configuration)
+//	    --s3-endpoint string endpoint URL for S3 sites, for S3 compatible services
+//	                         like MinIO
+//	    --s3-profile string  AWS shared config profile to use for S3 sites
+//	    --user-agent string  User-Agent header to send with every request
+//	                         (default "sitescan/<version>")
 //	    --site1 string       Site 1 URL
 //	    --site1name string   Site 1 Name
 //	    --site1pass string   Site 1 Password
 //	    --site1user string   Site 1 User ID
+//	    --site1token string  Site 1 bearer token (sent instead of the user/password)
+//	    --site1-auth string how to send Site 1's user/password - basic or
+//	                         digest (default "basic")
+//	    --site1-type string what kind of site Site 1 is - html, webdav, ftp, s3,
+//	                         or local (default works it out from the URL)
+//	    --site1header        extra header to send to Site 1, as "Name: value"
+//	                         (may be repeated)
+//	    --site1-cookie string
+//	                         cookie to send to Site 1, as "name=value" - or
+//	                         several, as "a=1; b=2" (may be repeated)
+//	    --site1-cookie-file string
+//	                         Netscape format cookies file (cookies.txt) to load
+//	                         Site 1's cookies from
+//	    --site1-cert string PEM file with the TLS client certificate to give
+//	                         Site 1
+//	    --site1-key string  PEM file with the private key for --site1-cert
+//	    --site1-strip string
+//	                         only compare what's under this path at Site 1, as
+//	                         if it were the top of the site
 //	    --site2 string       Site 2 URL
 //	    --site2name string   Site 2 Name
 //	    --site2pass string   Site 2 Password
 //	    --site2user string   Site 2 User ID
+//	    --site2token string  Site 2 bearer token (sent instead of the user/password)
+//	    --site2-auth string how to send Site 2's user/password - basic or
+//	                         digest (default "basic")
+//	    --site2-type string what kind of site Site 2 is - html, webdav, ftp, s3,
+//	                         or local (default works it out from the URL)
+//	    --site2header        extra header to send to Site 2, as "Name: value"
+//	                         (may be repeated)
+//	    --site2-cookie string
+//	                         cookie to send to Site 2, as "name=value" - or
+//	                         several, as "a=1; b=2" (may be repeated)
+//	    --site2-cookie-file string
+//	                         Netscape format cookies file (cookies.txt) to load
+//	                         Site 2's cookies from
+//	    --site2-cert string PEM file with the TLS client certificate to give
+//	                         Site 2
+//	    --site2-key string  PEM file with the private key for --site2-cert
+//	    --site2-strip string
+//	                         only compare what's under this path at Site 2, as
+//	                         if it were the top of the site
 //
-// # Environment Variables
-//
-// Acceptable environment variables are all capitals, are prefixed with "SITESCAN_",
-// and otherwise match the command line switches:
-//
-//	SITESCAN_SITE1
-//	SITESCAN_SITE1NAME
-//	SITESCAN_SITE1PASS
-//	SITESCAN_SITE1USER
-//	SITESCAN_SITE2
-//	SITESCAN_SITE2NAME
-//	SITESCAN_SITE2PASS
-//	SITESCAN_SITE2USER
-//
-// # Config File
-//
-// The default configuration file is named "sitescan_config.yaml" and should reside
-// in the directory you're running sitescan from (i.e. the directory that sitescan
-// will see as "PWD"). You can specify an alternate config file name/path using the
-// -c / --config command line option. And example config file:
-// `	# Example sitescan_config.yaml file
-//
-//	 download: false
-//	 suppress: true
-//		site1: http://webserver.myhost.com/path/to/examine
-//		site2: http://www.anotherhost.org:8080/
-//		site1user: someguy
-//		site1pass: spaceballs12345
-//		site1name: MyHost.com site
-//		# site2user:
-//		# site2pass:
-//		site2name: AnotherHost site `
+// See README.md for everything else: the environment variables and config file,
+// the other kinds of site, downloading, deleting and uploading, logging and
+// notifications, and using the scanner package from other Go programs.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"log"
+	"log/slog"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/cavaliercoder/grab"
+	"github.com/davexre/sitescan/checksum"
+	"github.com/davexre/sitescan/scanner"
 	"github.com/davexre/sitescan/webhandler"
 	"github.com/davexre/sitescan/writable"
-	"github.com/davexre/synceddata"
 	"github.com/gosuri/uilive"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
 )
 
-var (
-	site1Map = make(map[string]string)
-	site2Map = make(map[string]string)
+// fileEntry and fileMap are what a walk finds at a site - see scanner.Entry and
+// scanner.Map.
+type (
+	fileEntry = scanner.Entry
+	fileMap   = scanner.Map
+)
+
+// site is one of the trees being compared, and walkCounter what it's found so
+// far - see scanner.Site and scanner.Counter. fetchError and errorList are the
+// URLs that couldn't be retrieved, by a walk or a download.
+type (
+	site        = scanner.Site
+	walkCounter = scanner.Counter
+	fetchError  = scanner.FetchError
+	errorList   = scanner.ErrorList
+)
+
+// checksumDiff describes a file that exists at both sites, but whose contents
+// don't match.
+type checksumDiff struct {
+	Name  string `json:"path"`
+	Site1 string `json:"site1"`
+	Site2 string `json:"site2"`
+}
+
+// siteConfig is how a site is described in the "sites" list of the config file.
+type siteConfig struct {
+	URL        string   `mapstructure:"url"`
+	Type       string   `mapstructure:"type"`
+	Name       string   `mapstructure:"name"`
+	User       string   `mapstructure:"user"`
+	Pass       string   `mapstructure:"pass"`
+	Token      string   `mapstructure:"token"`
+	Auth       string   `mapstructure:"auth"`
+	Headers    []string `mapstructure:"headers"`
+	Cookies    []string `mapstructure:"cookies"`
+	CookieFile string   `mapstructure:"cookie-file"`
+	Cert       string   `mapstructure:"cert"`
+	Key        string   `mapstructure:"key"`
+	Strip      string   `mapstructure:"strip"`
+}
+
+// sizeDiff and newerDiff are the differences --size-compare and --newer-than
+// find - see scanner.SizeDiff and scanner.NewerDiff.
+type (
+	sizeDiff  = scanner.SizeDiff
+	newerDiff = scanner.NewerDiff
+)
 
+var (
+	// version, commit and buildDate say which build of sitescan this is, for
+	// --version (and the default User-Agent). They're set at build time, like so:
+	//
+	//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)"
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+
+	// sites are all the trees being compared, in the order they were configured
+	sites []*site
+
+	// updateInterval is how often the progress display is redrawn, from
+	// --progress-interval
 	updateInterval = time.Millisecond * 200
 
-	site1done, site2done, stopupdating chan bool
-	site1Counter, site2Counter         synceddata.Counter
+	sitedone     chan int
+	stopupdating chan bool
 
 	lw = uilive.New()
 
-	url1, url2                      string
-	site1User, site1Pass, site1Name string
-	site2User, site2Pass, site2Name string
-
-	debug      = false
-	download   = false
-	dryrun     = false
-	noprogress = false
-	suppress   = false
+	// the first two sites are the ones that --download, --size-compare,
+	// --newer-than and --checksum work with, so they get their own shortcuts. site1Opts and
+	// site2Opts carry everything webhandler needs to authenticate with each -
+	// user/password, bearer token, and any extra headers
+	url1, url2           string
+	site1Name, site2Name string
+	site1Opts, site2Opts webhandler.Options
+
+	debug       = false
+	download    = false
+	upload      = false
+	dryrun      = false
+	noprogress  = false
+	suppress    = false
+	sizeCompare = false
+	newerThan   = false
+	failFast    = false
+	ignoreCase  = false
+	verify      = false
+
+	// force downloads files again, even if they're already there and match
+	force = false
+
+	// deleteFiles deletes the local files that aren't at Site 2 any more, after
+	// the downloads - and deleteDirs the directories, too. assumeYes skips
+	// asking first.
+	deleteFiles = false
+	deleteDirs  = false
+	assumeYes   = false
+
+	// dlSource is Site 2's map, which says how big each file being downloaded
+	// should be
+	dlSource *fileMap
+
+	// stateFile is where --state keeps the site maps between runs, and refresh
+	// ignores what's already in it
+	stateFile = ""
+	refresh   = false
+
+	// snapshotDir is where --save-snapshot saves a snapshot of each site once
+	// it's been walked
+	snapshotDir = ""
+
+	// preserveTimes sets each downloaded file's mtime to match its source
+	preserveTimes = false
+
+	// dlHooks runs the --on-download-complete command for each downloaded
+	// file. It's nil if there isn't one
+	dlHooks *hookRunner
+
+	// failOnDiff makes sitescan exit with exitDiff when the comparison finds any
+	// differences, for scripts and CI jobs that need to know
+	failOnDiff = false
+
+	// showStats reports how the scan went once it's done, with scanStats
+	showStats = false
+
+	// notifyURL is the --notify-webhook that report is sent to when the run's
+	// over, with notifyOpts, and made into whatever notifyTemplate says (if
+	// it's set - otherwise it's sent as JSON). runStarted is when the run
+	// began, for its duration
+	notifyURL      = ""
+	notifyOpts     webhandler.Options
+	notifyTemplate *template.Template
+	report         runReport
+	runStarted     time.Time
+
+	// metricsFile is where report is written as Prometheus metrics when the
+	// run's over, for --metrics-file
+	metricsFile = ""
+
+	// verifyChecksums checks each download against the checksum file published
+	// next to it, named with checksumSuffix - or against the manifest at
+	// manifestPath, if there is one. Either way, they're manifestAlgo
+	// checksums. A download that doesn't match is deleted, and fetched again up
+	// to checksumRetries times
+	verifyChecksums = false
+	checksumSuffix  = ".sha256"
+	manifestPath    = ""
+	manifestFormat  = "gnu"
+	manifestAlgo    = "sha256"
+	checksumRetries = 0
+
+	// fileMode and dirMode are the permissions downloaded files and the
+	// directories made for them get, less whatever umask takes away
+	fileMode os.FileMode = 0644
+	dirMode  os.FileMode = 0755
+	umask    os.FileMode
+
+	// outputFormat is how the comparison gets rendered - "text", "json", "csv",
+	// "quiet" (just the paths), or "sync" (what a two-way sync would do)
+	outputFormat = "text"
+
+	// markers says whether --quiet marks each path with which site it's at
+	markers = true
+
+	// pathEnd is what --quiet ends each path with - a newline, or a NUL with
+	// --print0
+	pathEnd = "\n"
+
+	// outputFile, if set, is where the comparison gets written instead of stdout
+	outputFile   = ""
+	appendOutput = false
+
+	// statusOut is where the status messages around the scan go. That's stderr,
+	// along with the progress display, so stdout holds nothing but the results,
+	// and can be redirected to a file.
+	statusOut io.Writer = os.Stderr
+
+	checksumAlgo = ""
 
 	throttle = 1
-	timeout  = 0
+	maxDepth = 0
+
+	// timeout is how long the whole run gets, from --timeout. 0 means forever
+	timeout time.Duration
+
+	// bandwidthLimiter, if --bandwidth-limit is set, hands out the bytes that all
+	// the downloads share between them. nil means there's no limit
+	bandwidthLimiter *rate.Limiter
+
+	// walkConcurrency is how many directory listings a web server's walk
+	// fetches at once, for each site
+	walkConcurrency = 1
 
+	// requestRate is how many requests a second each site gets, from
+	// --request-rate. 0 means as many as it'll take
+	requestRate = 0.0
+
+	// outputJSON, outputCSV, quietOutput and syncPreview are the output options
+	// that were asked for, which config() settles on an outputFormat from -
+	// validateConfig checks that they go together
+	outputJSON  = false
+	outputCSV   = false
+	quietOutput = false
+	syncPreview = false
+
+	// settingErrors are the settings config() couldn't make sense of, for
+	// validateConfig to report along with everything else
+	settingErrors []error
+
+	// dlSuffix is added to the name of a file while it's being downloaded, so a
+	// partial download can be told apart from the real thing (and resumed)
 	dlSuffix = ".sitescandl"
 
+	// errTimedOut is the cause timeoutWorker gives when it cancels the run
+	errTimedOut = errors.New("timed out")
+
 	// these are various anchor texts that are presented by the web browser that
 	// change sort order, or take us up a directory, etc. We don't want to take
 	// these into account in our Maps, so we use this list to ignore them when
@@ -137,53 +541,261 @@ var (
 		html.UnescapeString("&nbsp;&darr;&nbsp"): 12,
 	}
 
+	// dlLog is where the download workers report what they're doing - stderr by
+	// default, or the --log-file, with timestamps. Every worker shares this one
+	// Logger, and its internal Mutex keeps their lines from getting garbled.
+	dlLog   = log.New(os.Stderr, "", 0)
+	logFile = ""
+
+	// dlJSON is dlLog's slog.Logger, for --log-format json - nil otherwise.
+	// logFormat is text or json.
+	dlJSON    *slog.Logger
+	logFormat = "text"
+
+	// dlProgress is how far the downloads have got - how many files and bytes
+	// there are, how many have been fetched, and the ones that couldn't be -
+	// for the progress display and the summary at the end
+	dlProgress scanner.Progress
+
+	// heartbeatInterval is how often a one line summary of the scan, and then
+	// the downloads, goes to dlLog when the progress display is turned off with
+	// --noprogress, from --heartbeat. 0 means never
+	heartbeatInterval = time.Minute
+
+	// walkErrors holds every URL that couldn't be retrieved during the walk, so we
+	// can carry on and report them all at the end (unless failFast is set)
+	walkErrors errorList
+
+	// ignoreRegexes are compiled once from --ignore-regex in config(), and any
+	// anchor whose text or href matches one of them is skipped by the walk.
+	ignoreRegexes []*regexp.Regexp
+
+	// includeGlobs and excludeGlobs decide which entries make it into the site
+	// maps - see scanner.ScanOptions
+	includeGlobs, excludeGlobs []string
+
+	// extensions, if not empty, holds the (lowercased, dot-less) extensions from
+	// --extensions
+	extensions map[string]bool
+
+	// s3Region, s3Endpoint and s3Profile override what the standard AWS
+	// configuration (environment, shared config files, instance roles...) would
+	// otherwise pick. s3Endpoint is for S3 compatible services, like MinIO.
+	s3Region   = ""
+	s3Endpoint = ""
+	s3Profile  = ""
+
 	wg sync.WaitGroup
 )
 
+// versionString is what --version prints.
+func versionString() string {
+	return fmt.Sprintf("sitescan %s (commit %s, built %s)", version, commit, buildDate)
+}
+
+// configExts are the extensions of the config file formats sitescan reads.
+var configExts = []string{".yaml", ".yml", ".json", ".toml"}
+
+// configFileName works out which file --config means. A path that ends in one of
+// configExts is used as it is - anything else is YAML, and gets ".yaml" added.
+func configFileName(path string) string {
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range configExts {
+		if ext == e {
+			return path
+		}
+	}
+
+	return path + ".yaml"
+
+}
+
+// configPaths are the directories searched for sitescan_config, in order - the
+// first one that has it wins. A --config file that's there is used instead.
+func configPaths() []string {
+
+	paths := []string{"."}
+
+	home, _ := os.UserHomeDir()
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "sitescan"))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "sitescan"))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".sitescan"))
+	}
+
+	return append(paths, "/etc/sitescan")
+
+}
+
 func config() {
 
 	var clConfigFile, clConfigFileFSName string
+	var showVersion bool
+	var completion string
+	var initConfig bool
+	var printSettings bool
 	var flagSite1, flagSite1User, flagSite1Pass, flagSite1Name string
 	var flagSite2, flagSite2User, flagSite2Pass, flagSite2Name string
 	var err error
 
 	v := viper.New()
 	flag.StringVarP(&clConfigFile, "config", "c", "", "path to alternate configuration file")
-	flag.BoolVarP(&debug, "debug", "d", false, "output debugging info")
+	flag.BoolVarP(&debug, "debug", "d", false, "output debugging info (the same as --log-level debug)")
+	flag.String("log-level", "info", "only show messages at this level or above - error, warn, info or debug")
+	flag.String("log-format", "text", "how to write messages - text, or json for a log pipeline (one JSON object to a line)")
+	flag.String("netrc", "", "read the logins for sites without a user or password from this .netrc file (default $NETRC, or ~/.netrc)")
+	flag.String("credentials-file", "", "path to a separate config file with the passwords and tokens in it, so the main one can be shared")
+	flag.BoolVar(&showVersion, "version", false, "print the version of sitescan, and exit")
+	flag.BoolVar(&printSettings, "print-config", false, "print every setting, from the config file, the environment and the command line, and exit (secrets are masked)")
+	flag.StringVar(&completion, "completion", "", "print a tab completion script for this shell - bash, zsh or fish - and exit")
+	flag.BoolVar(&initConfig, "init", false, "write a starter "+configInitName+", with every setting and its default, to the current directory, and exit")
 	flag.BoolVar(&download, "download", false, "automatically download files that exist on Site 2 that are missing for Site 1")
+	flag.BoolVar(&upload, "upload", false, "upload files that exist on Site 1 that are missing from Site 2, which has to accept PUT (like a WebDAV share)")
 	flag.BoolVar(&dryrun, "dryrun", false, "requires --download, runs process without actually performing any downloads")
+	flag.Bool("verify", false, "after each download, check that the file's size matches what the server (or source file) reported")
+	flag.Bool("delete", false, "after downloading, delete local files that aren't at Site 2 any more (asks first, unless --yes)")
+	flag.Bool("delete-dirs", false, "with --delete, delete local directories that aren't at Site 2 any more, too")
+	flag.BoolP("yes", "y", false, "don't ask before deleting anything with --delete")
+	flag.Bool("force", false, "download files again, even if they're already there from an earlier run and match")
+	flag.String("state", "", "save what was found at each site to this file, and only report new differences next time")
+	flag.String("save-snapshot", "", "save what was found at each site to a snapshot file in this directory, for comparing against later as a snapshot:// site")
+	flag.StringSlice("diff-snapshots", nil, "compare two snapshot files saved with --save-snapshot, older first (as \"old.json,new.json\"), without going near the sites")
+	flag.Bool("refresh", false, "with --state, report every difference, ignoring the saved state")
+	flag.Bool("preserve-times", false, "give each downloaded file the modification time it has at the source")
+	flag.String("on-download-complete", "", "run this command for each file that's downloaded, with {} replaced by its path (which is in $SITESCAN_FILE, too)")
+	flag.Bool("verify-checksums", false, "after each download, check it against the checksum file published next to it")
+	flag.String("checksum-suffix", ".sha256", "what's added to a file's name to find its checksum file")
+	flag.String("manifest", "", "check each download against this checksum manifest (a path relative to Site 2, or a URL)")
+	flag.String("manifest-format", "gnu", "how the manifest is laid out - gnu (as from sha256sum) or bsd")
+	flag.String("manifest-algo", "sha256", "the checksum algorithm the checksum files and manifest use - md5 or sha256")
+	flag.Int("checksum-retries", 0, "how many more times to download a file that doesn't match its checksum")
 	flag.BoolVarP(&noprogress, "noprogress", "n", false, "don't show the progress bar (for unattended use)")
+	flag.Duration("progress-interval", 200*time.Millisecond, "how often the progress display is redrawn - longer is easier on a slow terminal or ssh link")
+	flag.Duration("heartbeat", time.Minute, "with --noprogress, how often to log a line saying how the scan or downloads are going (0 for never)")
 	flag.BoolVarP(&suppress, "suppress", "s", false, "suppress output of directories")
+	flag.Bool("size-compare", false, "also report files that exist on both sites, but have different sizes")
+	flag.Bool("newer-than", false, "also report files that exist on both sites, but are newer on Site 2")
+	flag.StringArray("ignore-regex", nil, "skip links whose text or href matches this regular expression (may be repeated)")
+	flag.StringArray("include", nil, "only compare files whose path (or name) matches this glob pattern (may be repeated)")
+	flag.StringArray("exclude", nil, "never compare files whose path (or name) matches this glob pattern (may be repeated)")
+	flag.String("extensions", "", "only compare files with one of these extensions (comma separated, e.g. \"mp3,flac,jpg\")")
+	flag.Bool("ignore-case", false, "treat paths that only differ by upper/lower case as the same file")
+	flag.Bool("fail-fast", false, "stop the whole scan as soon as any page of a listing can't be retrieved")
+	flag.Int("max-depth", 0, "don't descend more than this many directories deep (0 means no limit)")
+	flag.Int("walk-concurrency", 1, "how many directory listings to fetch at once from each web server")
+	flag.String("notify-webhook", "", "when the run's over, POST a JSON summary of it to this URL")
+	flag.String("notify-template", "", "a Go template for what --notify-webhook sends, in place of the JSON summary")
+	flag.String("notify-user", "", "user for basic authentication with --notify-webhook")
+	flag.String("notify-pass", "", "password for basic authentication with --notify-webhook")
+	flag.String("metrics-file", "", "when the run's over, write Prometheus metrics about it to this file, for node_exporter's textfile collector")
+	flag.Bool("stats", false, "once the scan's done, report the requests made, HTML fetched, request latency, deepest directory, and time taken at each site")
+	flag.Bool("output-json", false, "write the comparison as JSON instead of text")
+	flag.Bool("output-csv", false, "write the comparison as CSV instead of text, for spreadsheets")
+	flag.Bool("sync-preview", false, "show what a two-way sync of the sites would do - what would be pulled, pushed, or left alone - without doing any of it")
+	flag.BoolP("quiet", "q", false, "just list the paths that differ, one per line, with no headings or progress bar")
+	flag.BoolP("print0", "0", false, "like --quiet, but end each path with a NUL instead of a newline, for xargs -0")
+	flag.Bool("no-markers", false, "with --quiet, leave off the \"<\", \">\" and \"~\" that say how each path differs")
+	flag.StringP("output-file", "f", "", "write the comparison to this file instead of stdout")
+	flag.Bool("append", false, "add to the end of --output-file, rather than replacing it")
+	flag.Bool("fail-on-diff", false, "exit with status 2 if any differences are found")
+	flag.String("checksum", "", "also report files that exist on both sites, but have different contents (md5 or sha256)")
+	flag.Lookup("checksum").NoOptDefVal = "sha256"
 	flag.IntVarP(&throttle, "throttle", "t", 1, "throttle concurrent downloads to this many")
-	flag.IntVarP(&timeout, "timeout", "o", 0, "timeout - number of hours to run downloads before exiting")
+	flag.String("bandwidth-limit", "", "cap the total download rate, in bytes per second, like \"5MB\" (default no limit)")
+	flag.String("file-mode", "0644", "permissions for downloaded files, in octal (local copies keep the source's)")
+	flag.String("dir-mode", "0755", "permissions for directories created by downloads, in octal")
+	flag.StringP("timeout", "o", "0", "how long to run (scanning and downloading) before stopping, like \"30m\" - a plain number is hours")
+	flag.String("partial-suffix", ".sitescandl", "what to add to the name of a file while it's being downloaded")
+	flag.String("log-file", "", "write each download worker's progress and errors to this file, leaving just a summary on the console")
+	flag.Int("http-timeout", 30, "seconds to wait for any single page of a listing before giving up on it (0 means wait forever)")
+	flag.Float64("request-rate", 0, "most requests per second to make to each web server while walking it (0 means no limit)")
+	flag.Int("retries", 2, "how many times to retry a request after a network error, 5xx, or 429 response")
+	flag.Duration("retry-delay", time.Second, "how long to wait before the first retry - each retry after that waits twice as long")
+	flag.String("proxy", "", "send all HTTP(S) requests through this proxy URL (default uses HTTP_PROXY / HTTPS_PROXY)")
+	flag.Bool("insecure", false, "don't verify the sites' TLS certificates, for self-signed test servers - never use this in production")
+	flag.Bool("skip-tls-verify", false, "same as --insecure")
+	flag.Int("max-redirects", 10, "how many redirects in a row to follow before giving up on a request (0 means no limit)")
+	flag.Bool("no-redirect", false, "don't follow redirects at all")
+	flag.String("ca-cert", "", "PEM file with CA certificates to trust, on top of the system's, for servers they signed")
+	flag.String("s3-region", "", "AWS region for S3 sites (default from the standard AWS configuration)")
+	flag.String("s3-endpoint", "", "endpoint URL for S3 sites, for S3 compatible services like MinIO")
+	flag.String("s3-profile", "", "AWS shared config profile to use for S3 sites")
+	flag.String("user-agent", "sitescan/"+version, "User-Agent header to send with every request")
 	flag.StringVar(&flagSite1, "site1", "", "Site 1 URL")
 	flag.StringVar(&flagSite1User, "site1user", "", "Site 1 User ID")
 	flag.StringVar(&flagSite1Pass, "site1pass", "", "Site 1 Password")
 	flag.StringVar(&flagSite1Name, "site1name", "", "Site 1 Name")
+	flag.String("site1token", "", "Site 1 bearer token (sent instead of the user/password)")
+	flag.String("site1-auth", "basic", "how to send Site 1's user/password - basic or digest")
+	flag.String("site1-type", "", "what kind of site Site 1 is - html, webdav, ftp, s3, or local (default works it out from the URL)")
+	flag.StringArray("site1header", nil, "extra header to send to Site 1, as \"Name: value\" (may be repeated)")
+	flag.StringArray("site1-cookie", nil, "cookie to send to Site 1, as \"name=value\" (may be repeated)")
+	flag.String("site1-cookie-file", "", "Netscape format cookies file (cookies.txt) to load Site 1's cookies from")
+	flag.String("site1-cert", "", "PEM file with the TLS client certificate to give Site 1")
+	flag.String("site1-key", "", "PEM file with the private key for --site1-cert")
+	flag.String("site1-strip", "", "only compare what's under this path at Site 1, as if it were the top of the site")
 	flag.StringVar(&flagSite2, "site2", "", "Site 2 URL")
 	flag.StringVar(&flagSite2User, "site2user", "", "Site 2 User ID")
 	flag.StringVar(&flagSite2Pass, "site2pass", "", "Site 2 Password")
 	flag.StringVar(&flagSite2Name, "site2name", "", "Site 2 Name")
+	flag.String("site2token", "", "Site 2 bearer token (sent instead of the user/password)")
+	flag.String("site2-auth", "basic", "how to send Site 2's user/password - basic or digest")
+	flag.String("site2-type", "", "what kind of site Site 2 is - html, webdav, ftp, s3, or local (default works it out from the URL)")
+	flag.StringArray("site2header", nil, "extra header to send to Site 2, as \"Name: value\" (may be repeated)")
+	flag.StringArray("site2-cookie", nil, "cookie to send to Site 2, as \"name=value\" (may be repeated)")
+	flag.String("site2-cookie-file", "", "Netscape format cookies file (cookies.txt) to load Site 2's cookies from")
+	flag.String("site2-cert", "", "PEM file with the TLS client certificate to give Site 2")
+	flag.String("site2-key", "", "PEM file with the private key for --site2-cert")
+	flag.String("site2-strip", "", "only compare what's under this path at Site 2, as if it were the top of the site")
 	flag.Parse()
 
+	// until the config file's been read, only --debug can turn on debugging
 	if debug {
-		fmt.Printf("DEBUG: clConfigFile <%s>\n", clConfigFile)
+		setupLogging(os.Stderr, slog.LevelDebug)
+	} else {
+		setupLogging(os.Stderr, slog.LevelInfo)
 	}
 
-	if clConfigFile != "" {
-		if strings.HasSuffix(clConfigFile, ".yaml") {
-			clConfigFileFSName = clConfigFile
-			clConfigFile = strings.TrimSuffix(clConfigFile, ".yaml")
-		} else {
-			clConfigFileFSName = fmt.Sprintf("%s.yaml", clConfigFile)
+	if showVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+
+	if completion != "" {
+		script, err := completionScript(completion, flag.CommandLine)
+		if err != nil {
+			slog.Error("invalid --completion", "err", err)
+			os.Exit(1)
+		}
+		fmt.Print(script)
+		os.Exit(0)
+	}
+
+	if initConfig {
+		overwrite, _ := flag.CommandLine.GetBool("force")
+		if err := writeConfigInit(configInitName, flag.CommandLine, overwrite); err != nil {
+			slog.Error("unable to write config file", "err", err)
+			os.Exit(1)
 		}
+		fmt.Fprintf(statusOut, "Wrote %s - edit it to set up your sites\n", configInitName)
+		os.Exit(0)
+	}
+
+	slog.Debug("config", "clconfigfile", clConfigFile)
+
+	if clConfigFile != "" {
+		clConfigFileFSName = configFileName(clConfigFile)
 
 		if _, err = os.Stat(clConfigFileFSName); err != nil {
-			fmt.Println("config file not found: ", clConfigFileFSName)
+			slog.Warn("config file not found", "path", clConfigFileFSName)
 			v.SetConfigName("sitescan_config")
 		} else {
-			v.SetConfigName(filepath.Base(clConfigFile))
-			v.AddConfigPath(filepath.Dir(clConfigFile))
+			// the extension tells viper what format it's in
+			v.SetConfigFile(clConfigFileFSName)
 		}
 	} else {
 		v.SetConfigName("sitescan_config")
@@ -197,546 +809,1719 @@ func config() {
 	v.SetDefault("site2user", "")
 	v.SetDefault("site2pass", "")
 	v.SetDefault("site2name", "Site 2")
+	v.SetDefault("ignore", []string{})
+	v.SetDefault("ignore-replace", false)
 	v.SetEnvPrefix("SITESCAN")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	v.AutomaticEnv()
+	v.BindEnv("user-agent", "SITESCAN_USERAGENT", "SITESCAN_USER_AGENT")
 	v.BindPFlags(flag.CommandLine)
-	v.AddConfigPath(".")
+	for _, dir := range configPaths() {
+		v.AddConfigPath(dir)
+	}
 
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			if debug {
-				fmt.Printf("config file not found (viper)\n")
-			}
+			slog.Debug("config file not found (viper)")
 		} else {
-			fmt.Printf("%v\n", err)
+			slog.Error("unable to read config file", "err", err)
 		}
+	} else {
+		slog.Debug("config", "configfile", v.ConfigFileUsed())
 	}
 
-	url1 = strings.Trim(v.GetString("site1"), "\"")
-	url2 = strings.Trim(v.GetString("site2"), "\"")
-	site1User = strings.Trim(v.GetString("site1user"), "\"")
-	site1Pass = strings.Trim(v.GetString("site1pass"), "\"")
-	site1Name = strings.Trim(v.GetString("site1name"), "\"")
-	site2User = strings.Trim(v.GetString("site2user"), "\"")
-	site2Pass = strings.Trim(v.GetString("site2pass"), "\"")
-	site2Name = strings.Trim(v.GetString("site2name"), "\"")
-
-	if debug {
-		fmt.Printf("DEBUG: site1       <%s>\n", url1)
-		fmt.Printf("DEBUG: site1User   <%s>\n", site1User)
-		fmt.Printf("DEBUG: site1Pass   <%s>\n", site1Pass)
-		fmt.Printf("DEBUG: site1Name   <%s>\n", site1Name)
-		fmt.Printf("DEBUG: site2       <%s>\n", url2)
-		fmt.Printf("DEBUG: site2User   <%s>\n", site2User)
-		fmt.Printf("DEBUG: site2Pass   <%s>\n", site2Pass)
-		fmt.Printf("DEBUG: site2Name   <%s>\n", site2Name)
-		fmt.Printf("DEBUG: download?   <%v>\n", download)
-		fmt.Printf("DEBUG: dryrun?     <%v>\n", dryrun)
-		fmt.Printf("DEBUG: noprogress? <%v>\n", noprogress)
-		fmt.Printf("DEBUG: suppress?   <%v>\n", suppress)
-		fmt.Printf("DEBUG: throttle    <%d>\n", throttle)
-		fmt.Printf("DEBUG: timeout     <%d>\n", timeout)
+	// now the config file and environment can have their say
+	// a log format or level that won't do is reported by validateConfig, and
+	// until then, it's text at the info level
+	logFormat = strings.ToLower(v.GetString("log-format"))
+	logLevel, err := parseLogLevel(v.GetString("log-level"))
+	if err != nil {
+		settingErrors = append(settingErrors, fmt.Errorf("invalid --log-level: %w", err))
+		logLevel = slog.LevelInfo
 	}
-
-	if dryrun && !download {
-		fmt.Printf("--dryrun option requires --download to be effective\n")
+	if v.GetBool("debug") {
+		logLevel = slog.LevelDebug
 	}
+	setupLogging(os.Stderr, logLevel)
+	setDownloadLog(os.Stderr, 0)
 
-}
+	if used := v.ConfigFileUsed(); used != "" {
+		warnExposedSecrets(used)
+	}
+	if credentialsFile := v.GetString("credentials-file"); credentialsFile != "" {
+		slog.Debug("config", "credentials", credentialsFile)
+		if err := loadCredentials(v, credentialsFile); err != nil {
+			slog.Error("unable to read credentials file", "path", credentialsFile, "err", err)
+			os.Exit(1)
+		}
+	}
 
-// walkLink builds a map of the URLs and plain text names for all the files
-// stored at the indicated site. This is intended to be called in a recursive
-// fashion between two different goroutines.
-//
-// So, why use the anchor tag text, and why are we checking the URL in href for
-// a trailing slash? Different web servers encode data differently, and present
-// the text in the anchor tags differently. For instance, lighthttpd does not
-// include the trailing "/" in the anchor tag text, but apache does. lighthttpd
-// encodes apostrophes (%27), but apache leaves them as bare apostrophes.
-//
-// Using anchor tag text skips most of the problems with encoding, but makes it
-// hard to recognize directories - and also makes it hard to directly compare
-// between different servers. Thus, we check the href URL for a trailing slash,
-// and append one to the anchor tag text if it's not already there
-//
-// The primary work is done in the doc.Find block - it looks at each anchor
-// tag in the document, and processes it accordingly. We're expecting to find
-// a file listing there. Any directory needs to be explored, so walkLink calls
-// itself recursively to handle that.
-func walkLink(urlprefix string, url string, currentName string, siteMap *map[string]string,
-	user string, pass string, counter *synceddata.Counter) {
+	if printSettings {
+		if used := v.ConfigFileUsed(); used != "" {
+			fmt.Printf("# from %s, the environment and the command line\n", used)
+		}
+		printConfig(os.Stdout, v.AllSettings())
+		os.Exit(0)
+	}
 
-	urltoget := fmt.Sprintf("%s%s", urlprefix, url)
+	// the notifications are set up first, so that even a run that doesn't get
+	// past the rest of the config still sends its report (see exit)
+	notifyURL = v.GetString("notify-webhook")
+	notifyOpts = webhandler.Options{
+		User:    v.GetString("notify-user"),
+		Pass:    v.GetString("notify-pass"),
+		Headers: map[string]string{"Content-Type": "application/json"},
+	}
+	metricsFile = v.GetString("metrics-file")
+	if text := v.GetString("notify-template"); text != "" {
+		if notifyTemplate, err = parseNotifyTemplate(text); err != nil {
+			settingErrors = append(settingErrors, fmt.Errorf("invalid --notify-template: %w", err))
+		}
+	}
 
-	response, err := webhandler.HTTPHandler(urltoget, user, pass)
-	switch {
-	case err != nil:
-		fmt.Println("ERROR retrieving HTTP Request for URL: ", urltoget)
-		log.Fatal(err)
-	case response == nil:
-		log.Fatalf("ERROR retrieving HTTP Request - response is empty. URL: %s", urltoget)
+	webhandler.UserAgent = v.GetString("user-agent")
+	s3Region = v.GetString("s3-region")
+	s3Endpoint = v.GetString("s3-endpoint")
+	s3Profile = v.GetString("s3-profile")
+	webhandler.SetTimeout(time.Duration(v.GetInt("http-timeout")) * time.Second)
+	webhandler.Retries = v.GetInt("retries")
+	webhandler.RetryDelay = v.GetDuration("retry-delay")
+	updateInterval = v.GetDuration("progress-interval")
+	heartbeatInterval = v.GetDuration("heartbeat")
+	if err = webhandler.SetProxy(v.GetString("proxy")); err != nil {
+		settingErrors = append(settingErrors, err)
+	}
+	webhandler.MaxRedirects = v.GetInt("max-redirects")
+	webhandler.FollowRedirects = !v.GetBool("no-redirect")
+	insecure := v.GetBool("insecure") || v.GetBool("skip-tls-verify")
+	webhandler.SetInsecure(insecure)
+	if caCert := v.GetString("ca-cert"); caCert != "" {
+		if err = webhandler.SetCACert(caCert); err != nil {
+			settingErrors = append(settingErrors, fmt.Errorf("unable to load --ca-cert <%s>: %w", caCert, err))
+		}
 	}
 
-	defer response.Body.Close()
+	var siteConfigs []siteConfig
+	if err = v.UnmarshalKey("sites", &siteConfigs); err != nil {
+		slog.Error("unable to read the sites list from the config", "err", err)
+		exit(1)
+	}
+	if err = applyCredentials(v, siteConfigs); err != nil {
+		slog.Error("unable to read the credentials list from the credentials file", "err", err)
+		exit(1)
+	}
+	if paths := v.GetStringSlice("diff-snapshots"); len(paths) > 0 {
+		// two snapshots take the place of whatever sites are configured
+		if siteConfigs, err = snapshotSites(paths); err != nil {
+			slog.Error("invalid --diff-snapshots", "err", err)
+			exit(1)
+		}
+	} else if len(siteConfigs) == 0 {
+		// no list, so the site1 and site2 settings describe the two sites
+		for _, key := range []string{"site1", "site2"} {
+			siteConfigs = append(siteConfigs, siteConfig{
+				URL:        v.GetString(key),
+				Type:       v.GetString(key + "-type"),
+				Name:       v.GetString(key + "name"),
+				User:       v.GetString(key + "user"),
+				Pass:       v.GetString(key + "pass"),
+				Token:      v.GetString(key + "token"),
+				Auth:       v.GetString(key + "-auth"),
+				Headers:    configList(v, key+"header"),
+				Cookies:    configList(v, key+"-cookie"),
+				CookieFile: v.GetString(key + "-cookie-file"),
+				Cert:       v.GetString(key + "-cert"),
+				Key:        v.GetString(key + "-key"),
+				Strip:      v.GetString(key + "-strip"),
+			})
+		}
+	}
 
-	doc, err := goquery.NewDocumentFromReader(response.Body)
+	logins, err := loadNetrc(v.GetString("netrc"))
 	if err != nil {
-		log.Fatal(err)
+		settingErrors = append(settingErrors, fmt.Errorf("unable to read .netrc file: %w", err))
 	}
+	applyNetrc(logins, siteConfigs)
 
-	doc.Find("a").Each(func(i int, s *goquery.Selection) {
-		_, exists := ignoreThese[s.Text()]
-		if !exists {
-			href, exists := s.Attr("href")
-			if exists {
-
-				counter.Incr()
-
-				ourname := fmt.Sprintf("%s%s", currentName, s.Text())
-				oururl := fmt.Sprintf("%s%s", url, href)
-
-				if strings.HasSuffix(href, "/") && !strings.HasSuffix(ourname, "/") {
-					ourname = fmt.Sprintf("%s/", ourname)
-				}
+	var siteErrors []error
+	sites, siteErrors = buildSites(siteConfigs)
+	settingErrors = append(settingErrors, siteErrors...)
+	if len(siteConfigs) < 2 {
+		settingErrors = append(settingErrors, fmt.Errorf("at least two sites are needed for a comparison"))
+	}
 
-				(*siteMap)[ourname] = oururl
+	// each site gets a limiter of its own, so a slow one doesn't hold up the rest
+	requestRate = v.GetFloat64("request-rate")
+	if requestRate > 0 {
+		for _, s := range sites {
+			s.Opts.Limiter = webhandler.NewRateLimiter(requestRate)
+		}
+	}
 
-				if strings.HasSuffix(href, "/") {
-					walkLink(urlprefix, oururl, ourname, siteMap, user, pass, counter)
-				}
+	if len(sites) >= 2 {
+		url1, site1Name, site1Opts = sites[0].URL, sites[0].Name, sites[0].Opts
+		url2, site2Name, site2Opts = sites[1].URL, sites[1].Name, sites[1].Opts
+	}
 
-			}
+	sizeCompare = v.GetBool("size-compare")
+	newerThan = v.GetBool("newer-than")
+	maxDepth = v.GetInt("max-depth")
+	walkConcurrency = v.GetInt("walk-concurrency")
+	failFast = v.GetBool("fail-fast")
+	ignoreCase = v.GetBool("ignore-case")
+	logFile = v.GetString("log-file")
+	dlSuffix = v.GetString("partial-suffix")
+	verify = v.GetBool("verify")
+	force = v.GetBool("force")
+	deleteFiles = v.GetBool("delete")
+	deleteDirs = v.GetBool("delete-dirs")
+	assumeYes = v.GetBool("yes")
+	preserveTimes = v.GetBool("preserve-times")
+	if command := v.GetString("on-download-complete"); command != "" {
+		if dlHooks, err = newHookRunner(command, max(throttle, 1)); err != nil {
+			settingErrors = append(settingErrors, fmt.Errorf("invalid --on-download-complete: %w", err))
+		}
+	}
+	manifestPath = v.GetString("manifest")
+	verifyChecksums = v.GetBool("verify-checksums") || manifestPath != ""
+	checksumSuffix = v.GetString("checksum-suffix")
+	manifestFormat = strings.ToLower(v.GetString("manifest-format"))
+	manifestAlgo = v.GetString("manifest-algo")
+	checksumRetries = v.GetInt("checksum-retries")
+	stateFile = v.GetString("state")
+	snapshotDir = v.GetString("save-snapshot")
+	refresh = v.GetBool("refresh")
+
+	if fileMode, err = parseMode(v.GetString("file-mode")); err != nil {
+		settingErrors = append(settingErrors, fmt.Errorf("invalid --file-mode: %w", err))
+	}
+	if dirMode, err = parseMode(v.GetString("dir-mode")); err != nil {
+		settingErrors = append(settingErrors, fmt.Errorf("invalid --dir-mode: %w", err))
+	}
+	umask = writable.Umask()
+	if timeout, err = parseTimeout(v.GetString("timeout")); err != nil {
+		settingErrors = append(settingErrors, fmt.Errorf("invalid --timeout: %w", err))
+	}
+	bandwidth, err := parseBandwidth(v.GetString("bandwidth-limit"))
+	if err != nil {
+		settingErrors = append(settingErrors, fmt.Errorf("invalid --bandwidth-limit: %w", err))
+	}
+	bandwidthLimiter = newBandwidthLimiter(bandwidth)
+	outputFile = v.GetString("output-file")
+	appendOutput = v.GetBool("append")
+	failOnDiff = v.GetBool("fail-on-diff")
+	showStats = v.GetBool("stats")
+
+	outputJSON, outputCSV = v.GetBool("output-json"), v.GetBool("output-csv")
+	quietOutput, syncPreview = v.GetBool("quiet"), v.GetBool("sync-preview")
+	switch {
+	case outputJSON:
+		outputFormat = "json"
+	case outputCSV:
+		outputFormat = "csv"
+	case quietOutput || v.GetBool("print0"):
+		outputFormat = "quiet"
+		noprogress = true
+	case syncPreview:
+		outputFormat = "sync"
+	}
+	if logFormat == "json" {
+		// the live display would garble the JSON lines on the console
+		noprogress = true
+	}
+	markers = !v.GetBool("no-markers")
+	if v.GetBool("print0") {
+		pathEnd = "\x00"
+	}
+	checksumAlgo = v.GetString("checksum")
 
+	ignoreList := configList(v, "ignore")
+	if v.GetBool("ignore-replace") && len(ignoreList) > 0 {
+		ignoreThese = make(map[string]int)
+	}
+	for _, text := range ignoreList {
+		if _, exists := ignoreThese[text]; !exists {
+			ignoreThese[text] = len(ignoreThese) + 1
 		}
+	}
 
-	})
+	ignoreRegexList := configList(v, "ignore-regex")
+	ignoreRegexes, err = compilePatterns(ignoreRegexList)
+	if err != nil {
+		settingErrors = append(settingErrors, fmt.Errorf("invalid --ignore-regex pattern: %w", err))
+	}
 
-}
+	includeGlobs = configList(v, "include")
+	excludeGlobs = configList(v, "exclude")
 
-func walkFS(basepath string, siteMap *map[string]string, counter *synceddata.Counter) {
+	extensions = parseExtensions(v.GetString("extensions"))
 
-	err := filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if os.IsPermission(err) {
-				if debug {
-					fmt.Println(err)
+	if debug {
+		slog.Debug("config", "useragent", webhandler.UserAgent)
+		slog.Debug("config", "proxy", v.GetString("proxy"))
+		slog.Debug("config", "insecure", insecure)
+		slog.Debug("config", "redirects", webhandler.FollowRedirects)
+		slog.Debug("config", "maxredirect", webhandler.MaxRedirects)
+		slog.Debug("config", "cacert", v.GetString("ca-cert"))
+		slog.Debug("config", "httptimeout", v.GetInt("http-timeout"))
+		slog.Debug("config", "retries", webhandler.Retries)
+		slog.Debug("config", "retrydelay", webhandler.RetryDelay)
+		slog.Debug("config", "reqrate", requestRate)
+		slog.Debug("config", "s3region", s3Region)
+		slog.Debug("config", "s3endpoint", s3Endpoint)
+		slog.Debug("config", "s3profile", s3Profile)
+		for i, s := range sites {
+			// secrets are masked, as for --print-config, so a debug log
+			// can be shared (or sent down a log pipeline) safely
+			slog.Debug("config", "site", i+1, "url", maskSetting("url", s.URL), "name", s.Name, "type", s.Type, "strip", s.Strip,
+				"user", s.Opts.User, "pass", maskSetting("pass", s.Opts.Pass), "token", maskSetting("token", s.Opts.Token), "auth", s.Opts.Auth,
+				"header", fmt.Sprintf("%q", maskSetting("header", headerList(s.Opts.Headers))), "cert", s.Opts.Transport != nil)
+			if u, err := url.Parse(s.URL); err == nil && s.Opts.Jar != nil {
+				var cookies []string
+				for _, c := range s.Opts.Jar.Cookies(u) {
+					cookies = append(cookies, c.Name+"="+c.Value)
 				}
-				return filepath.SkipDir
-			} else {
-				return err
+				slog.Debug("config", "site", i+1, "cookie", fmt.Sprintf("%q", maskSetting("cookie", cookies)))
 			}
 		}
+		slog.Debug("config", "download", download)
+		slog.Debug("config", "upload", upload)
+		slog.Debug("config", "dryrun", dryrun)
+		slog.Debug("config", "ondownloadcomplete", v.GetString("on-download-complete"))
+		slog.Debug("config", "noprogress", noprogress)
+		slog.Debug("config", "progressinterval", updateInterval)
+		slog.Debug("config", "heartbeat", heartbeatInterval)
+		slog.Debug("config", "suppress", suppress)
+		slog.Debug("config", "sizecomp", sizeCompare)
+		slog.Debug("config", "stats", showStats)
+		slog.Debug("config", "notifywebhook", redactURL(notifyURL))
+		slog.Debug("config", "notifyuser", notifyOpts.User)
+		slog.Debug("config", "metricsfile", metricsFile)
+		slog.Debug("config", "newerthan", newerThan)
+		slog.Debug("config", "checksum", checksumAlgo)
+		slog.Debug("config", "ignore", fmt.Sprintf("%q", ignoreList))
+		slog.Debug("config", "ignoreregex", fmt.Sprintf("%q", ignoreRegexList))
+		slog.Debug("config", "include", fmt.Sprintf("%q", includeGlobs))
+		slog.Debug("config", "exclude", fmt.Sprintf("%q", excludeGlobs))
+		slog.Debug("config", "extensions", extensions)
+		slog.Debug("config", "throttle", throttle)
+		slog.Debug("config", "timeout", timeout)
+		slog.Debug("config", "bandwidth", bandwidth)
+		slog.Debug("config", "logfile", logFile)
+		slog.Debug("config", "partial", dlSuffix)
+		slog.Debug("config", "verify", verify)
+		slog.Debug("config", "force", force)
+		slog.Debug("config", "delete", deleteFiles)
+		slog.Debug("config", "deletedirs", deleteDirs)
+		slog.Debug("config", "yes", assumeYes)
+		slog.Debug("config", "presvtimes", preserveTimes)
+		slog.Debug("config", "verifysums", verifyChecksums)
+		slog.Debug("config", "sumsuffix", checksumSuffix)
+		slog.Debug("config", "manifest", manifestPath)
+		slog.Debug("config", "manifestfmt", manifestFormat)
+		slog.Debug("config", "manifestalg", manifestAlgo)
+		slog.Debug("config", "sumretries", checksumRetries)
+		slog.Debug("config", "state", stateFile)
+		slog.Debug("config", "savesnapshot", snapshotDir)
+		slog.Debug("config", "refresh", refresh)
+		slog.Debug("config", "filemode", fmt.Sprintf("%#o", fileMode))
+		slog.Debug("config", "dirmode", fmt.Sprintf("%#o", dirMode))
+		slog.Debug("config", "umask", fmt.Sprintf("%#o", umask))
+		slog.Debug("config", "maxdepth", maxDepth)
+		slog.Debug("config", "failfast", failFast)
+		slog.Debug("config", "ignorecase", ignoreCase)
+		slog.Debug("config", "output", outputFormat)
+		slog.Debug("config", "markers", markers)
+		slog.Debug("config", "pathend", fmt.Sprintf("%q", pathEnd))
+		slog.Debug("config", "outputfile", outputFile)
+		slog.Debug("config", "append", appendOutput)
+	}
 
-		if path == basepath {
-			if debug {
-				fmt.Printf("Skipping - seems to be our base path: %s\n", info.Name())
-			}
-			return nil
-		}
+	if verify && !download {
+		fmt.Fprintf(statusOut, "--verify option requires --download to be effective\n")
+	}
 
-		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
-			if debug {
-				fmt.Printf("Skipping dir %s\n", info.Name())
-			}
-			return filepath.SkipDir
-		}
+	if preserveTimes && !download {
+		fmt.Fprintf(statusOut, "--preserve-times option requires --download to be effective\n")
+	}
 
-		if !info.IsDir() && strings.HasPrefix(info.Name(), ".") {
-			if debug {
-				fmt.Printf("Skipping file %s\n", info.Name())
-			}
-			return nil
-		}
+	if dlHooks != nil && !download {
+		fmt.Fprintf(statusOut, "--on-download-complete option requires --download to be effective\n")
+	}
 
-		counter.Incr()
+	if verifyChecksums && !download {
+		fmt.Fprintf(statusOut, "--verify-checksums and --manifest options require --download to be effective\n")
+	}
 
-		filepath := strings.TrimPrefix(path, basepath+"/")
+	if refresh && stateFile == "" {
+		fmt.Fprintf(statusOut, "--refresh option requires --state to be effective\n")
+	}
 
-		if info.IsDir() {
-			dirname := fmt.Sprintf("%s%s", filepath, "/")
-			(*siteMap)[dirname] = filepath
-		} else {
-			(*siteMap)[filepath] = filepath
-		}
+	if logFile != "" && !download && !upload {
+		fmt.Fprintf(statusOut, "--log-file option requires --download or --upload to be effective\n")
+	}
 
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
+	if force && !download {
+		fmt.Fprintf(statusOut, "--force option requires --download to be effective\n")
 	}
 
-}
+	if deleteFiles && !download {
+		fmt.Fprintf(statusOut, "--delete option requires --download to be effective\n")
+	}
 
-func walkWrapper(urlprefix string, siteMap *map[string]string,
-	user, pass string, done chan bool, counter *synceddata.Counter) {
+	if deleteDirs && !deleteFiles {
+		fmt.Fprintf(statusOut, "--delete-dirs option requires --delete to be effective\n")
+	}
 
-	if strings.HasPrefix(urlprefix, "http") {
-		walkLink(urlprefix, "", "", siteMap, user, pass, counter)
-	} else {
-		walkFS(urlprefix, siteMap, counter)
+	if assumeYes && !deleteFiles {
+		fmt.Fprintf(statusOut, "--yes option requires --delete to be effective\n")
 	}
 
-	if !noprogress {
-		done <- true
+	if appendOutput && outputFile == "" {
+		fmt.Fprintf(statusOut, "--append option requires --output-file to be effective\n")
 	}
 
-	wg.Done()
+	if failOnDiff && download {
+		fmt.Fprintf(statusOut, "--fail-on-diff has no effect with --download\n")
+	}
 
-}
+	if outputFormat != "text" && outputFormat != "sync" && download {
+		option := "--output-" + outputFormat
+		if outputFormat == "quiet" {
+			option = "--quiet"
+		}
+		fmt.Fprintf(statusOut, "%s has no effect with --download\n", option)
+	}
 
-func updateProgress() {
+	// the progress display goes with the status messages, out of the results' way
+	lw.Out = statusOut
 
-	startTime := time.Now()
-	var s1Duration, s2Duration time.Duration
+	if insecure {
+		slog.Warn("--insecure is set, so TLS certificates are NOT being verified - anyone in between could be answering for the sites. Only use this against test servers you trust.")
+	}
 
-	s1done := false
-	s2done := false
+}
 
-	for {
-		select {
-		case <-time.After(updateInterval):
-			if !s1done {
-				s1Duration = time.Since(startTime)
-			}
+// buildSites turns the configured sites into the sites to walk. Any that aren't
+// given a name are called "Site N", after their place in the list. Everything
+// that's wrong with them is returned, rather than just the first problem. A
+// site that can't be walked at all is left out.
+func buildSites(configs []siteConfig) ([]*site, []error) {
+
+	var built []*site
+	var errs []error
+
+	for i, c := range configs {
+		s := &site{
+			Name: strings.Trim(c.Name, "\""),
+			URL:  strings.Trim(c.URL, "\""),
+			Type: strings.Trim(c.Type, "\""),
+			Opts: webhandler.Options{
+				User:  strings.Trim(c.User, "\""),
+				Pass:  strings.Trim(c.Pass, "\""),
+				Token: strings.Trim(c.Token, "\""),
+				Auth:  strings.ToLower(strings.Trim(c.Auth, "\"")),
+			},
+			Map: new(fileMap),
+		}
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("Site %d", i+1)
+		}
+		if s.URL == "" {
+			errs = append(errs, fmt.Errorf("ERROR: no URL given for %s", s.Name))
+			continue
+		}
 
-			fmt.Fprintf(lw, "%-20s %-6s %5v files and directories", site1Name+":",
-				s1Duration.Round(time.Second).String(), site1Counter.Read())
+		var err error
+		if s.Type, s.URL, err = scanner.SiteType(s.Type, s.URL); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err = scanner.BackendFor(s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch s.Opts.Auth {
+		case "":
+			s.Opts.Auth = "basic"
+		case "basic", "digest":
+		default:
+			errs = append(errs, fmt.Errorf("ERROR: auth for %s must be basic or digest: <%s>", s.Name, c.Auth))
+		}
+		if s.Opts.Headers, err = parseHeaders(c.Headers); err != nil {
+			errs = append(errs, err)
+		}
+		if strip := strings.Trim(strings.Trim(c.Strip, "\""), "/"); strip != "" {
+			s.Strip = scanner.EntryKey("", strip, true)
+		}
 
-			if s1done {
-				fmt.Fprintf(lw, " - DONE!\n")
-			} else {
-				fmt.Fprintf(lw, "\n")
+		// every site gets a cookie jar, in case it hands out a session cookie,
+		// and it's seeded with any cookies we've been given
+		s.Opts.Jar = webhandler.NewJar()
+		if err = webhandler.SeedCookies(s.Opts.Jar, s.URL, c.Cookies); err != nil {
+			errs = append(errs, err)
+		}
+		if c.CookieFile != "" {
+			if err = webhandler.LoadCookieFile(s.Opts.Jar, strings.Trim(c.CookieFile, "\"")); err != nil {
+				errs = append(errs, fmt.Errorf("ERROR: unable to load cookies for %s: %w", s.Name, err))
 			}
+		}
 
-			if !s2done {
-				s2Duration = time.Since(startTime)
+		// a site that wants a client certificate gets a Transport of its own
+		cert, key := strings.Trim(c.Cert, "\""), strings.Trim(c.Key, "\"")
+		if (cert == "") != (key == "") {
+			errs = append(errs, fmt.Errorf("ERROR: %s needs both a client certificate and its key", s.Name))
+		} else if cert != "" {
+			if s.Opts.Transport, err = webhandler.NewSiteTransport(cert, key); err != nil {
+				errs = append(errs, fmt.Errorf("ERROR: unable to load the client certificate for %s: %w", s.Name, err))
 			}
+		}
 
-			fmt.Fprintf(lw.Newline(), "%-20s %-6s %5v files and directories", site2Name+":",
-				s2Duration.Round(time.Second).String(), site2Counter.Read())
-
-			if s2done {
-				fmt.Fprintf(lw, " - DONE!\n")
-			} else {
-				fmt.Fprintf(lw, "\n")
-			}
+		built = append(built, s)
+	}
 
-		case s1done = <-site1done:
-			s1Duration = time.Since(startTime)
+	return built, errs
 
-		case s2done = <-site2done:
-			s2Duration = time.Since(startTime)
+}
 
-		case <-stopupdating:
-			fmt.Fprintf(lw, "%-20s %-6s %5v files and directories - DONE!\n", site1Name+":",
-				s1Duration.Round(time.Second).String(), site1Counter.Read())
-			fmt.Fprintf(lw.Newline(), "%-20s %-6s %5v files and directories - DONE!\n", site2Name+":",
-				s2Duration.Round(time.Second).String(), site2Counter.Read())
+// configList reads a list of strings from the config. Lists in a config file
+// come through from Viper as lists, but an environment variable is just a
+// string - and Viper would split that on whitespace, which breaks entries like
+// "Parent Directory". So, we split strings on commas ourselves.
+func configList(v *viper.Viper, key string) []string {
 
-			lw.Stop()
+	raw, ok := v.Get(key).(string)
+	if !ok {
+		return v.GetStringSlice(key)
+	}
 
-			return
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
 		}
 	}
+
+	return list
+
 }
 
-func downloadWorker(id int, localpath, remotepath string, fileschan <-chan string) {
+// printConfig writes out every setting, for --print-config, whether it came
+// from a flag, the environment, the config file, or a default - one per line,
+// in the same "name <value>" form as the debug output, sorted by name. The
+// sites list is flattened, so its entries show up as sites[0].url and so on.
+// Anything secret is masked - see maskSetting.
+func printConfig(w io.Writer, settings map[string]interface{}) {
+
+	flat := make(map[string]interface{})
+	flattenSettings("", settings, flat)
+
+	names := make([]string, 0, len(flat))
+	for name := range flat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "%-24s <%v>\n", name, maskSetting(name, flat[name]))
+	}
+
+}
 
-	for file := range fileschan {
+// flattenSettings copies the settings in value into flat, with nested maps and
+// lists of maps spelled out in their names.
+func flattenSettings(name string, value interface{}, flat map[string]interface{}) {
 
-		if strings.HasSuffix(file, "/") {
-			if debug {
-				fmt.Printf("Worker %d skipping directory %s\n", id, file)
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			if name != "" {
+				k = name + "." + k
 			}
-			continue
+			flattenSettings(k, v, flat)
 		}
-
-		if strings.HasSuffix(file, dlSuffix) {
-			if debug {
-				fmt.Printf("Worker %d skipping download file %s\n", id, file)
+	case []interface{}:
+		for _, item := range value {
+			if _, ok := item.(map[string]interface{}); !ok {
+				flat[name] = value
+				return
 			}
-			continue
 		}
+		for i, item := range value {
+			flattenSettings(fmt.Sprintf("%s[%d]", name, i), item, flat)
+		}
+	default:
+		flat[name] = value
+	}
+
+}
 
-		fmt.Printf("Worker %d starting %s\n", id, file)
+// headerList turns a site's headers into "Name: value" lines, sorted by name,
+// the way they're given in the config - for maskSetting to mask.
+func headerList(headers map[string]string) []string {
 
-		if !dryrun {
+	list := make([]string, 0, len(headers))
+	for name, value := range headers {
+		list = append(list, name+": "+value)
+	}
+	sort.Strings(list)
 
-			if strings.HasPrefix(remotepath, "http") {
+	return list
 
-				// may refactor this to use grab's DoBatch function later...
+}
 
-				client := grab.NewClient()
-				req, _ := grab.NewRequest(localpath+file+dlSuffix, remotepath+file)
-				req.HTTPRequest.SetBasicAuth(site2User, site2Pass)
-				fmt.Printf("Worker %d downloading: %s\n", id, file)
+// secretSetting reports whether the setting called name (as flattenSettings
+// names it) is a password, token or secret key.
+func secretSetting(name string) bool {
+	last := strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+	return strings.Contains(last, "pass") || strings.Contains(last, "token") || strings.Contains(last, "secret")
+}
 
-				resp := client.Do(req)
+// maskSetting hides the secret part of a setting: all of a password, token or
+// secret key, the values of headers and cookies (which are often API keys or
+// sessions), and the password in a URL.
+func maskSetting(name string, value interface{}) interface{} {
 
-				if resp.Err() != nil {
-					fmt.Printf("Worker %d error downloading: %s: %v\n", id, resp.Request.URL(), resp.Err())
-					break
-				} else {
-					fmt.Printf("Worker %d finished: %s\n", id, file)
-				}
+	last := strings.ToLower(name[strings.LastIndex(name, ".")+1:])
 
+	switch {
+	case secretSetting(name):
+		if fmt.Sprint(value) == "" {
+			return value
+		}
+		return "********"
+
+	case strings.Contains(last, "header"), strings.Contains(last, "cookie") && !strings.Contains(last, "file"):
+		var list []string
+		switch value := value.(type) {
+		case []string:
+			list = value
+		case []interface{}:
+			for _, item := range value {
+				list = append(list, fmt.Sprint(item))
+			}
+		case string:
+			if value == "" {
+				return value
+			}
+			list = []string{value}
+		default:
+			return value
+		}
+		masked := make([]string, len(list))
+		for i, item := range list {
+			if name, _, ok := strings.Cut(item, ":"); ok {
+				masked[i] = name + ": ********"
+			} else if name, _, ok := strings.Cut(item, "="); ok {
+				masked[i] = name + "=********"
 			} else {
+				masked[i] = "********"
+			}
+		}
+		return masked
 
-				targetfile := localpath + file
-				targetdir := filepath.Dir(targetfile)
+	}
 
-				if targetdir == "." {
-					fmt.Printf("Worker %d target dir yields no path: %s\n", id, targetdir)
-					break
-				}
+	if str, ok := value.(string); ok && strings.Contains(str, "://") {
+		if u, err := url.Parse(str); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+	}
 
-				// since we're a local filesystem copy, and not HTTP, we can't trust
-				// a filecopy to pick up where we left off. So, remove the dlSuffix
-				// file, if it exists. If it doesn't, no biggie - we can ignore the error
-				if debug {
-					fmt.Printf("Worker %d removing dl file, if it exists\n", id)
-				}
+	return value
 
-				_ = os.Remove(file + dlSuffix)
+}
 
-				if debug {
-					fmt.Printf("Worker %d stat'ing %s\n", id, targetdir)
-				}
+// parseHeaders turns a list of "Name: value" headers into a map, ready to be used
+// in webhandler.Options.
+func parseHeaders(list []string) (map[string]string, error) {
 
-				_, err := os.Stat(targetdir)
-				if os.IsNotExist(err) {
-					err := os.MkdirAll(targetdir, 0777)
-					if err != nil {
-						fmt.Printf("Worker %d error making targetdir: %s\n", id, targetdir)
-						fmt.Printf("Worker %d error: %s\n", id, err)
-						break
-					}
-				}
+	headers := make(map[string]string)
 
-				// Can we link it? (a trick, if the file lives in this filesystem)
-				err = os.Link(remotepath+file, targetfile) // we should be so lucky...
-				if err == nil {
-					if debug {
-						fmt.Printf("Worker %d successfully linked %s\n", id, targetfile)
-					}
-				}
-				if err != nil {
-					// actually copy the file, then
-
-					source, err := os.Open(remotepath + file)
-					if err != nil {
-						fmt.Printf("tWorker %d error opening source: %s\n", id, url2+file)
-						fmt.Printf("Worker %d error: %s", id, err)
-						break
-					}
-					defer source.Close()
-
-					target, err := os.Create(targetfile + dlSuffix)
-					if err != nil {
-						fmt.Printf("Worker %d error creating target: %s\n", id, targetfile)
-						fmt.Printf("Worker %d error: %s", id, err)
-						break
-					}
-					defer target.Close()
-
-					_, err = io.Copy(source, target)
-					if err != nil {
-						fmt.Printf("Worker %d error copying file\n", id)
-						fmt.Printf("Worker %d error: %s\n", id, err)
-						break
-					}
+	for _, header := range list {
+		name, value, err := webhandler.ParseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
 
-				}
+	return headers, nil
 
-			}
+}
 
-			err := os.Rename(localpath+file+dlSuffix, localpath+file)
-			if err != nil {
-				fmt.Printf("Worker %d error renaming %s\n", id, localpath+file+dlSuffix)
-			}
+// compilePatterns compiles each of the given regular expressions, so the walk
+// doesn't have to do it over and over again. The first bad pattern is reported.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
 
-			_ = os.Chmod(localpath+file, 0777)
+	var compiled []*regexp.Regexp
 
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
 		}
-
+		compiled = append(compiled, re)
 	}
 
-	wg.Done()
+	return compiled, nil
+
 }
 
-func timeoutWorker(timechan <-chan bool) {
+// parseExtensions turns a comma separated list of extensions into a set, so
+// "MP3, .flac,jpg" gives mp3, flac and jpg. An empty list gives a nil set.
+func parseExtensions(list string) map[string]bool {
 
-	if debug {
-		fmt.Printf("timeoutWorker: starting\n")
-	}
+	var set map[string]bool
 
-	select {
-	case _, ok := <-timechan:
-		if !ok {
-			if debug {
-				fmt.Printf("timeoutWorker: timeout channel closed, exiting\n")
-			}
-			return
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "" {
+			continue
 		}
-	case <-time.After(time.Duration(timeout) * time.Hour):
-		fmt.Printf("Exiting at timeout interval of %d hours\n", timeout)
-		os.Exit(0)
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[ext] = true
 	}
 
-}
+	return set
 
-func downloadManager(localpath, remotepath string, filelist []string) {
+}
 
-	writable, err := writable.IsWritable(localpath, debug)
-	if err != nil {
-		fmt.Printf("Error checking if %s is writable\n", localpath)
-		log.Fatal(err)
-	} else if !writable {
-		fmt.Printf("ERROR: %s is not writable. Cannot download files.\n", localpath)
-		os.Exit(1)
-	}
+// walkFailed deals with a URL that a walk couldn't retrieve - it's the
+// scanner.ScanOptions Failed. Normally, we note it in walkErrors and carry on
+// with the rest of the tree - one bad directory shouldn't throw away everything
+// else we've found. With --fail-fast, it's the end of the road.
+func walkFailed(urltoget string, err error) {
 
-	if !strings.HasSuffix(localpath, "/") {
-		localpath = localpath + "/"
-	}
-	if !strings.HasSuffix(remotepath, "/") {
-		remotepath = remotepath + "/"
+	if failFast {
+		slog.Error("unable to retrieve URL", "url", urltoget, "err", err)
+		exit(1)
 	}
 
-	fileschan := make(chan string, len(filelist))
-	timechan := make(chan bool)
+	slog.Debug("unable to retrieve, skipping it", "url", urltoget, "err", err)
 
-	for _, file := range filelist {
-		if debug {
-			fmt.Printf("downloadManager: Adding to queue: %s\n", file)
-		}
-		fileschan <- file
-	}
-	close(fileschan)
-
-	for i := 1; i <= throttle; i++ {
-		if debug {
-			fmt.Printf("downloadManager: Adding thread %d to worker pool\n", i)
-		}
-		wg.Add(1)
-		go downloadWorker(i, localpath, remotepath, fileschan)
-	}
+	walkErrors.Add(urltoget, err)
 
-	if timeout > 0 {
-		if debug {
-			fmt.Printf("downloadManager: Starting timeout timer\n")
-		}
-		go timeoutWorker(timechan)
-	}
+}
 
-	if debug {
-		fmt.Printf("downloadManaager: waiting\n")
-	}
-	wg.Wait()
+// scanOptions gathers up the settings that decide what a walk looks at, and
+// keeps, for scanner.ScanSite.
+func scanOptions() scanner.ScanOptions {
 
-	if timeout > 0 {
-		if debug {
-			fmt.Printf("downloadManager: signalling timeoutWorker to exit\n")
-		}
-		close(timechan)
+	ignoreText := make(map[string]bool, len(ignoreThese))
+	for text := range ignoreThese {
+		ignoreText[text] = true
 	}
 
-	if debug {
-		fmt.Printf("downloadManager: exiting\n")
+	return scanner.ScanOptions{
+		IgnoreText:     ignoreText,
+		IgnorePatterns: ignoreRegexes,
+		Include:        includeGlobs,
+		Exclude:        excludeGlobs,
+		Extensions:     extensions,
+		MaxDepth:       maxDepth,
+		Concurrency:    walkConcurrency,
+		S3Region:       s3Region,
+		S3Endpoint:     s3Endpoint,
+		S3Profile:      s3Profile,
+		Failed:         walkFailed,
 	}
 
 }
 
-func compareMaps(sm1, sm2 *map[string]string) []string {
+func walkWrapper(ctx context.Context, i int, s *site) {
 
-	var filelist []string
-	// alpha sort the keys
+	scanner.ScanSite(ctx, s, scanOptions())
 
-	keys := make([]string, 0, len(*sm1))
-	for k := range *sm1 {
-		keys = append(keys, k)
+	if !noprogress {
+		sitedone <- i
 	}
-	sort.Strings(keys)
 
-	for _, k := range keys {
-		_, exists := (*sm2)[k]
-		if !exists {
-			if strings.HasSuffix(k, "/") {
-				if !suppress {
-					filelist = append(filelist, k)
+	wg.Done()
+
+}
+
+// updateProgress keeps a line per site on the screen, showing how long it's been
+// walking and how much it's found, until it's told to stop.
+func updateProgress() {
+
+	startTime := time.Now()
+	durations := make([]time.Duration, len(sites))
+	finished := make([]bool, len(sites))
+
+	for {
+		select {
+		case <-time.After(updateInterval):
+			for i, s := range sites {
+				if !finished[i] {
+					durations[i] = time.Since(startTime)
 				}
-			} else {
-				filelist = append(filelist, k)
+				progressLine(i, s, durations[i], finished[i])
 			}
+
+		case i := <-sitedone:
+			finished[i] = true
+			durations[i] = time.Since(startTime)
+
+		case <-stopupdating:
+			for i, s := range sites {
+				progressLine(i, s, durations[i], true)
+			}
+
+			lw.Stop()
+
+			return
 		}
 	}
+}
+
+// heartbeat logs a line to dlLog every heartbeatInterval, saying how much each
+// site's walk has found so far, until stop is closed. It's the --noprogress
+// stand-in for updateProgress: something to show that an unattended scan is
+// still alive, without the terminal control codes.
+func heartbeat(stop chan bool) {
+
+	startTime := time.Now()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dlLog.Print(heartbeatLine(time.Since(startTime)))
+		case <-stop:
+			return
+		}
+	}
+
+}
+
+// heartbeatLine is the line heartbeat logs, like "scanning... Site 1: 12340
+// files and directories (12000 files, 340 dirs), ... 14m0s elapsed".
+func heartbeatLine(elapsed time.Duration) string {
+
+	var b strings.Builder
+	b.WriteString("scanning...")
+	for _, s := range sites {
+		fmt.Fprintf(&b, " %s: %d files and directories (%s),", s.Name, s.Counter.Read(), s.Counter.Split())
+	}
+	fmt.Fprintf(&b, " %s elapsed", elapsed.Round(time.Second))
+
+	return b.String()
+
+}
+
+// progressLine writes one site's line of the progress display. The first line
+// goes to lw itself, and the rest to lw.Newline(), so they all redraw together.
+func progressLine(i int, s *site, elapsed time.Duration, done bool) {
+
+	var w io.Writer = lw
+	if i > 0 {
+		w = lw.Newline()
+	}
+
+	fmt.Fprintf(w, "%-20s %-6s %5v files and directories (%s)", s.Name+":",
+		elapsed.Round(time.Second).String(), s.Counter.Read(), s.Counter.Split())
+
+	if done {
+		fmt.Fprintf(w, " - DONE!\n")
+	} else {
+		fmt.Fprintf(w, "\n")
+	}
+
+}
+
+// workerLog reports what a download (or upload) worker is up to, through dlLog.
+func workerLog(id int, format string, args ...interface{}) {
+	if dlJSON != nil {
+		dlJSON.Info(fmt.Sprintf(format, args...), "worker", id)
+		return
+	}
+	dlLog.Printf("Worker %d %s", id, fmt.Sprintf(format, args...))
+}
+
+// batchLog reports what a web server's batch of downloads is up to, through
+// dlLog.
+func batchLog(format string, args ...interface{}) {
+	if dlJSON != nil {
+		dlJSON.Info(fmt.Sprintf(format, args...), "worker", "batch")
+		return
+	}
+	dlLog.Printf("Batch %s", fmt.Sprintf(format, args...))
+}
+
+// reportDownloads keeps the user up to date while the downloads run, until
+// it's sent something on stop - and then answers on stop once it's done. The
+// progress display is a uilive writer of its own, since lw still has the
+// scan's progress on screen: a rolling summary of the whole lot, followed by a
+// line for each web server download in flight. Log lines headed for the same
+// place go through its Bypass, so they don't get tangled up with it. With
+// --noprogress, the summary goes to dlLog every heartbeatInterval instead (if
+// it isn't 0).
+func reportDownloads(stop chan bool) {
+
+	started := time.Now()
+
+	var dw *uilive.Writer
+	interval := heartbeatInterval
+	if !noprogress {
+		dw = uilive.New()
+		dw.Out = lw.Out
+		dw.Start()
+		if out := dlLog.Writer(); out == lw.Out {
+			dlLog.SetOutput(dw.Bypass())
+			defer dlLog.SetOutput(out)
+		}
+		interval = updateInterval
+	}
+
+	// with no ticker, there's nothing to do but wait to stop
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var window rateWindow
+
+	for {
+		select {
+		case <-tick:
+			if dw == nil {
+				dlLog.Print(downloadSummary(time.Since(started), nil, downloadETA(&window, nil)))
+				continue
+			}
+			active := dlProgress.InFlight()
+			fmt.Fprintln(dw, downloadSummary(time.Since(started), active, downloadETA(&window, active)))
+			for _, resp := range active {
+				downloadLine(dw.Newline(), resp.Request.Tag.(string), resp.BytesComplete(), resp.Size, resp.BytesPerSecond())
+			}
+
+		case <-stop:
+			if dw != nil {
+				fmt.Fprintln(dw, downloadSummary(time.Since(started), nil, -1))
+				dw.Stop()
+			}
+			stop <- true
+			return
+		}
+	}
+
+}
+
+// downloadSummary is the one line summary of the downloads so far - files done
+// out of the total, bytes, the average rate, how long it's been, and how long
+// it's likely to be yet, if eta isn't negative. Bytes from the downloads in
+// active are counted too, so the numbers keep moving with big files.
+func downloadSummary(elapsed time.Duration, active []*grab.Response, eta time.Duration) string {
+
+	bytes := downloadedBytes(active)
+
+	rate := int64(0)
+	if elapsed > 0 {
+		rate = int64(float64(bytes) / elapsed.Seconds())
+	}
+
+	summary := fmt.Sprintf("Downloaded %d/%d files, %s, %s/s, %s elapsed", dlProgress.Finished.Read(), dlProgress.Total.Load(), humanSize(bytes),
+		humanSize(rate), elapsed.Round(time.Second))
+	if eta >= 0 {
+		summary += fmt.Sprintf(", about %s left", eta.Round(time.Second))
+	}
+	if failed := len(dlProgress.Errors.List()); failed > 0 {
+		summary += fmt.Sprintf(" (%d failed)", failed)
+	}
+
+	return summary
+
+}
+
+// downloadedBytes is how many bytes have been downloaded so far, including what
+// the downloads in active have got.
+func downloadedBytes(active []*grab.Response) int64 {
+
+	bytes := dlProgress.Bytes.Load()
+	for _, resp := range active {
+		bytes += resp.BytesComplete()
+	}
+
+	return bytes
+
+}
+
+// downloadETA adds how much has been downloaded by now to window, and works out
+// from its rate how long the rest will take. It's -1 if there's no telling -
+// when the size of some of the files isn't known, or nothing's come in lately.
+func downloadETA(window *rateWindow, active []*grab.Response) time.Duration {
+
+	bytes := downloadedBytes(active)
+	window.add(time.Now(), bytes)
+
+	total := dlProgress.TotalBytes.Load()
+	rate := window.rate()
+	if total < 0 || rate <= 0 {
+		return -1
+	}
+
+	remaining := total - dlProgress.SkippedBytes.Load() - bytes
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+
+}
+
+// rateWindowSpan is how far back rateWindow looks, so the ETA follows how fast
+// the downloads are going now, not how fast they went at the start.
+const rateWindowSpan = 30 * time.Second
+
+// rateWindow keeps a running tally of bytes downloaded over the last
+// rateWindowSpan, for a rolling average of the rate across all the workers.
+type rateWindow struct {
+	samples []rateSample
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// add records that bytes had been downloaded by at, and forgets the samples
+// that have fallen out of the window - always keeping one from before it, to
+// measure from.
+func (w *rateWindow) add(at time.Time, bytes int64) {
+
+	w.samples = append(w.samples, rateSample{at, bytes})
+
+	drop := 0
+	for drop < len(w.samples)-2 && at.Sub(w.samples[drop+1].at) >= rateWindowSpan {
+		drop++
+	}
+	w.samples = w.samples[drop:]
+
+}
+
+// rate is the average bytes per second over the window, or 0 until there's
+// enough to go on.
+func (w *rateWindow) rate() float64 {
+
+	if len(w.samples) < 2 {
+		return 0
+	}
+	first, last := w.samples[0], w.samples[len(w.samples)-1]
+	seconds := last.at.Sub(first.at).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+
+	return float64(last.bytes-first.bytes) / seconds
+
+}
+
+// downloadLine writes one download's line of the progress display - how much
+// of it is done, and how fast it's coming in. size is 0 or less if the server
+// didn't say how big the file is, in which case there's no percentage.
+func downloadLine(w io.Writer, file string, complete, size int64, rate float64) {
+
+	if len(file) > 40 {
+		file = "..." + file[len(file)-37:]
+	}
+
+	if size > 0 {
+		fmt.Fprintf(w, "%-40s %5.1f%% of %s at %s/s\n", file, 100*float64(complete)/float64(size),
+			humanSize(size), humanSize(int64(rate)))
+	} else {
+		fmt.Fprintf(w, "%-40s %s at %s/s\n", file, humanSize(complete), humanSize(int64(rate)))
+	}
+
+}
+
+// parseTimeout parses --timeout, which is a duration like "90m" or "2h30m". It
+// used to be a whole number of hours, so a plain number still means hours.
+func parseTimeout(s string) (time.Duration, error) {
+
+	if hours, err := strconv.Atoi(s); err == nil {
+		if hours < 0 {
+			return 0, fmt.Errorf("<%s> is negative", s)
+		}
+		return time.Duration(hours) * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("<%s> isn't a number of hours, or a duration like \"90m\"", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("<%s> is negative", s)
+	}
+
+	return d, nil
+
+}
+
+// parseBandwidth parses --bandwidth-limit, a number of bytes per second like
+// "500K", "5MB" or "1.5MiB/s". The units go up in 1024s, as they do for
+// humanSize. An empty limit, or 0, means no limit.
+func parseBandwidth(s string) (int64, error) {
+
+	if s == "" {
+		return 0, nil
+	}
+
+	limit, _, ok := scanner.ParseSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if !ok {
+		return 0, fmt.Errorf("<%s> isn't a number of bytes, like \"500K\" or \"5MB\"", s)
+	}
+
+	return limit, nil
+
+}
+
+// newBandwidthLimiter returns a Limiter that lets through limit bytes a second,
+// or nil if there's no limit. grab asks for a whole buffer (32KB) at a time, so
+// the limiter can never hand out less than that in one go.
+func newBandwidthLimiter(limit int64) *rate.Limiter {
+
+	if limit <= 0 {
+		return nil
+	}
+
+	burst := int(limit)
+	if burst < 32*1024 {
+		burst = 32 * 1024
+	}
+
+	return rate.NewLimiter(rate.Limit(limit), burst)
+
+}
+
+// parseMode parses a permission mode given in octal, like "0644" or "755".
+func parseMode(s string) (os.FileMode, error) {
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("<%s> isn't an octal number", s)
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("<%s> has bits set other than permissions", s)
+	}
+
+	return os.FileMode(mode), nil
+
+}
+
+// timeoutWorker cancels the run, with errTimedOut as the cause, once timeout has
+// passed - unless ctx is done with first. Everything watching ctx stops just as it
+// would for a Ctrl-C, and context.Cause tells the two apart afterwards.
+func timeoutWorker(ctx context.Context, cancel context.CancelCauseFunc) {
+
+	slog.Debug("timeoutWorker: starting")
+
+	select {
+	case <-ctx.Done():
+		slog.Debug("timeoutWorker: finished before the timeout, exiting")
+	case <-time.After(timeout):
+		slog.Debug("timeoutWorker: timeout is up, stopping", "timeout", timeout)
+		cancel(errTimedOut)
+	}
+
+}
+
+// openLogFile points dlLog at the --log-file, if there is one, and returns it
+// for the caller to close once the transfers are done.
+func openLogFile() *os.File {
+
+	if logFile == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		slog.Error("unable to open log file", "path", logFile, "err", err)
+		exit(1)
+	}
+	setDownloadLog(f, log.LstdFlags)
+
+	return f
+
+}
+
+// downloadOptions gathers up the download settings, for scanner.Download.
+func downloadOptions() scanner.DownloadOptions {
+
+	opts := scanner.DownloadOptions{
+		Source:          dlSource,
+		Site:            site2Opts,
+		Workers:         throttle,
+		PartialSuffix:   dlSuffix,
+		FileMode:        fileMode,
+		DirMode:         dirMode,
+		Umask:           umask,
+		Force:           force,
+		DryRun:          dryrun,
+		Verify:          verify,
+		PreserveTimes:   preserveTimes,
+		Bandwidth:       bandwidthLimiter,
+		UpdateInterval:  updateInterval,
+		VerifyChecksums: verifyChecksums,
+		ChecksumSuffix:  checksumSuffix,
+		Manifest:        manifestPath,
+		ManifestFormat:  manifestFormat,
+		ChecksumAlgo:    manifestAlgo,
+		ChecksumRetries: checksumRetries,
+		Log:             downloadLog,
+		Verbose:         debug,
+		Progress:        &dlProgress,
+	}
+	if dlHooks != nil {
+		opts.Downloaded = dlHooks.run
+	}
+
+	return opts
+
+}
+
+// downloadLog reports what scanner.Download is up to, through dlLog - with
+// workerLog for one of its workers, and batchLog for a web server's batch.
+func downloadLog(worker int, msg string) {
+
+	switch worker {
+	case scanner.NoWorker:
+		dlLog.Print(msg)
+	case scanner.BatchWorker:
+		batchLog("%s", msg)
+	default:
+		workerLog(worker, "%s", msg)
+	}
+
+}
+
+// downloadManager downloads everything in filelist from remotepath to
+// localpath, until it's done or ctx is cancelled.
+func downloadManager(ctx context.Context, localpath, remotepath string, filelist []string) downloadTotals {
+
+	if ok, err := writable.IsWritable(localpath); !ok {
+		slog.Error("not writable, so files can't be downloaded to it", "path", localpath, "err", err)
+		exit(1)
+	}
+
+	var stopreporting chan bool
+	if !dryrun {
+		stopreporting = make(chan bool)
+		go reportDownloads(stopreporting)
+	}
+
+	totals, err := scanner.Download(ctx, localpath, remotepath, filelist, downloadOptions())
+	if err != nil {
+		slog.Error("unable to load manifest", "err", err)
+		exit(1)
+	}
+
+	if dlHooks != nil {
+		dlHooks.wait()
+	}
+
+	if stopreporting != nil {
+		stopreporting <- true
+		<-stopreporting
+	}
+
+	if ctx.Err() != nil {
+		finished, failed := dlProgress.Finished.Read(), len(dlProgress.Errors.List())
+		stopped := "interrupted"
+		if context.Cause(ctx) == errTimedOut {
+			stopped = "stopped at the timeout"
+		}
+		slog.Warn("downloads "+stopped+" - anything partly downloaded was kept, and will be resumed next time",
+			"finished", finished, "failed", failed, "unfinished", int(dlProgress.Total.Load())-finished-failed, "suffix", dlSuffix)
+	}
+	if logFile != "" {
+		slog.Info("the downloads are logged in full in the log file", "path", logFile)
+	}
+
+	slog.Debug("downloadManager: exiting")
+
+	return downloadTotals{Queued: totals.Queued, Succeeded: totals.Succeeded, Failed: totals.Failed, Skipped: totals.Skipped}
+
+}
+
+// compareMaps lists the entries in sm1 that have no match in sm2. With
+// --ignore-case, an entry matches regardless of case, but is still listed with
+// the case it has in sm1.
+func compareMaps(sm1, sm2 *fileMap) []string {
+	return scanner.CompareMaps(sm1, sm2, compareOptions())
+}
+
+// compareOptions are the scanner.Options that --ignore-case and --suppress ask
+// for.
+func compareOptions() scanner.Options {
+	return scanner.Options{IgnoreCase: ignoreCase, SuppressDirs: suppress}
+}
+
+// downloadList turns the entries missing from Site 1 into the list of things to
+// download: sorted, so every run downloads in the same order, and with anything
+// that would be saved to the same place as something earlier in the list left
+// out, so nothing is fetched twice. Keys that only differ in their slashes or
+// "." segments (or, with --ignore-case, in their case) count as the same, and
+// the tidiest of them is the one that's kept.
+func downloadList(missing []string) []string {
+
+	sorted := append([]string(nil), missing...)
+	sort.Strings(sorted)
+
+	var filelist []string
+	seen := make(map[string]int)
+
+	for _, k := range sorted {
+
+		clean := cleanKey(k)
+		target := clean
+		if ignoreCase {
+			target = strings.ToLower(target)
+		}
+
+		i, exists := seen[target]
+		switch {
+		case !exists:
+			seen[target] = len(filelist)
+			filelist = append(filelist, k)
+		case k == clean && filelist[i] != cleanKey(filelist[i]):
+			slog.Debug("downloadList: skipping duplicate", "file", filelist[i])
+			filelist[i] = k
+		default:
+			slog.Debug("downloadList: skipping duplicate", "file", k)
+		}
+
+	}
+
+	// a tidier key may have taken an earlier one's place
+	sort.Strings(filelist)
 
 	return filelist
 
 }
 
-func main() {
+// cleanKey tidies up a map key, without any doubled slashes or "." and ".."
+// segments, keeping the trailing "/" that says it's a directory.
+func cleanKey(k string) string {
 
-	config()
+	clean := strings.TrimPrefix(path.Clean("/"+k), "/")
+	if strings.HasSuffix(k, "/") && clean != "" {
+		clean += "/"
+	}
+
+	return clean
+
+}
+
+// caseIndex is the scanner.CaseIndex for a site map, with --ignore-case.
+// Otherwise it's nil.
+func caseIndex(sm *fileMap) map[string][]string {
+	return scanner.CaseIndex(sm, ignoreCase)
+}
+
+// lookupEntry finds the entry for key in a site map, as scanner.Lookup does.
+func lookupEntry(sm *fileMap, index map[string][]string, key string) (fileEntry, bool) {
+	return scanner.Lookup(sm, index, key)
+}
 
-	if url1 == url2 {
-		fmt.Printf("Both sites are the same:\n")
-		fmt.Printf("    Site 1: %s\n", url1)
-		fmt.Printf("    Site 2: %s\n\n", url2)
-		fmt.Printf("Nothing to compare...")
-		os.Exit(1)
+// stripPrefix returns a new map holding just the entries under the directory
+// prefix in sm, with prefix taken off the front of their keys - so a site can be
+// compared as if it started further down. Their URLs are left alone, since
+// they're still relative to the site's URL. The number of entries kept is
+// returned as well, so a prefix that matched nothing can be pointed out.
+func stripPrefix(sm *fileMap, prefix string) (*fileMap, int) {
+
+	stripped := new(fileMap)
+	for key, entry := range sm.Snapshot() {
+		if strings.HasPrefix(key, prefix) && key != prefix {
+			stripped.Set(strings.TrimPrefix(key, prefix), entry)
+		}
 	}
 
-	if strings.HasPrefix(url1, "http") {
-		if download {
-			fmt.Println("ERROR: site1 cannot be HTTP(S) based with --download")
-			os.Exit(1)
+	return stripped, stripped.Len()
+
+}
+
+// stripBase returns the path (or URL) that a site's keys are relative to, once
+// its strip path has been taken off them.
+func stripBase(base, strip string) string {
+
+	if strip == "" {
+		return base
+	}
+	if scanner.IsHTTP(base) {
+		strip = scanner.EscapePath(strip)
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strip
+
+}
+
+// caseCollisions finds the groups of keys in a site map that are the same apart
+// from case. With --ignore-case, each of them matches the same entry at the
+// other site, which the user ought to know about. Otherwise, they don't matter.
+func caseCollisions(sm *fileMap) [][]string {
+
+	if !ignoreCase {
+		return nil
+	}
+
+	return scanner.CaseCollisions(sm)
+
+}
+
+// compareSizes finds the files that exist in both maps, but whose sizes don't
+// match, for --size-compare.
+func compareSizes(sm1, sm2 *fileMap) []sizeDiff {
+	return scanner.CompareSizes(sm1, sm2, compareOptions())
+}
+
+// compareTimes finds the files that exist in both maps, but were modified more
+// recently at site 2, for --newer-than.
+func compareTimes(sm1, sm2 *fileMap) []newerDiff {
+	return scanner.CompareTimes(sm1, sm2, compareOptions())
+}
+
+// compareChecksums finds the files that exist at both sites, but whose contents
+// differ. Local files are read and hashed. For a web server, we can't hash the
+// file without downloading it, so instead we ask for just the headers and use
+// the checksum the server reports there - if it doesn't report one, that's an
+// error, since we'd have no way to tell whether the file matches. Cancelling
+// ctx stops the comparison, with ctx's error.
+func compareChecksums(ctx context.Context, base1, base2 string, sm1, sm2 *fileMap, algo string) ([]checksumDiff, error) {
+
+	var diffs []checksumDiff
+	index := caseIndex(sm2)
+
+	keys := sm1.Keys()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return diffs, err
 		}
-		err := webhandler.ValidateURL(url1)
+		if strings.HasSuffix(k, "/") {
+			continue
+		}
+		e2, exists := lookupEntry(sm2, index, k)
+		if !exists {
+			continue
+		}
+
+		e1, _ := sm1.Get(k)
+		sum1, err := checksum.File(filepath.Join(base1, e1.URL), algo)
 		if err != nil {
-			fmt.Printf("ERROR: invalid URL: <%s>\n", url1)
-			fmt.Printf("%v\n", err)
-			os.Exit(1)
+			return diffs, err
 		}
-	} else {
-		_, err := os.Stat(url1)
+
+		sum2, err := remoteChecksum(ctx, base2, e2, site2Opts, algo)
 		if err != nil {
-			fmt.Printf("ERROR: path does not exist: <%s>\n", url1)
-			fmt.Printf("%v\n", err)
-			os.Exit(1)
+			return diffs, err
+		}
+
+		slog.Debug("checksums", "path", k, "site1", sum1, "site2", sum2)
+
+		if sum1 != sum2 {
+			diffs = append(diffs, checksumDiff{Name: k, Site1: sum1, Site2: sum2})
+		}
+	}
+
+	return diffs, nil
+
+}
+
+// remoteChecksum finds the checksum of a file at a site, which might be a local
+// path, or a web server reporting it in the response headers.
+func remoteChecksum(ctx context.Context, base string, entry fileEntry, opts webhandler.Options, algo string) (string, error) {
+
+	if !scanner.IsHTTP(base) {
+		return checksum.File(filepath.Join(base, entry.URL), algo)
+	}
+
+	urltoget := base + entry.URL
+	response, err := webhandler.HeadHandler(ctx, urltoget, opts)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+
+	sum, ok := checksum.FromHeader(response.Header, algo)
+	if !ok {
+		return "", fmt.Errorf("server didn't report a %s checksum for <%s> - --checksum needs a server "+
+			"that sends Digest, Content-MD5, or X-Checksum headers", algo, urltoget)
+	}
+
+	return sum, nil
+
+}
+
+// openOutputFile opens the file the comparison should be written to, either
+// replacing what's there, or adding to the end of it.
+func openOutputFile(name string, appendTo bool) (*os.File, error) {
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	return os.OpenFile(name, flags, 0644)
+
+}
+
+// formatSize shows a size in bytes, marking it with a "~" if it's approximate.
+func formatSize(e fileEntry) string {
+	if e.SizeApprox {
+		return fmt.Sprintf("~%d", e.Size)
+	}
+	return fmt.Sprintf("%d", e.Size)
+}
+
+// validateConfig checks the sites and options config() has settled on, and
+// returns everything that's wrong with them - starting with the settingErrors
+// config() came across - so they can all be fixed in one go rather than one run
+// at a time. Along the way, a web server's URL is normalized, so that the same
+// site given with and without its trailing "/" is still seen to be the same,
+// and url1 and url2 are brought up to date.
+func validateConfig() []error {
+
+	errs := append([]error(nil), settingErrors...)
+
+	for _, s := range sites {
+		switch {
+		case scanner.IsHTTP(s.URL):
+			normalized, err := webhandler.NormalizeURL(s.URL)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid URL for %s: <%s>: %w", s.Name, s.URL, err))
+				continue
+			}
+			s.URL = normalized
+		case isSnapshot(s.URL):
+			if _, err := os.Stat(snapshotPath(s.URL)); err != nil {
+				errs = append(errs, fmt.Errorf("snapshot for %s does not exist: <%s>: %w", s.Name, s.URL, err))
+			}
+		case scanner.URLScheme(s.URL) != "file":
+			if err := webhandler.ValidateURL(s.URL, scanner.Schemes()...); err != nil {
+				errs = append(errs, fmt.Errorf("invalid URL for %s: <%s>: %w", s.Name, s.URL, err))
+			}
+		default:
+			if _, err := os.Stat(s.URL); err != nil {
+				errs = append(errs, fmt.Errorf("path for %s does not exist: <%s>: %w", s.Name, s.URL, err))
+			}
+		}
+	}
+
+	for i, s1 := range sites {
+		for _, s2 := range sites[i+1:] {
+			if s1.URL == s2.URL {
+				errs = append(errs, fmt.Errorf("%s and %s are the same (%s) - nothing to compare", s1.Name, s2.Name, s1.URL))
+			}
+		}
+	}
+
+	if len(sites) >= 2 {
+		url1, url2 = sites[0].URL, sites[1].URL
+	}
+
+	if len(sites) > 2 && (download || sizeCompare || newerThan || checksumAlgo != "") {
+		errs = append(errs, fmt.Errorf("--download, --size-compare, --newer-than and --checksum only work with two sites"))
+	}
+	if len(sites) > 2 && upload {
+		errs = append(errs, fmt.Errorf("--upload only works with two sites"))
+	}
+	if len(sites) > 2 && outputFormat == "sync" {
+		errs = append(errs, fmt.Errorf("--sync-preview only works with two sites"))
+	}
+
+	if download && upload {
+		errs = append(errs, fmt.Errorf("--download and --upload can't be used together"))
+	}
+	if upload && (scanner.URLScheme(url1) != "file" || !scanner.IsHTTP(url2)) {
+		errs = append(errs, fmt.Errorf("--upload needs site1 to be a local path, and site2 a web server that accepts PUT (like a WebDAV share)"))
+	}
+	if download && scanner.URLScheme(url1) != "file" {
+		errs = append(errs, fmt.Errorf("site1 cannot be HTTP(S), FTP or S3 based with --download"))
+	}
+	if (download || checksumAlgo != "") && scanner.IsS3(url2) {
+		errs = append(errs, fmt.Errorf("--download and --checksum don't work with an S3 site2"))
+	}
+	if (download || checksumAlgo != "") && isSnapshot(url2) {
+		errs = append(errs, fmt.Errorf("--download and --checksum need site2 itself, not a snapshot of it"))
+	}
+	if dryrun && !download && !upload {
+		errs = append(errs, fmt.Errorf("--dryrun requires --download or --upload"))
+	}
+	if throttle > 1 && !download && !upload {
+		errs = append(errs, fmt.Errorf("--throttle requires --download or --upload - for a faster scan, try --walk-concurrency"))
+	}
+
+	if checksumAlgo != "" {
+		if _, err := checksum.New(checksumAlgo); err != nil {
+			errs = append(errs, err)
+		}
+		if scanner.URLScheme(url1) != "file" {
+			errs = append(errs, fmt.Errorf("--checksum requires site1 to be a local path"))
+		}
+	}
+
+	if throttle < 1 {
+		errs = append(errs, fmt.Errorf("--throttle must be at least 1, not %d", throttle))
+	}
+	if timeout < 0 {
+		errs = append(errs, fmt.Errorf("--timeout can't be negative"))
+	}
+
+	if updateInterval <= 0 {
+		errs = append(errs, fmt.Errorf("--progress-interval must be more than 0, not %s", updateInterval))
+	}
+	if heartbeatInterval < 0 {
+		errs = append(errs, fmt.Errorf("--heartbeat can't be less than 0, not %s", heartbeatInterval))
+	}
+	if !slices.Contains(logFormats, logFormat) {
+		errs = append(errs, fmt.Errorf("--log-format must be text or json, not %q", logFormat))
+	}
+	if webhandler.MaxRedirects < 0 {
+		errs = append(errs, fmt.Errorf("--max-redirects can't be negative, not %d", webhandler.MaxRedirects))
+	}
+	if requestRate < 0 {
+		errs = append(errs, fmt.Errorf("--request-rate can't be negative"))
+	}
+	if walkConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("--walk-concurrency must be at least 1, not %d", walkConcurrency))
+	}
+	if dlSuffix == "" || strings.ContainsAny(dlSuffix, `/\`) {
+		errs = append(errs, fmt.Errorf("--partial-suffix can't be empty, or contain a path separator"))
+	}
+
+	if _, err := checksum.New(manifestAlgo); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --manifest-algo: %w", err))
+	}
+	if manifestFormat != "gnu" && manifestFormat != "bsd" {
+		errs = append(errs, fmt.Errorf("--manifest-format must be gnu or bsd"))
+	}
+	if checksumRetries < 0 {
+		errs = append(errs, fmt.Errorf("--checksum-retries can't be negative"))
+	}
+
+	for _, pattern := range append(append([]string{}, includeGlobs...), excludeGlobs...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --include/--exclude pattern %q: %w", pattern, err))
+		}
+	}
+
+	switch {
+	case outputJSON && outputCSV:
+		errs = append(errs, fmt.Errorf("--output-json and --output-csv can't be used together"))
+	case (outputJSON || outputCSV) && quietOutput:
+		errs = append(errs, fmt.Errorf("--quiet can't be used with --output-json or --output-csv"))
+	case (outputJSON || outputCSV) && pathEnd == "\x00":
+		errs = append(errs, fmt.Errorf("--print0 can't be used with --output-json or --output-csv"))
+	}
+	if syncPreview && (outputJSON || outputCSV || outputFormat == "quiet") {
+		errs = append(errs, fmt.Errorf("--sync-preview can't be used with --output-json, --output-csv or --quiet"))
+	}
+	if syncPreview && download {
+		errs = append(errs, fmt.Errorf("--sync-preview can't be used with --download - it's for planning one"))
+	}
+
+	if notifyURL != "" {
+		if err := webhandler.ValidateURL(notifyURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --notify-webhook: %w", err))
+		}
+	}
+
+	return errs
+
+}
+
+func main() {
+
+	runStarted = time.Now()
+
+	config()
+
+	if errs := validateConfig(); len(errs) > 0 {
+		slog.Error(fmt.Sprintf("the configuration has %d problem(s)", len(errs)))
+		for _, err := range errs {
+			slog.Error(strings.TrimPrefix(err.Error(), "ERROR: "))
 		}
+		exit(1)
 	}
 
-	if strings.HasPrefix(url2, "http") {
-		err := webhandler.ValidateURL(url2)
+	// open the output file before we start, so we don't find out it can't be
+	// written after a long scan
+	var out io.Writer = os.Stdout
+	if outputFile != "" {
+		f, err := openOutputFile(outputFile, appendOutput)
 		if err != nil {
-			fmt.Printf("ERROR: invalid URL: <%s>\n", url2)
-			fmt.Printf("%v\n", err)
-			os.Exit(1)
+			slog.Error("unable to open output file", "path", outputFile, "err", err)
+			exit(1)
 		}
-	} else {
-		_, err := os.Stat(url2)
+		defer f.Close()
+		out = f
+	}
+
+	// likewise, find out about a state file from the wrong sites up front
+	var previous *scanState
+	if stateFile != "" {
+		st, err := loadState(stateFile, sites)
 		if err != nil {
-			fmt.Printf("ERROR: path does not exist: <%s>\n", url2)
-			fmt.Printf("%v\n", err)
-			os.Exit(1)
+			slog.Error("unable to load state file", "path", stateFile, "err", err)
+			exit(1)
+		}
+		// downloads and uploads still go after every difference, so anything
+		// that failed last time gets another go - only a report is cut down
+		if !refresh && !download && !upload {
+			previous = st
 		}
 	}
 
-	fmt.Println("")
-	fmt.Printf("%-20s %s\n", site1Name+":", url1)
-	fmt.Printf("%-20s %s\n", site2Name+":", url2)
+	fmt.Fprintln(statusOut, "")
+	for _, s := range sites {
+		fmt.Fprintf(statusOut, "%-20s %s\n", s.Name+":", s.URL)
+	}
+	if previous != nil {
+		fmt.Fprintf(statusOut, "\nOnly reporting differences since %s\n", previous.Saved.Format(time.RFC1123))
+	}
+
+	// Ctrl-C (or a SIGTERM) cancels ctx, and everything winds down from there.
+	// Once it has, the signals are let go, so a second Ctrl-C stops us dead
+	sigctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigctx.Done()
+		stop()
+	}()
+
+	// --timeout cancels it, too, but with errTimedOut as the cause
+	ctx, cancel := context.WithCancelCause(sigctx)
+	defer cancel(nil)
+	if timeout > 0 {
+		go timeoutWorker(ctx, cancel)
+	}
 
-	fmt.Printf("\nConnecting to servers...\n\n")
+	// find out whether Site 2 takes uploads before the scan, not after it
+	davUpload := false
+	if upload {
+		dav, err := uploadable(ctx, stripBase(url2, sites[1].Strip))
+		if err != nil {
+			slog.Error("site won't accept uploads", "site", site2Name, "url", url2, "err", err)
+			exit(1)
+		}
+		davUpload = dav
+	}
 
-	site1done = make(chan bool)
-	site2done = make(chan bool)
+	fmt.Fprintf(statusOut, "\nConnecting to servers...\n\n")
 
-	wg.Add(1)
-	go walkWrapper(url1, &site1Map, site1User, site1Pass, site1done, &site1Counter)
+	sitedone = make(chan int)
 
-	wg.Add(1)
-	go walkWrapper(url2, &site2Map, site2User, site2Pass, site2done, &site2Counter)
+	for i, s := range sites {
+		wg.Add(1)
+		go walkWrapper(ctx, i, s)
+	}
 
 	if !noprogress {
 		lw.Start()
@@ -744,8 +2529,18 @@ func main() {
 		go updateProgress()
 	}
 
+	var stopheartbeat chan bool
+	if noprogress && heartbeatInterval > 0 {
+		stopheartbeat = make(chan bool)
+		go heartbeat(stopheartbeat)
+	}
+
 	wg.Wait()
 
+	if stopheartbeat != nil {
+		close(stopheartbeat)
+	}
+
 	if !noprogress {
 		stopupdating <- true
 
@@ -754,54 +2549,199 @@ func main() {
 		// than add a second waitqueue, this seemed like a more reasonable approach.
 		time.Sleep(time.Second)
 
-		fmt.Printf("\n\n")
+		fmt.Fprintf(statusOut, "\n\n")
+	}
+
+	if showStats {
+		if err := renderStats(statusOut, gatherStats(sites)); err != nil {
+			slog.Warn("unable to write the stats", "err", err)
+		}
+	}
+
+	// when we're interrupted, a walk that was cut short would make for a
+	// misleading comparison, so just say how far each one got. At the timeout,
+	// the comparison is what the user's been waiting all this time for, so
+	// they get what there is of it - but it's no basis for a state file, or
+	// downloads, and there's no time left for checksums
+	timedOut := false
+	if ctx.Err() != nil {
+		if context.Cause(ctx) != errTimedOut {
+			fmt.Fprintf(statusOut, "Interrupted before the scan finished:\n")
+			for _, s := range sites {
+				fmt.Fprintf(statusOut, "    %-20s %d files and directories found (%s)\n", s.Name+":", s.Counter.Read(), s.Counter.Split())
+			}
+			exit(130)
+		}
+		slog.Warn("the timeout was reached before the scan finished - these are only the differences in what was found by then", "timeout", timeout)
+		timedOut = true
+		checksumAlgo = ""
+	}
+
+	// a snapshot's only worth keeping if it's of the whole site, and it's
+	// taken before the strip paths leave some of it out
+	if snapshotDir != "" && !timedOut {
+		if err := saveSnapshots(snapshotDir, sites, runStarted); err != nil {
+			slog.Warn("unable to save snapshots", "dir", snapshotDir, "err", err)
+		}
+	}
+
+	for _, s := range sites {
+		if s.Strip == "" {
+			continue
+		}
+		var kept int
+		if s.Map, kept = stripPrefix(s.Map, s.Strip); kept == 0 {
+			slog.Warn("nothing was found under the strip path", "strip", s.Strip, "site", s.Name)
+		}
+	}
+
+	if ignoreCase {
+		for _, s := range sites {
+			for _, keys := range caseCollisions(s.Map) {
+				slog.Warn("these paths only differ by case, so --ignore-case treats them as one", "site", s.Name, "paths", strings.Join(keys, ", "))
+			}
+		}
 	}
 
-	if download {
+	site1Map, site2Map := sites[0].Map, sites[1].Map
+
+	if download && !timedOut {
+
+		keepState(nil)
+
+		filelist := downloadList(compareMaps(site2Map, site1Map))
+		report.Differences = len(filelist)
 
-		filelist := compareMaps(&site2Map, &site1Map)
+		writeBanner(out, "Downloading from "+site2Name+":")
 
-		banner := "Downloading from "
-		fmt.Printf("%s%s:\n", banner, site2Name)
-		for i := 0; i < len(banner+site2Name+":"); i++ {
-			fmt.Printf("=")
+		if f := openLogFile(); f != nil {
+			defer f.Close()
 		}
-		fmt.Printf("\n\n")
 
 		// url1 still serves as our base path to download to... and url2 is still the
 		// base on the other side. Note that we need to use site2Map to get the
 		// proper URL to pull from!
 
-		downloadManager(url1, url2, filelist)
+		if dryrun {
+			renderDryRun(out, site2Map, filelist)
+		}
 
-	} else {
+		// with strip paths, the files are further down on each side
+		localpath, remotepath := stripBase(url1, sites[0].Strip), stripBase(url2, sites[1].Strip)
+		if sites[0].Strip != "" && !dryrun {
+			if err := os.MkdirAll(localpath, dirMode); err != nil {
+				slog.Error("unable to make directory", "path", localpath, "err", err)
+				exit(1)
+			}
+		}
+
+		// deleting what's gone from Site 2 is only safe if we saw all of it,
+		// and it's best to ask before the downloads, rather than after them
+		var deletions []string
+		if deleteFiles {
+			deletions = compareMaps(site1Map, site2Map)
+			switch {
+			case len(walkErrors.List()) > 0:
+				slog.Warn("not deleting anything, since some of the site couldn't be listed", "site", site2Name)
+				deletions = nil
+			case len(deletions) == 0, dryrun, assumeYes:
+			case !confirm(fmt.Sprintf("Delete the %d files and directories in %s that aren't at %s?", len(deletions), localpath, site2Name)):
+				fmt.Fprintf(statusOut, "Not deleting anything\n")
+				deletions = nil
+			}
+		}
 
-		banner := "Files/directories only at "
+		dlSource = site2Map
+		dl := downloadManager(ctx, localpath, remotepath, filelist)
 
-		fmt.Printf("%s%s:\n", banner, site1Name)
-		for i := 0; i < len(banner+site1Name+":"); i++ {
-			fmt.Printf("=")
+		if len(deletions) > 0 && ctx.Err() == nil {
+			dl.Deleted, dl.DeletedDirs = deleteLocal(localpath, deletions)
 		}
-		fmt.Printf("\n\n")
+		report.Downloads = &dl
 
-		filelist := compareMaps(&site1Map, &site2Map)
-		for _, file := range filelist {
-			fmt.Println(file)
+		renderFetchErrors(out, walkErrors.List())
+		renderDownloadErrors(out, dlProgress.Errors.List())
+		renderDownloadSummary(out, dl)
+
+		if ctx.Err() != nil && context.Cause(ctx) != errTimedOut {
+			exit(130)
+		}
+
+	} else if upload && !timedOut {
+
+		keepState(nil)
+
+		filelist := downloadList(compareMaps(site1Map, site2Map))
+		report.Differences = len(filelist)
+
+		writeBanner(out, "Uploading to "+site2Name+":")
+
+		if f := openLogFile(); f != nil {
+			defer f.Close()
+		}
+
+		// with strip paths, the files are further down on each side
+		localpath, remotepath := stripBase(url1, sites[0].Strip), stripBase(url2, sites[1].Strip)
+		up := uploadManager(ctx, localpath, remotepath, filelist, davUpload)
+		report.Uploads = &up
+
+		renderFetchErrors(out, walkErrors.List())
+		renderUploadErrors(out, upErrors.List())
+		renderUploadSummary(out, up)
+
+		if ctx.Err() != nil && context.Cause(ctx) != errTimedOut {
+			exit(130)
+		}
+
+	} else if len(sites) > 2 {
+
+		result := compareAllSites(sites)
+		if !timedOut {
+			keepState(nil)
 		}
-		fmt.Printf("\n\n")
+		if previous != nil {
+			result = newSinceMulti(result, previous)
+		}
+		report.Differences = result.differences()
+
+		if err := renderMulti(out, result); err != nil {
+			slog.Error("unable to write output", "format", outputFormat, "err", err)
+			exit(1)
+		}
+
+		if failOnDiff && result.hasDifferences() {
+			exit(exitDiff)
+		}
+
+	} else {
 
-		fmt.Printf("%s%s:\n", banner, site2Name)
-		for i := 0; i < len(banner+site2Name+":"); i++ {
-			fmt.Printf("=")
+		// the state's saved once the comparison's made, so the checksum
+		// differences can go in it - all of them, not just the new ones
+		result, err := compareSites(ctx, site1Map, site2Map)
+		if !timedOut {
+			keepState(result.ChecksumDiffs)
+		}
+		if previous != nil {
+			result = newSince(result, previous)
+		}
+		report.Differences = result.differences()
+
+		if err := render(out, result); err != nil {
+			slog.Error("unable to write output", "format", outputFormat, "err", err)
+			exit(1)
 		}
-		fmt.Printf("\n\n")
 
-		filelist = compareMaps(&site2Map, &site1Map)
-		for _, file := range filelist {
-			fmt.Println(file)
+		if err != nil {
+			slog.Error("checksum comparison stopped early", "err", err)
+			exit(1)
+		}
+
+		if failOnDiff && result.hasDifferences() {
+			exit(exitDiff)
 		}
-		fmt.Printf("\n\n")
 
 	}
 
+	finish(0)
+
 }
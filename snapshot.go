@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/davexre/sitescan/scanner"
+)
+
+// snapshotVersion is the version of the snapshot format this sitescan writes.
+// It goes up whenever the format changes in a way an older sitescan couldn't
+// read, and a snapshot from a newer version than this won't be loaded.
+const snapshotVersion = 1
+
+// siteSnapshot is what --save-snapshot writes for a site: where it was, when
+// it was captured, and everything that was found there. A snapshot:// site
+// reads one back in place of walking.
+type siteSnapshot struct {
+	Version  int                  `json:"version"`
+	Name     string               `json:"name"`
+	URL      string               `json:"url"`
+	Captured time.Time            `json:"captured"`
+	Entries  map[string]fileEntry `json:"entries"`
+}
+
+func init() {
+	scanner.RegisterBackend("snapshot", snapshotBackend{})
+}
+
+// snapshotBackend loads a site's map from a snapshot file, without going near
+// the site itself.
+type snapshotBackend struct{}
+
+func (snapshotBackend) Walk(ctx context.Context, s *site, opts scanner.ScanOptions) {
+
+	snap, err := loadSnapshot(snapshotPath(s.URL))
+	if err != nil {
+		opts.Fail(s.URL, err)
+		return
+	}
+
+	// the same filters as a walk, so a snapshot compares like the site would
+	for k, e := range snap.Entries {
+		isDir := strings.HasSuffix(k, "/")
+		if opts.MaxDepth > 0 && strings.Count(strings.TrimSuffix(k, "/"), "/") >= opts.MaxDepth {
+			continue
+		}
+		if !opts.Wanted(k) {
+			continue
+		}
+		s.Counter.Incr(isDir)
+		s.Counter.Reached(strings.Count(strings.TrimSuffix(k, "/"), "/") + 1)
+		s.Map.Set(k, e)
+	}
+
+	slog.Debug("loaded snapshot", "site", s.Name, "url", snap.URL, "captured", snap.Captured, "entries", len(snap.Entries))
+
+}
+
+// isSnapshot reports whether a site URL is a snapshot:// one.
+func isSnapshot(u string) bool {
+	return scanner.URLScheme(u) == "snapshot"
+}
+
+// snapshotPath is the file a snapshot:// URL points at. What follows the
+// "snapshot://" is a path, so snapshot:///srv/snap.json is absolute and
+// snapshot://snap.json is relative to the current directory.
+func snapshotPath(u string) string {
+	return u[len("snapshot://"):]
+}
+
+// loadSnapshot reads a snapshot saved by --save-snapshot.
+func loadSnapshot(path string) (*siteSnapshot, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap siteSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("%s isn't a sitescan snapshot: %w", path, err)
+	}
+	if snap.Version < 1 || snap.Entries == nil {
+		return nil, fmt.Errorf("%s isn't a sitescan snapshot", path)
+	}
+	if snap.Version > snapshotVersion {
+		return nil, fmt.Errorf("%s is a version %d snapshot - this sitescan only reads up to version %d", path, snap.Version, snapshotVersion)
+	}
+
+	return &snap, nil
+
+}
+
+// snapshotSites are the sites for --diff-snapshots: the two snapshot files at
+// paths, older first, each named after the site it was taken of and when -
+// like "Mirror (2024-05-01 02:00:00)" - so two snapshots of the same site can
+// be told apart.
+func snapshotSites(paths []string) ([]siteConfig, error) {
+
+	if len(paths) != 2 {
+		return nil, fmt.Errorf("it needs two snapshot files, not %d", len(paths))
+	}
+
+	var configs []siteConfig
+	for _, path := range paths {
+		snap, err := loadSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, siteConfig{
+			URL:  "snapshot://" + path,
+			Name: fmt.Sprintf("%s (%s)", snap.Name, snap.Captured.Local().Format("2006-01-02 15:04:05")),
+		})
+	}
+
+	return configs, nil
+
+}
+
+// saveSnapshots writes a snapshot of each site that was walked to dir, for
+// --save-snapshot, named after the site and when it was captured (see
+// snapshotFile), so each run adds to the ones before. Sites that were loaded
+// from snapshots themselves are left out. If two sites' names would give them
+// the same file, neither is saved.
+func saveSnapshots(dir string, all []*site, captured time.Time) error {
+
+	files := make(map[string]string)
+	for _, s := range all {
+		if isSnapshot(s.URL) {
+			continue
+		}
+		name := snapshotFile(s.Name, captured)
+		if other, exists := files[name]; exists {
+			return fmt.Errorf("%s and %s would both be saved in %s - give them names that differ in more than punctuation", other, s.Name, name)
+		}
+		files[name] = s.Name
+	}
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+
+	for _, s := range all {
+		if isSnapshot(s.URL) {
+			continue
+		}
+		u := s.URL
+		if scanner.URLScheme(u) != "file" {
+			u = redactURL(u)
+		}
+		snap := siteSnapshot{
+			Version:  snapshotVersion,
+			Name:     s.Name,
+			URL:      u,
+			Captured: captured,
+			Entries:  s.Map.Snapshot(),
+		}
+		data, err := json.MarshalIndent(snap, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, snapshotFile(s.Name, captured)), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// unsafeFileChars are what snapshotFile replaces in a site's name.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// snapshotFile is the name of the file a site's snapshot is saved in, made from
+// its name and when it was captured (in UTC): "Site 2", captured at 3am on 15
+// October 2026, is saved in Site_2-20261015T030000Z.json.
+func snapshotFile(name string, captured time.Time) string {
+
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_.")
+	if name == "" {
+		name = "site"
+	}
+
+	return name + "-" + captured.UTC().Format("20060102T150405Z") + ".json"
+
+}
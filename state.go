@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/davexre/sitescan/syncedmap"
+)
+
+// scanState is what --state saves between runs: which sites were walked, when,
+// and everything that was found at each of them. The checksum differences the
+// comparison found are kept too, since unlike the rest, they can't be worked
+// out again from the maps.
+type scanState struct {
+	Saved         time.Time              `json:"saved"`
+	URLs          []string               `json:"urls"`
+	Maps          []map[string]fileEntry `json:"maps"`
+	ChecksumDiffs []checksumDiff         `json:"checksum_differences,omitempty"`
+}
+
+// loadState reads the state saved by an earlier run. If there isn't one yet, it
+// returns nil - the first run has nothing to go on. A state saved from a
+// different set of sites is an error, since comparing against it would be
+// meaningless.
+func loadState(path string, all []*site) (*scanState, error) {
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var st scanState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s isn't a sitescan state file: %w", path, err)
+	}
+
+	if len(st.URLs) != len(all) || len(st.Maps) != len(all) {
+		return nil, fmt.Errorf("%s was saved from %d sites, not %d", path, len(st.URLs), len(all))
+	}
+	for i, s := range all {
+		if st.URLs[i] != s.URL {
+			return nil, fmt.Errorf("%s was saved from <%s>, not <%s>", path, st.URLs[i], s.URL)
+		}
+	}
+
+	return &st, nil
+
+}
+
+// saveState writes every site's map to path, for the next run, along with the
+// checksum differences found this time. It's written with writeFileAtomic, so a
+// run that's interrupted can't leave half a state behind.
+func saveState(path string, all []*site, checksums []checksumDiff) error {
+
+	st := scanState{Saved: time.Now(), ChecksumDiffs: checksums}
+	for _, s := range all {
+		st.URLs = append(st.URLs, s.URL)
+		st.Maps = append(st.Maps, s.Map.Snapshot())
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data, 0600)
+
+}
+
+// writeFileAtomic writes data to path, with permissions perm, by way of a
+// temporary file alongside it, which is renamed into place once it's all there,
+// so nothing reading path can ever see half of it.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+
+}
+
+// keepState saves the state for --state, if there's a state file, warning if it
+// can't. checksums are the checksum differences this run found, before newSince
+// had its way with them.
+func keepState(checksums []checksumDiff) {
+
+	if stateFile == "" {
+		return
+	}
+
+	if err := saveState(stateFile, sites, checksums); err != nil {
+		slog.Warn("unable to save state file", "path", stateFile, "err", err)
+	}
+
+}
+
+// newSince drops the differences from result that were already there in the
+// previous state, leaving just the ones that have turned up since. A size,
+// time or checksum difference only counts as already there if it's the same
+// as it was then - a file that's changed again since is reported again.
+func newSince(result comparison, previous *scanState) comparison {
+
+	prev1, prev2 := syncedmap.New(previous.Maps[0]), syncedmap.New(previous.Maps[1])
+
+	result.Site1Only = onlyNew(result.Site1Only, compareMaps(prev1, prev2))
+	result.Site2Only = onlyNew(result.Site2Only, compareMaps(prev2, prev1))
+
+	if result.SizeDiffs != nil {
+		result.SizeDiffs = onlyNewDiffs(result.SizeDiffs, compareSizes(prev1, prev2), func(d sizeDiff) string {
+			return fmt.Sprintf("%s %d %d", d.Name, d.Site1.Size, d.Site2.Size)
+		})
+	}
+	if result.NewerDiffs != nil {
+		result.NewerDiffs = onlyNewDiffs(result.NewerDiffs, compareTimes(prev1, prev2), func(d newerDiff) string {
+			return fmt.Sprintf("%s %d %d", d.Name, d.Site1.ModTime.Unix(), d.Site2.ModTime.Unix())
+		})
+	}
+	if result.ChecksumDiffs != nil {
+		result.ChecksumDiffs = onlyNewDiffs(result.ChecksumDiffs, previous.ChecksumDiffs, func(d checksumDiff) string {
+			return d.Name + " " + d.Site1 + " " + d.Site2
+		})
+	}
+
+	return result
+
+}
+
+// newSinceMulti is newSince for a comparison of more than two sites. There's
+// only what each site is missing to filter, since sizes, times and checksums
+// are only compared between two.
+func newSinceMulti(result multiComparison, previous *scanState) multiComparison {
+
+	var prevSites []*site
+	for i, m := range previous.Maps {
+		prevSites = append(prevSites, &site{Name: result.Sites[i].Name, URL: previous.URLs[i], Map: syncedmap.New(m)})
+	}
+	prevResult := compareAllSites(prevSites)
+
+	for i := range result.Missing {
+		var names []string
+		for _, entry := range prevResult.Missing[i].Missing {
+			names = append(names, entry.Path)
+		}
+		result.Missing[i].Missing = onlyNew(result.Missing[i].Missing, names)
+	}
+
+	return result
+
+}
+
+// onlyNewDiffs drops the differences in diffs that are in previous too, as
+// matched up by key.
+func onlyNewDiffs[D any](diffs, previous []D, key func(D) string) []D {
+
+	seen := make(map[string]bool, len(previous))
+	for _, d := range previous {
+		seen[key(d)] = true
+	}
+
+	fresh := make([]D, 0, len(diffs))
+	for _, d := range diffs {
+		if !seen[key(d)] {
+			fresh = append(fresh, d)
+		}
+	}
+
+	return fresh
+
+}
+
+// onlyNew drops the entries that are named in previous.
+func onlyNew(entries []diffEntry, previous []string) []diffEntry {
+
+	seen := make(map[string]bool, len(previous))
+	for _, name := range previous {
+		seen[name] = true
+	}
+
+	fresh := make([]diffEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !seen[entry.Path] {
+			fresh = append(fresh, entry)
+		}
+	}
+
+	return fresh
+
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/davexre/sitescan/syncedmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoadState(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "sitescan-state")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	all := []*site{
+		{URL: "/a", Map: syncedmap.New(map[string]fileEntry{"file1": {URL: "file1", Size: 1}})},
+		{URL: "http://someurl.com/", Map: syncedmap.New(map[string]fileEntry{"dir1/": {URL: "dir1/", Size: -1}})},
+	}
+
+	st, err := loadState(path, all)
+	assert.Nil(t, err)
+	assert.Nil(t, st)
+
+	assert.Nil(t, saveState(path, all, []checksumDiff{{Name: "file1", Site1: "abc", Site2: "def"}}))
+
+	st, err = loadState(path, all)
+	if assert.Nil(t, err) && assert.NotNil(t, st) {
+		assert.Equal(t, []string{"/a", "http://someurl.com/"}, st.URLs)
+		assert.Equal(t, []map[string]fileEntry{all[0].Map.Snapshot(), all[1].Map.Snapshot()}, st.Maps)
+		assert.Equal(t, []checksumDiff{{Name: "file1", Site1: "abc", Site2: "def"}}, st.ChecksumDiffs)
+	}
+
+	_, err = loadState(path, []*site{all[0], {URL: "http://otherurl.com/"}})
+	assert.NotNil(t, err)
+	_, err = loadState(path, append(all, &site{URL: "/c"}))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("not json"), 0644))
+	_, err = loadState(path, all)
+	assert.NotNil(t, err)
+}
+
+func TestNewSince(t *testing.T) {
+
+	previous := &scanState{
+		URLs: []string{"/a", "/b"},
+		Maps: []map[string]fileEntry{
+			{"file1": {URL: "file1"}, "old1": {URL: "old1"}},
+			{"file1": {URL: "file1"}, "old2": {URL: "old2"}},
+		},
+	}
+
+	result := comparison{
+		Site1Only: []diffEntry{{Path: "old1"}, {Path: "new1"}},
+		Site2Only: []diffEntry{{Path: "old2"}, {Path: "new2"}},
+	}
+
+	result = newSince(result, previous)
+	assert.Equal(t, []diffEntry{{Path: "new1"}}, result.Site1Only)
+	assert.Equal(t, []diffEntry{{Path: "new2"}}, result.Site2Only)
+
+	// a size, time or checksum difference that's already known is left out,
+	// unless it's changed since
+	then, now := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 2, 0, 0, 0, time.UTC)
+	previous = &scanState{
+		URLs: []string{"/a", "/b"},
+		Maps: []map[string]fileEntry{
+			{"known": {URL: "known", Size: 1, ModTime: then}, "changed": {URL: "changed", Size: 1, ModTime: then}},
+			{"known": {URL: "known", Size: 2, ModTime: now}, "changed": {URL: "changed", Size: 2, ModTime: now}},
+		},
+		ChecksumDiffs: []checksumDiff{{Name: "known", Site1: "abc", Site2: "def"}, {Name: "changed", Site1: "abc", Site2: "def"}},
+	}
+	later := now.Add(24 * time.Hour)
+	result = comparison{
+		SizeDiffs: []sizeDiff{
+			{Name: "changed", Site1: fileEntry{URL: "changed", Size: 1}, Site2: fileEntry{URL: "changed", Size: 3}},
+			{Name: "known", Site1: fileEntry{URL: "known", Size: 1}, Site2: fileEntry{URL: "known", Size: 2}},
+			{Name: "new", Site1: fileEntry{URL: "new", Size: 1}, Site2: fileEntry{URL: "new", Size: 2}},
+		},
+		NewerDiffs: []newerDiff{
+			{Name: "changed", Site1: fileEntry{URL: "changed", ModTime: then}, Site2: fileEntry{URL: "changed", ModTime: later}},
+			{Name: "known", Site1: fileEntry{URL: "known", ModTime: then.Local()}, Site2: fileEntry{URL: "known", ModTime: now.Local()}},
+		},
+		ChecksumDiffs: []checksumDiff{{Name: "changed", Site1: "abc", Site2: "123"}, {Name: "known", Site1: "abc", Site2: "def"}},
+	}
+
+	result = newSince(result, previous)
+	if assert.Len(t, result.SizeDiffs, 2) {
+		assert.Equal(t, "changed", result.SizeDiffs[0].Name)
+		assert.Equal(t, "new", result.SizeDiffs[1].Name)
+	}
+	assert.Equal(t, []newerDiff{{Name: "changed", Site1: fileEntry{URL: "changed", ModTime: then}, Site2: fileEntry{URL: "changed", ModTime: later}}}, result.NewerDiffs)
+	assert.Equal(t, []checksumDiff{{Name: "changed", Site1: "abc", Site2: "123"}}, result.ChecksumDiffs)
+
+	// with three sites, file2 was already missing from B
+	previous = &scanState{
+		URLs: []string{"/a", "/b", "/c"},
+		Maps: []map[string]fileEntry{
+			{"file1": {URL: "file1", Size: 1}, "file2": {URL: "file2", Size: 2}},
+			{"file1": {URL: "file1", Size: 1}},
+			{"file1": {URL: "file1", Size: 1}, "file2": {URL: "file2", Size: 2}},
+		},
+	}
+
+	multi := newSinceMulti(compareAllSites(testSites()), previous)
+	assert.Equal(t, []diffEntry{{Path: "file3", fileEntry: fileEntry{URL: "file3", Size: 3}}}, multi.Missing[0].Missing)
+	assert.Equal(t, []diffEntry{}, multi.Missing[1].Missing)
+	assert.Equal(t, []diffEntry{}, multi.Missing[2].Missing)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/davexre/sitescan/webhandler"
+)
+
+// scanStats is what --stats reports once the scan's done: how hard the web
+// servers were worked, and where the time went, for deciding whether to turn
+// --walk-concurrency up, or --exclude part of a site.
+type scanStats struct {
+	Requests         int64       `json:"requests"`
+	HTMLBytes        int64       `json:"html_bytes"`
+	AverageLatencyMS float64     `json:"average_latency_ms"`
+	MaxDepth         int         `json:"max_depth"`
+	Sites            []siteStats `json:"sites"`
+}
+
+// siteStats is the part of scanStats for one site.
+type siteStats struct {
+	Name      string  `json:"name"`
+	Seconds   float64 `json:"seconds"`
+	Files     int     `json:"files"`
+	Dirs      int     `json:"dirs"`
+	MaxDepth  int     `json:"max_depth"`
+	HTMLBytes int64   `json:"html_bytes"`
+}
+
+// gatherStats collects the scanStats for sites from their walkCounters, and the
+// request counts webhandler has kept.
+func gatherStats(sites []*site) scanStats {
+
+	stats := scanStats{Requests: webhandler.Requests.Load()}
+	if stats.Requests > 0 {
+		latency := time.Duration(webhandler.RequestTime.Load() / stats.Requests)
+		stats.AverageLatencyMS = float64(latency.Microseconds()) / 1000
+	}
+
+	for _, s := range sites {
+		ss := siteStats{
+			Name:      s.Name,
+			Seconds:   s.Elapsed.Round(time.Millisecond).Seconds(),
+			Files:     s.Counter.Files.Read(),
+			Dirs:      s.Counter.Dirs.Read(),
+			MaxDepth:  int(s.Counter.Depth.Load()),
+			HTMLBytes: s.Counter.HTMLBytes.Load(),
+		}
+		stats.HTMLBytes += ss.HTMLBytes
+		stats.MaxDepth = max(stats.MaxDepth, ss.MaxDepth)
+		stats.Sites = append(stats.Sites, ss)
+	}
+
+	return stats
+
+}
+
+// renderStats writes the --stats report - as JSON, with --output-json, or as
+// text otherwise.
+func renderStats(w io.Writer, stats scanStats) error {
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	writeBanner(w, "Scan statistics:")
+	fmt.Fprintf(w, "%-30s %d\n", "HTTP requests:", stats.Requests)
+	fmt.Fprintf(w, "%-30s %s\n", "HTML fetched:", humanSize(stats.HTMLBytes))
+	fmt.Fprintf(w, "%-30s %.1fms\n", "Average request latency:", stats.AverageLatencyMS)
+	fmt.Fprintf(w, "%-30s %d\n", "Deepest directory:", stats.MaxDepth)
+	for _, s := range stats.Sites {
+		fmt.Fprintf(w, "%-30s %s (%d files, %d dirs, %d deep, %s of HTML)\n", s.Name+":",
+			time.Duration(s.Seconds*float64(time.Second)).Round(time.Millisecond), s.Files, s.Dirs, s.MaxDepth, humanSize(s.HTMLBytes))
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	return nil
+
+}
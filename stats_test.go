@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatherStats(t *testing.T) {
+
+	savedRequests, savedTime := webhandler.Requests.Load(), webhandler.RequestTime.Load()
+	defer func() {
+		webhandler.Requests.Store(savedRequests)
+		webhandler.RequestTime.Store(savedTime)
+	}()
+	webhandler.Requests.Store(4)
+	webhandler.RequestTime.Store(int64(100 * time.Millisecond))
+
+	site1 := &site{Name: "Site 1", Elapsed: 2500 * time.Millisecond}
+	site1.Counter.Files.Set(10)
+	site1.Counter.Dirs.Set(3)
+	site1.Counter.Reached(2)
+	site1.Counter.Reached(4)
+	site1.Counter.Reached(3)
+	site1.Counter.HTMLBytes.Store(2048)
+	site2 := &site{Name: "Site 2", Elapsed: time.Second}
+	site2.Counter.Files.Set(12)
+	site2.Counter.Reached(1)
+
+	stats := gatherStats([]*site{site1, site2})
+
+	assert.Equal(t, scanStats{
+		Requests:         4,
+		HTMLBytes:        2048,
+		AverageLatencyMS: 25,
+		MaxDepth:         4,
+		Sites: []siteStats{
+			{Name: "Site 1", Seconds: 2.5, Files: 10, Dirs: 3, MaxDepth: 4, HTMLBytes: 2048},
+			{Name: "Site 2", Seconds: 1, Files: 12, MaxDepth: 1},
+		},
+	}, stats)
+
+}
+
+func TestRenderStats(t *testing.T) {
+
+	saved := outputFormat
+	defer func() { outputFormat = saved }()
+
+	stats := scanStats{
+		Requests:         4,
+		HTMLBytes:        2048,
+		AverageLatencyMS: 25,
+		MaxDepth:         4,
+		Sites:            []siteStats{{Name: "Site 1", Seconds: 2.5, Files: 10, Dirs: 3, MaxDepth: 4, HTMLBytes: 2048}},
+	}
+
+	var out bytes.Buffer
+	outputFormat = "text"
+	assert.Nil(t, renderStats(&out, stats))
+	assert.Equal(t, "Scan statistics:\n================\n\n"+
+		"HTTP requests:                 4\n"+
+		"HTML fetched:                  2.0 KiB\n"+
+		"Average request latency:       25.0ms\n"+
+		"Deepest directory:             4\n"+
+		"Site 1:                        2.5s (10 files, 3 dirs, 4 deep, 2.0 KiB of HTML)\n\n\n", out.String())
+
+	out.Reset()
+	outputFormat = "json"
+	assert.Nil(t, renderStats(&out, stats))
+	var decoded scanStats
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, stats, decoded)
+
+}
@@ -0,0 +1,68 @@
+// Package syncedmap provides a map that's safe for concurrent use. Like
+// synceddata.Counter, it's protected by a Mutex (a sync.RWMutex, here, since
+// it's read far more than it's written), and the zero value is ready to go.
+package syncedmap
+
+import "sync"
+
+// Map is a map from K to V that any number of goroutines can use at once.
+type Map[K comparable, V any] struct {
+	m    sync.RWMutex
+	data map[K]V
+}
+
+// New returns a Map holding a copy of the entries in from, which can be nil.
+func New[K comparable, V any](from map[K]V) *Map[K, V] {
+	s := &Map[K, V]{data: make(map[K]V, len(from))}
+	for k, v := range from {
+		s.data[k] = v
+	}
+	return s
+}
+
+// Set stores v under k, replacing whatever was there.
+func (s *Map[K, V]) Set(k K, v V) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.data == nil {
+		s.data = make(map[K]V)
+	}
+	s.data[k] = v
+}
+
+// Get returns what's stored under k, and whether there was anything.
+func (s *Map[K, V]) Get(k K) (V, bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	v, ok := s.data[k]
+	return v, ok
+}
+
+// Keys returns all of the keys, in no particular order.
+func (s *Map[K, V]) Keys() []K {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	keys := make([]K, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Len returns how many entries there are.
+func (s *Map[K, V]) Len() int {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return len(s.data)
+}
+
+// Snapshot returns a copy of everything in the Map, as a plain map.
+func (s *Map[K, V]) Snapshot() map[K]V {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	snapshot := make(map[K]V, len(s.data))
+	for k, v := range s.data {
+		snapshot[k] = v
+	}
+	return snapshot
+}
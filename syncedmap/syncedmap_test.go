@@ -0,0 +1,69 @@
+package syncedmap
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMap(t *testing.T) {
+	assert := assert.New(t)
+
+	var m Map[string, int]
+
+	_, ok := m.Get("missing")
+	assert.False(ok)
+	assert.Equal(0, m.Len())
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("a", 3)
+
+	v, ok := m.Get("a")
+	assert.True(ok)
+	assert.Equal(3, v)
+	assert.Equal(2, m.Len())
+
+	keys := m.Keys()
+	sort.Strings(keys)
+	assert.Equal([]string{"a", "b"}, keys)
+
+	snapshot := m.Snapshot()
+	snapshot["c"] = 4
+	assert.Equal(2, m.Len())
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	from := map[string]int{"a": 1}
+	m := New(from)
+	from["b"] = 2
+
+	assert.Equal(map[string]int{"a": 1}, m.Snapshot())
+	assert.Equal(0, New[string, int](nil).Len())
+}
+
+func TestConcurrent(t *testing.T) {
+
+	var m Map[string, int]
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Set(fmt.Sprintf("%d-%d", i, j), j)
+				m.Get(fmt.Sprintf("%d-%d", i, j/2))
+				m.Len()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1000, m.Len())
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/davexre/sitescan/scanner"
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/davexre/synceddata"
+)
+
+var (
+	// upFinished counts the files the upload workers have sent, and upErrors
+	// collects the ones they couldn't, for the summary at the end. They're
+	// kept apart from the download ones, since --upload and --download never
+	// run together.
+	upFinished synceddata.Counter
+	upErrors   errorList
+)
+
+// uploadable asks Site 2 whether it'll take uploads at remotepath, with an
+// OPTIONS request. It has to list PUT among the methods it allows. If it
+// speaks WebDAV, too, dav is true, and directories can be made on it with
+// MKCOL - otherwise, it's up to the server to make them as files are put in
+// them.
+func uploadable(ctx context.Context, remotepath string) (dav bool, err error) {
+
+	response, err := webhandler.OptionsHandler(ctx, remotepath, site2Opts)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return false, fmt.Errorf("OPTIONS request returned %d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	}
+
+	put := false
+	for _, allow := range response.Header.Values("Allow") {
+		for _, method := range strings.Split(allow, ",") {
+			if strings.EqualFold(strings.TrimSpace(method), "PUT") {
+				put = true
+			}
+		}
+	}
+	if !put {
+		return false, fmt.Errorf("the server doesn't allow PUT requests there")
+	}
+
+	return response.Header.Get("DAV") != "", nil
+
+}
+
+// uploadManager uploads everything in filelist from localpath to remotepath
+// (the files only at Site 1), until it's done or ctx is cancelled. Directories
+// are made first, one at a time, parents before children - if the server
+// speaks WebDAV - and then the files are handed to a pool of throttle
+// uploadWorkers.
+func uploadManager(ctx context.Context, localpath, remotepath string, filelist []string, dav bool) downloadTotals {
+
+	if !strings.HasSuffix(localpath, "/") {
+		localpath = localpath + "/"
+	}
+	if !strings.HasSuffix(remotepath, "/") {
+		remotepath = remotepath + "/"
+	}
+
+	var files []string
+	for _, file := range filelist {
+
+		switch {
+		case strings.HasSuffix(file, dlSuffix):
+			// a partial download isn't ours to share
+			continue
+		case !strings.HasSuffix(file, "/"):
+			files = append(files, file)
+			continue
+		case !dav || ctx.Err() != nil:
+			continue
+		}
+
+		if dryrun {
+			dlLog.Printf("would make directory: %s", file)
+			continue
+		}
+
+		if err := makeCollection(ctx, remotepath+scanner.EscapePath(file)); err != nil {
+			dlLog.Printf("error making directory: %s: %v", file, err)
+			upErrors.Add(remotepath+file, err)
+			continue
+		}
+		dlLog.Printf("made directory: %s", file)
+
+	}
+
+	fileschan := make(chan string, len(files))
+	for _, file := range files {
+		fileschan <- file
+	}
+	close(fileschan)
+
+	var uwg sync.WaitGroup
+	for i := 1; i <= throttle; i++ {
+		uwg.Add(1)
+		go func(id int) {
+			defer uwg.Done()
+			uploadWorker(ctx, id, localpath, remotepath, fileschan)
+		}(i)
+	}
+	uwg.Wait()
+
+	finished, failed := upFinished.Read(), len(upErrors.List())
+	if ctx.Err() != nil {
+		slog.Warn("uploads interrupted", "finished", finished, "failed", failed, "unfinished", len(files)-finished-failed)
+	}
+	if logFile != "" {
+		slog.Info("the uploads are logged in full in the log file", "path", logFile)
+	}
+
+	up := downloadTotals{Queued: len(files), Succeeded: finished, Failed: failed}
+	up.Skipped = up.Queued - up.Succeeded - up.Failed
+
+	return up
+
+}
+
+// makeCollection makes a directory on a WebDAV server. One that's already
+// there is fine.
+func makeCollection(ctx context.Context, location string) error {
+
+	response, err := webhandler.MkcolHandler(ctx, location, site2Opts)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode == http.StatusMethodNotAllowed || (response.StatusCode >= 200 && response.StatusCode <= 299) {
+		return nil
+	}
+
+	return fmt.Errorf("server returned %d %s", response.StatusCode, http.StatusText(response.StatusCode))
+
+}
+
+// uploadWorker is a download worker in reverse - it takes files from fileschan,
+// and PUTs each one from localpath to remotepath, within the
+// --bandwidth-limit, until fileschan is empty or ctx is cancelled.
+func uploadWorker(ctx context.Context, id int, localpath, remotepath string, fileschan <-chan string) {
+
+	failures := 0
+
+	for file := range fileschan {
+
+		if ctx.Err() != nil {
+			workerLog(id, "interrupted")
+			break
+		}
+
+		if dryrun {
+			workerLog(id, "would upload: %s", file)
+			continue
+		}
+
+		workerLog(id, "uploading: %s", file)
+
+		if err := uploadFile(ctx, localpath+file, remotepath+scanner.EscapePath(file)); err != nil {
+			workerLog(id, "error uploading: %s: %v", file, err)
+			failures++
+			upErrors.Add(remotepath+file, err)
+			continue
+		}
+
+		workerLog(id, "finished: %s", file)
+		upFinished.Incr()
+
+	}
+
+	workerLog(id, "done, %d failed", failures)
+
+}
+
+// uploadFile PUTs the local file source to location, on Site 2.
+func uploadFile(ctx context.Context, source, location string) error {
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	body := func() (io.ReadCloser, error) {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{scanner.LimitReader(ctx, f, bandwidthLimiter), f}, nil
+	}
+
+	response, err := webhandler.PutHandler(ctx, location, body, info.Size(), site2Opts)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("server returned %d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	}
+
+	return nil
+
+}
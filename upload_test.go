@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/davexre/sitescan/webhandler"
+	"github.com/davexre/synceddata"
+	"github.com/stretchr/testify/assert"
+)
+
+// davServer is just enough of a WebDAV server to upload to - it keeps what's
+// PUT to it, and the directories made on it, in memory.
+type davServer struct {
+	m     sync.Mutex
+	files map[string]string
+	dirs  []string
+	allow string
+}
+
+func (s *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", s.allow)
+		w.Header().Set("DAV", "1")
+	case "MKCOL":
+		s.dirs = append(s.dirs, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	case "PUT":
+		if r.URL.Path == "/readonly" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.files[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+}
+
+func TestUploadable(t *testing.T) {
+
+	saved := webhandler.Client
+	defer func() { webhandler.Client = saved }()
+	webhandler.Client = webhandler.NewClient()
+
+	dav := &davServer{files: map[string]string{}, allow: "OPTIONS, GET, HEAD, PROPFIND, MKCOL, PUT"}
+	ts := httptest.NewServer(dav)
+	defer ts.Close()
+
+	isDAV, err := uploadable(context.Background(), ts.URL+"/")
+	assert.Nil(t, err)
+	assert.True(t, isDAV)
+
+	dav.allow = "OPTIONS, GET, HEAD"
+	_, err = uploadable(context.Background(), ts.URL+"/")
+	assert.NotNil(t, err)
+}
+
+func TestUploadManager(t *testing.T) {
+
+	srcdir, _ := ioutil.TempDir("", "sitescan-src")
+	defer os.RemoveAll(srcdir)
+	assert.Nil(t, os.MkdirAll(filepath.Join(srcdir, "dir 1"), 0755))
+	for _, file := range []string{"dir 1/file1", "file2", "readonly", "file3" + dlSuffix} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(srcdir, file), []byte("contents of "+file), 0644))
+	}
+
+	dav := &davServer{files: map[string]string{}, allow: "PUT"}
+	ts := httptest.NewServer(dav)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	saved, savedLog, savedThrottle := webhandler.Client, dlLog, throttle
+	defer func() {
+		webhandler.Client, dlLog, throttle, dryrun = saved, savedLog, savedThrottle, false
+		upErrors, upFinished = errorList{}, synceddata.Counter{}
+	}()
+	webhandler.Client = webhandler.NewClient()
+	dlLog = log.New(&out, "", 0)
+	throttle = 2
+
+	filelist := []string{"dir 1/", "dir 1/file1", "file2", "file3" + dlSuffix, "readonly"}
+
+	// a dry run doesn't send anything
+	dryrun = true
+	up := uploadManager(context.Background(), srcdir, ts.URL, filelist, true)
+	assert.Equal(t, downloadTotals{Queued: 3, Skipped: 3}, up)
+	assert.Len(t, dav.files, 0)
+	assert.Len(t, dav.dirs, 0)
+	assert.Contains(t, out.String(), "would upload: file2")
+
+	dryrun = false
+	up = uploadManager(context.Background(), srcdir, ts.URL, filelist, true)
+	assert.Equal(t, downloadTotals{Queued: 3, Succeeded: 2, Failed: 1}, up)
+	assert.Equal(t, []string{"/dir 1/"}, dav.dirs)
+	assert.Equal(t, map[string]string{"/dir 1/file1": "contents of dir 1/file1", "/file2": "contents of file2"}, dav.files)
+	if errs := upErrors.List(); assert.Len(t, errs, 1) {
+		assert.Equal(t, ts.URL+"/readonly", errs[0].URL)
+	}
+}
@@ -0,0 +1,111 @@
+package webhandler
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewJar returns an empty cookie jar, for a site's Options. Cookies that the
+// site sets are kept in it, and sent back with every later request to the site,
+// so a login session carries through a whole walk.
+func NewJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil) // only fails on a bad PublicSuffixList, and there isn't one
+	return jar
+}
+
+// SeedCookies puts cookies given on the command line into jar, for siteURL.
+// Each one can be a single "name=value", or several at once, separated by
+// semicolons, like a Cookie header copied from a browser.
+func SeedCookies(jar http.CookieJar, siteURL string, raw []string) error {
+
+	u, err := url.Parse(siteURL)
+	if err != nil {
+		return err
+	}
+
+	var cookies []*http.Cookie
+	for _, line := range raw {
+		parsed, err := http.ParseCookie(strings.TrimSpace(strings.TrimPrefix(line, "Cookie:")))
+		if err != nil {
+			return fmt.Errorf("ERROR: cookie must look like \"name=value\": <%s>", line)
+		}
+		cookies = append(cookies, parsed...)
+	}
+
+	jar.SetCookies(u, cookies)
+	return nil
+
+}
+
+// LoadCookieFile puts the cookies from a Netscape format cookies file - the
+// cookies.txt that curl, wget and browser extensions write - into jar. Each
+// line has seven tab separated fields:
+//
+//	domain  include-subdomains  path  secure  expires  name  value
+//
+// Lines starting with "#" are comments, apart from "#HttpOnly_", which marks an
+// HttpOnly cookie. Cookies that have already expired are skipped.
+func LoadCookieFile(jar http.CookieJar, path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(text, "#HttpOnly_")
+		text = strings.TrimPrefix(text, "#HttpOnly_")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("ERROR: line %d of %s isn't a Netscape format cookie", line, path)
+		}
+
+		domain, subdomains, cookiePath, secure := fields[0], fields[1] == "TRUE", fields[2], fields[3] == "TRUE"
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("ERROR: line %d of %s has a bad expiry time: <%s>", line, path, fields[4])
+		}
+
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     cookiePath,
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if subdomains {
+			cookie.Domain = domain
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: cookiePath}, []*http.Cookie{cookie})
+
+	}
+
+	return scanner.Err()
+
+}
@@ -0,0 +1,69 @@
+package webhandler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeedCookies(t *testing.T) {
+	assert := assert.New(t)
+
+	jar := NewJar()
+	assert.Nil(SeedCookies(jar, "http://testurl.com/files/", []string{"session=abc", "Cookie: a=1; b=2"}))
+
+	u, _ := url.Parse("http://testurl.com/files/dir/")
+	names := map[string]string{}
+	for _, c := range jar.Cookies(u) {
+		names[c.Name] = c.Value
+	}
+	assert.Equal(map[string]string{"session": "abc", "a": "1", "b": "2"}, names)
+
+	// they're only for the site they were given for
+	other, _ := url.Parse("http://otherurl.com/")
+	assert.Len(jar.Cookies(other), 0)
+
+	assert.NotNil(SeedCookies(jar, "http://testurl.com/", []string{"not a cookie"}))
+}
+
+func TestLoadCookieFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "webhandler-cookies")
+	defer os.RemoveAll(dir)
+
+	future := time.Now().Add(time.Hour).Unix()
+	path := filepath.Join(dir, "cookies.txt")
+	contents := "# Netscape HTTP Cookie File\n\n" +
+		fmt.Sprintf(".testurl.com\tTRUE\t/\tFALSE\t%d\tsession\tabc\n", future) +
+		fmt.Sprintf("#HttpOnly_testurl.com\tFALSE\t/private\tTRUE\t%d\ttoken\txyz\n", future) +
+		"testurl.com\tFALSE\t/\tFALSE\t1\texpired\told\n" +
+		"testurl.com\tFALSE\t/\tFALSE\t0\tbrowsersession\tyes\n"
+	assert.Nil(ioutil.WriteFile(path, []byte(contents), 0600))
+
+	jar := NewJar()
+	assert.Nil(LoadCookieFile(jar, path))
+
+	cookies := func(u string) map[string]string {
+		parsed, _ := url.Parse(u)
+		found := map[string]string{}
+		for _, c := range jar.Cookies(parsed) {
+			found[c.Name] = c.Value
+		}
+		return found
+	}
+
+	assert.Equal(map[string]string{"session": "abc", "browsersession": "yes"}, cookies("http://testurl.com/"))
+	assert.Equal(map[string]string{"session": "abc"}, cookies("http://www.testurl.com/"))
+	assert.Equal(map[string]string{"session": "abc", "browsersession": "yes", "token": "xyz"}, cookies("https://testurl.com/private/file"))
+
+	assert.Nil(ioutil.WriteFile(path, []byte("testurl.com\tFALSE\t/\n"), 0600))
+	assert.NotNil(LoadCookieFile(jar, path))
+	assert.NotNil(LoadCookieFile(jar, filepath.Join(dir, "missing.txt")))
+}
@@ -0,0 +1,214 @@
+package webhandler
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// newCnonce makes the client nonce for a digest response. It's swapped out by
+// the tests, so the responses come out the same every time.
+var newCnonce = func() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// digestChallenge is what a server asks for in a "WWW-Authenticate: Digest"
+// header (RFC 7616).
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+// findDigestChallenge looks through a 401 response's WWW-Authenticate headers
+// for a digest challenge we know how to answer.
+func findDigestChallenge(header http.Header) (digestChallenge, bool) {
+
+	for _, value := range header.Values("WWW-Authenticate") {
+
+		if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+			continue
+		}
+
+		params := parseAuthParams(value[7:])
+		c := digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: strings.ToUpper(params["algorithm"]),
+		}
+		if c.algorithm == "" {
+			c.algorithm = "MD5"
+		}
+		if digestHash(c.algorithm) == nil || c.nonce == "" {
+			continue
+		}
+
+		// we only do "auth" - "auth-int" would mean hashing the body, too
+		for _, qop := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if params["qop"] != "" && c.qop == "" {
+			continue
+		}
+
+		return c, true
+
+	}
+
+	return digestChallenge{}, false
+
+}
+
+// parseAuthParams splits the comma separated name=value pairs of an
+// authentication header, where the values can be quoted strings with commas of
+// their own.
+func parseAuthParams(s string) map[string]string {
+
+	params := make(map[string]string)
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, ", ") {
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.ReplaceAll(s[1:min(end, len(s))], "\\", "")
+			s = s[min(end+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[name] = value
+
+	}
+
+	return params
+
+}
+
+// digestHash returns the hash for a digest algorithm, or nil if we don't know it.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// authorization works out the Authorization header that answers c, for a
+// request with the given method and URI. nc counts the requests made with c's
+// nonce - each of ours answers a fresh challenge, so it's always 1.
+func (c digestChallenge) authorization(method, uri, user, pass, cnonce string) string {
+
+	newHash := digestHash(c.algorithm)
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	nc := "00000001"
+	ha1 := h(user + ":" + c.realm + ":" + pass)
+	if strings.HasSuffix(c.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if c.qop == "" {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + nc + ":" + cnonce + ":" + c.qop + ":" + ha2)
+	}
+
+	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s"`,
+		user, c.realm, c.nonce, uri, c.algorithm, response)
+	if c.opaque != "" {
+		auth += fmt.Sprintf(`, opaque="%s"`, c.opaque)
+	}
+	if c.qop != "" {
+		auth += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, c.qop, nc, cnonce)
+	}
+
+	return auth
+
+}
+
+// answerDigest deals with the response to a request made to a site that uses
+// digest authentication. If it's a 401 with a digest challenge, the request is
+// sent again through do, with the answer to the challenge, and that response is
+// returned instead. Anything else is returned as it is.
+func answerDigest(do func(*http.Request) (*http.Response, error), req *http.Request, res *http.Response, opts Options) (*http.Response, error) {
+
+	if res == nil || res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+
+	challenge, ok := findDigestChallenge(res.Header)
+	if !ok {
+		return res, nil
+	}
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", challenge.authorization(req.Method, req.URL.RequestURI(), opts.User, opts.Pass, newCnonce()))
+
+	return do(retry)
+
+}
+
+// digestTransport answers digest challenges for every request that goes through
+// it, for clients (like the download client) that don't go through doRequest.
+type digestTransport struct {
+	base http.RoundTripper
+	opts Options
+}
+
+func (t digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	return answerDigest(t.base.RoundTrip, req, res, t.opts)
+
+}
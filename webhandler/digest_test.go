@@ -0,0 +1,125 @@
+package webhandler
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davexre/sitescan/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// the example exchange from RFC 2617, section 3.5
+const rfcChallenge = `Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`
+
+func TestFindDigestChallenge(t *testing.T) {
+	assert := assert.New(t)
+
+	header := http.Header{}
+	header.Add("WWW-Authenticate", `Basic realm="somewhere"`)
+	header.Add("WWW-Authenticate", rfcChallenge)
+
+	c, ok := findDigestChallenge(header)
+	assert.True(ok)
+	assert.Equal(digestChallenge{
+		realm:     "testrealm@host.com",
+		nonce:     "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		opaque:    "5ccc069c403ebaf9f0171e9517f40e41",
+		algorithm: "MD5",
+		qop:       "auth",
+	}, c)
+
+	var tests = []struct {
+		input string
+		ok    bool
+	}{
+		{`Basic realm="somewhere"`, false},
+		{`Digest realm="a, b", nonce="xyz", algorithm=SHA-256`, true},
+		{`Digest realm="a", nonce="xyz", algorithm=SHA-512-256`, false},
+		{`Digest realm="a", nonce="xyz", qop="auth-int"`, false},
+		{`Digest realm="a"`, false},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("WWW-Authenticate", test.input)
+		_, ok := findDigestChallenge(header)
+		assert.Equal(test.ok, ok, test.input)
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	assert := assert.New(t)
+
+	header := http.Header{}
+	header.Set("WWW-Authenticate", rfcChallenge)
+	c, _ := findDigestChallenge(header)
+
+	auth := c.authorization("GET", "/dir/index.html", "Mufasa", "Circle Of Life", "0a4f113b")
+	assert.Contains(auth, `response="6629fae49393a05397450978507c4ef1"`)
+	assert.Contains(auth, `opaque="5ccc069c403ebaf9f0171e9517f40e41"`)
+	assert.Contains(auth, `qop=auth, nc=00000001, cnonce="0a4f113b"`)
+}
+
+func TestDigestAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	saved := newCnonce
+	defer func() { newCnonce = saved }()
+	newCnonce = func() string { return "0a4f113b" }
+
+	// no credentials until the server asks for them, and then the answer
+	var sent []string
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.Header.Get("Authorization"))
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "Digest ") {
+			header := http.Header{}
+			header.Set("WWW-Authenticate", rfcChallenge)
+			return &http.Response{StatusCode: 401, Header: header, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	res, err := HTTPHandlerWithOptions(context.Background(), "http://testurl.com/dir/index.html",
+		Options{User: "Mufasa", Pass: "Circle Of Life", Auth: "digest"})
+	assert.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	if assert.Len(sent, 2) {
+		assert.Equal("", sent[0])
+		assert.Contains(sent[1], `response="6629fae49393a05397450978507c4ef1"`)
+	}
+
+	// with basic auth, a digest challenge is just a 401
+	sent = nil
+	res, err = HTTPHandlerWithOptions(context.Background(), "http://testurl.com/dir/index.html",
+		Options{User: "Mufasa", Pass: "Circle Of Life"})
+	assert.Nil(err)
+	assert.Equal(401, res.StatusCode)
+	if assert.Len(sent, 1) {
+		assert.True(strings.HasPrefix(sent[0], "Basic "))
+	}
+}
+
+func TestNewSiteClientDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Digest ") {
+			w.Header().Set("WWW-Authenticate", `Digest realm="files", nonce="abc123", algorithm=SHA-256, qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := NewSiteClient(Options{User: "someguy", Pass: "spaceballs12345", Auth: "digest"})
+	res, err := client.Get(ts.URL + "/file")
+	assert.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	res.Body.Close()
+}
@@ -0,0 +1,174 @@
+package webhandler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert makes a self-signed certificate, and writes it and its key to
+// PEM files in dir.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sitescan test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err = x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile, cert
+
+}
+
+func TestNewSiteTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "sitescan-tls")
+	defer os.RemoveAll(dir)
+	certFile, keyFile, cert := writeTestCert(t, dir)
+
+	transport, err := NewSiteTransport(certFile, keyFile)
+	assert.Nil(err)
+	if assert.NotNil(transport.TLSClientConfig) && assert.Len(transport.TLSClientConfig.Certificates, 1) {
+		assert.Equal(cert.Raw, transport.TLSClientConfig.Certificates[0].Certificate[0])
+	}
+
+	// the shared Transport doesn't get it
+	assert.NotSame(Transport, transport)
+	if Transport.TLSClientConfig != nil {
+		assert.Len(Transport.TLSClientConfig.Certificates, 0)
+	}
+
+	_, err = NewSiteTransport(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.NotNil(err)
+	_, err = NewSiteTransport(certFile, certFile)
+	assert.NotNil(err)
+}
+
+func TestClientCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "sitescan-tls")
+	defer os.RemoveAll(dir)
+	certFile, keyFile, cert := writeTestCert(t, dir)
+
+	// a server that only lets in our certificate
+	var presented [][]byte
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range r.TLS.PeerCertificates {
+			presented = append(presented, c.Raw)
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	transport, err := NewSiteTransport(certFile, keyFile)
+	assert.Nil(err)
+	transport.TLSClientConfig.RootCAs = ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	res, err := HTTPHandlerWithOptions(context.Background(), ts.URL, Options{Transport: transport})
+	if assert.Nil(err) {
+		res.Body.Close()
+		assert.Equal(200, res.StatusCode)
+		assert.Equal([][]byte{cert.Raw}, presented)
+	}
+}
+
+func TestSetInsecure(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	saved := Client
+	defer func() {
+		Client = saved
+		SetInsecure(false)
+	}()
+	Client = NewClient()
+
+	// the test server's certificate is self-signed
+	_, err := HTTPHandlerWithOptions(context.Background(), ts.URL, Options{})
+	assert.NotNil(err)
+
+	SetInsecure(true)
+	res, err := HTTPHandlerWithOptions(context.Background(), ts.URL, Options{})
+	if assert.Nil(err) {
+		res.Body.Close()
+	}
+
+	// and the same goes for the downloads
+	res, err = NewSiteClient(Options{}).Get(ts.URL)
+	if assert.Nil(err) {
+		res.Body.Close()
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	dir, _ := ioutil.TempDir("", "sitescan-tls")
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+
+	pool, err := loadCertPool(caFile)
+	if assert.Nil(err) {
+		_, err = ts.Certificate().Verify(x509.VerifyOptions{Roots: pool, DNSName: "example.com"})
+		assert.Nil(err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	assert.Nil(ioutil.WriteFile(garbage, []byte("not a certificate"), 0644))
+	_, err = loadCertPool(garbage)
+	assert.NotNil(err)
+
+	_, err = loadCertPool(filepath.Join(dir, "missing.pem"))
+	assert.NotNil(err)
+
+	// with the CA trusted, requests to the server go through
+	saved, savedRoots := Client, Transport.TLSClientConfig.RootCAs
+	defer func() { Client, Transport.TLSClientConfig.RootCAs = saved, savedRoots }()
+	Client = NewClient()
+
+	assert.Nil(SetCACert(caFile))
+	res, err := HTTPHandlerWithOptions(context.Background(), ts.URL, Options{})
+	if assert.Nil(err) {
+		res.Body.Close()
+	}
+}
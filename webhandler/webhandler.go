@@ -1,9 +1,23 @@
 package webhandler
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"golang.org/x/time/rate"
 )
 
 // HTTPClient interface will allow for substituting a mock HTTP client for testing purposes
@@ -13,25 +27,184 @@ type HTTPClient interface {
 
 var (
 	// Client defines which HTTP interface will be used by HTTPHandler. By default, this is
-	// set to http.Client{} as part of the init function, but it can be changed to provide
-	// a mock HTTP response for testing purposes
+	// set to an http.Client using Transport as part of the init function, but it can be
+	// changed to provide a mock HTTP response for testing purposes
 	Client HTTPClient
+
+	// Transport is shared by Client and by every client built with NewClient (such as
+	// the one used for downloads), so settings like the proxy apply everywhere.
+	Transport *http.Transport
+
+	// UserAgent is sent as the User-Agent header with every request. Some servers
+	// block or rate limit Go's default "Go-http-client/1.1", so sitescan sets
+	// its own.
+	UserAgent string
+
+	// Retries is how many more times a request is tried after a network error, a 5xx,
+	// or a 429 (Too Many Requests). Other responses, including 4xx errors, are never
+	// retried since trying again won't change anything.
+	Retries int
+
+	// RetryDelay is how long to wait before the first retry. Each retry after that
+	// waits twice as long as the last, unless the server asks for longer with a
+	// Retry-After header.
+	RetryDelay = time.Second
+
+	// sleep is swapped out by the tests, so they don't have to actually wait
+	sleep = sleepContext
+
+	// FollowRedirects says whether redirects are followed at all. When they
+	// aren't, the redirect itself is returned, for the caller to deal with.
+	FollowRedirects = true
+
+	// MaxRedirects is how many redirects in a row are followed before giving up
+	// on a request. Zero means no limit.
+	MaxRedirects = 10
+
+	// ErrOffsiteRedirect is what a request made through Client fails with when
+	// it's redirected to a different host - a walk that follows it has most
+	// likely wandered off the site it was meant to be walking.
+	ErrOffsiteRedirect = errors.New("redirected to a different host")
+
+	// Requests counts every request sent (each retry counts again), and
+	// RequestTime adds up how long they took to get a response, in
+	// nanoseconds, for sitescan's --stats.
+	Requests    atomic.Int64
+	RequestTime atomic.Int64
 )
 
 func init() {
-	Client = &http.Client{}
+	Transport = http.DefaultTransport.(*http.Transport).Clone()
+	Transport.Proxy = http.ProxyFromEnvironment
+	client := NewClient()
+	client.CheckRedirect = stayOnHost
+	Client = client
+}
+
+// NewClient returns an http.Client that uses the shared Transport. Anything in
+// sitescan that needs its own *http.Client should get it here. It follows
+// redirects as FollowRedirects and MaxRedirects say, wherever they go - unlike
+// Client, it's happy to be sent to another host, as a mirror's downloads often
+// are.
+func NewClient() *http.Client {
+	return &http.Client{Transport: Transport, CheckRedirect: limitRedirects}
+}
+
+// limitRedirects is the CheckRedirect for clients from NewClient.
+func limitRedirects(req *http.Request, via []*http.Request) error {
+
+	switch {
+	case !FollowRedirects:
+		return http.ErrUseLastResponse
+	case MaxRedirects > 0 && len(via) >= MaxRedirects:
+		return fmt.Errorf("stopped after %d redirects", MaxRedirects)
+	}
+
+	return nil
+
+}
+
+// stayOnHost is the CheckRedirect for Client, which also refuses to be sent to
+// a different host from the one the request was made to.
+func stayOnHost(req *http.Request, via []*http.Request) error {
+
+	if err := limitRedirects(req, via); err != nil {
+		return err
+	}
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return fmt.Errorf("%w: <%s>", ErrOffsiteRedirect, req.URL)
+	}
+
+	return nil
+
+}
+
+// NewSiteClient returns a client from NewClient that does for a site what
+// doRequest does - uses opts.Transport if the site has its own, keeps its
+// cookies in opts.Jar, and answers digest challenges if opts.Auth asks for
+// them. Requests made with it still need opts.Apply.
+func NewSiteClient(opts Options) *http.Client {
+
+	client := NewClient()
+	client.Jar = opts.Jar
+	if opts.Transport != nil {
+		client.Transport = opts.Transport
+	}
+	if opts.Auth == "digest" {
+		client.Transport = digestTransport{base: client.Transport, opts: opts}
+	}
+
+	return client
+
+}
+
+// SetTimeout limits how long any single request made through Client may take,
+// including reading the response body. Zero means no limit. Clients built with
+// NewClient aren't affected, since a download can legitimately take a long time.
+func SetTimeout(timeout time.Duration) {
+	if c, ok := Client.(*http.Client); ok {
+		c.Timeout = timeout
+	}
+}
+
+// IsTimeout reports whether err came from a request that timed out.
+func IsTimeout(err error) bool {
+	if e, ok := err.(net.Error); ok {
+		return e.Timeout()
+	}
+	return false
+}
+
+// SetProxy sends all requests through the given proxy URL. An empty proxy falls
+// back to the standard HTTP_PROXY / HTTPS_PROXY / NO_PROXY environment variables.
+func SetProxy(proxy string) error {
+
+	if proxy == "" {
+		Transport.Proxy = http.ProxyFromEnvironment
+		return nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	switch {
+	case err != nil:
+		return err
+	case proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5":
+		return fmt.Errorf("ERROR: proxy URL must begin with http, https, or socks5: <%s>", proxy)
+	case proxyURL.Host == "":
+		return fmt.Errorf("ERROR: proxy URL has no host specified: <%s>", proxy)
+	}
+
+	Transport.Proxy = http.ProxyURL(proxyURL)
+	return nil
+
+}
+
+// SetInsecure turns off (or back on) verification of the TLS certificates of the
+// servers that requests go to, for test servers with self-signed certificates.
+// It changes the shared Transport, so it applies to the downloads as well - but
+// it needs to be called before NewSiteTransport, for sites with their own.
+func SetInsecure(insecure bool) {
+	if Transport.TLSClientConfig == nil {
+		Transport.TLSClientConfig = &tls.Config{}
+	}
+	Transport.TLSClientConfig.InsecureSkipVerify = insecure
 }
 
 // ValidateURL will double check a given string to ensure that it's actually a valid
-// URL and will highlight any problems with it.
-func ValidateURL(u string) error {
+// URL and will highlight any problems with it. Its scheme has to be one of
+// schemes - or, if none are given, http or https.
+func ValidateURL(u string, schemes ...string) error {
+
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
 
 	url, err := url.Parse(u)
 	switch {
 	case err != nil:
 		return err
-	case url.Scheme == "" || (url.Scheme != "http" && url.Scheme != "https"):
-		return fmt.Errorf("ERROR: URL must begin with http or https: <%s>", u)
+	case url.Scheme == "" || !slices.Contains(schemes, strings.ToLower(url.Scheme)):
+		return fmt.Errorf("ERROR: URL must begin with %s: <%s>", orList(schemes), u)
 	case url.Host == "":
 		return fmt.Errorf("ERROR: URL has no host specified: <%s>", u)
 	default:
@@ -40,17 +213,334 @@ func ValidateURL(u string) error {
 
 }
 
+// orList joins words into a list for a message, like "ftp, http or https".
+func orList(words []string) string {
+
+	if len(words) == 1 {
+		return words[0]
+	}
+
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+
+}
+
+// NormalizeURL checks u just as ValidateURL does, and returns it ready to have
+// paths added to the end of it: a URL for a directory gets the trailing "/" it
+// needs, if it didn't come with one, so that "http://host/path" and a link to
+// "subdir/" make "http://host/path/subdir/" rather than
+// "http://host/pathsubdir/". A URL whose last element looks like a file name
+// (like "index.html") is left alone, and so is any query string.
+func NormalizeURL(u string) (string, error) {
+
+	if err := ValidateURL(u); err != nil {
+		return "", err
+	}
+
+	parsed, _ := url.Parse(u)
+	if strings.HasSuffix(parsed.Path, "/") || looksLikeFile(path.Base(parsed.Path)) {
+		return u, nil
+	}
+
+	parsed.Path += "/"
+	if parsed.RawPath != "" {
+		parsed.RawPath += "/"
+	}
+
+	return parsed.String(), nil
+
+}
+
+// looksLikeFile guesses whether the last element of a URL's path names a file
+// rather than a directory - whether it has a short extension, with at least
+// one letter in it (so "v1.2" still counts as a directory, but "index.html"
+// doesn't).
+func looksLikeFile(name string) bool {
+
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	if ext == "" || len(ext) > 5 || ext == name[1:] {
+		return false
+	}
+
+	letter := false
+	for _, r := range ext {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case !unicode.IsDigit(r):
+			return false
+		}
+	}
+
+	return letter
+
+}
+
+// Options describes how to authenticate with a site, and any extra headers that
+// need to go along with every request to it. If Token is set, it's sent as a
+// bearer token in place of basic authentication. Headers are applied last, so
+// they can override anything else (including Authorization). Jar, if set, holds
+// the site's cookies - see NewJar. Options are passed around by value, but every
+// copy shares the one Jar.
+//
+// Auth picks how User and Pass are sent: "basic" (or "") sends them with every
+// request, while "digest" sends nothing until the server answers with a 401 and
+// a digest challenge, then tries again with the answer to it.
+//
+// Transport, if set, is the site's own - see NewSiteTransport - and its
+// requests go through that instead of Client.
+//
+// Limiter, if set, is waited on before every request to the site, retries
+// included - see NewRateLimiter. Like Jar, every copy shares the one Limiter.
+type Options struct {
+	User      string
+	Pass      string
+	Token     string
+	Auth      string
+	Headers   map[string]string
+	Jar       http.CookieJar
+	Transport *http.Transport
+	Limiter   Limiter
+}
+
+// Limiter holds requests back to a steady rate. Wait blocks until the next
+// request can go, or ctx is cancelled.
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NewRateLimiter makes a Limiter that lets perSecond requests through each
+// second (which can be less than one), spread out evenly rather than in bursts.
+func NewRateLimiter(perSecond float64) Limiter {
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// Apply sets the User-Agent, and the authentication and extra headers described by
+// o, on req.
+func (o Options) Apply(req *http.Request) {
+
+	if UserAgent != "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	if (o.User != "" || o.Pass != "") && o.Auth != "digest" {
+		req.SetBasicAuth(o.User, o.Pass)
+	}
+	if o.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.Token)
+	}
+	for name, value := range o.Headers {
+		req.Header.Set(name, value)
+	}
+
+}
+
+// client returns the client that requests to the site should go through -
+// Client, unless the site has a Transport of its own, in which case it's a
+// client for that, with the same timeout as Client.
+func (o Options) client() HTTPClient {
+
+	if o.Transport == nil {
+		return Client
+	}
+
+	c := &http.Client{Transport: o.Transport, CheckRedirect: stayOnHost}
+	if shared, ok := Client.(*http.Client); ok {
+		c.Timeout = shared.Timeout
+	}
+
+	return c
+
+}
+
 // HTTPHandler retrieves a given URL, and can support basic HTTP authentication. Keeping this
 // code separated in a handler function allows for easier testing of several other pieces.
 func HTTPHandler(url, user, pass string) (*http.Response, error) {
+	return HTTPHandlerWithOptions(context.Background(), url, Options{User: user, Pass: pass})
+}
+
+// HTTPHandlerWithOptions works like HTTPHandler, but takes the full set of Options,
+// so that bearer tokens and extra headers can be sent as well. Cancelling ctx
+// abandons the request, along with any retries still to come.
+func HTTPHandlerWithOptions(ctx context.Context, url string, opts Options) (*http.Response, error) {
+	return doRequest(ctx, "GET", url, opts)
+}
+
+// HeadHandler works like HTTPHandlerWithOptions, but only asks for the headers of the
+// given URL, not the body. It's used to find out about a file without downloading it.
+func HeadHandler(ctx context.Context, url string, opts Options) (*http.Response, error) {
+	return doRequest(ctx, "HEAD", url, opts)
+}
+
+// PropfindHandler asks a WebDAV server about the given URL and everything
+// directly inside it - a PROPFIND with "Depth: 1". With no request body, the
+// server sends back all its usual properties, which covers what we need.
+func PropfindHandler(ctx context.Context, url string, opts Options) (*http.Response, error) {
+
+	headers := map[string]string{}
+	for name, value := range opts.Headers {
+		headers[name] = value
+	}
+	headers["Depth"] = "1"
+	opts.Headers = headers
+
+	return doRequest(ctx, "PROPFIND", url, opts)
+
+}
+
+// PutHandler uploads to the given URL with a PUT. body is called for the
+// contents to send - again for each retry, so that every attempt sends the
+// whole thing - and size is how long they are.
+func PutHandler(ctx context.Context, url string, body func() (io.ReadCloser, error), size int64, opts Options) (*http.Response, error) {
+	return doRequestBody(ctx, "PUT", url, body, size, opts)
+}
+
+// PostHandler sends body to the given URL with a POST, the same way as
+// PutHandler.
+func PostHandler(ctx context.Context, url string, body func() (io.ReadCloser, error), size int64, opts Options) (*http.Response, error) {
+	return doRequestBody(ctx, "POST", url, body, size, opts)
+}
+
+// MkcolHandler makes a collection (a directory) at the given URL on a WebDAV
+// server.
+func MkcolHandler(ctx context.Context, url string, opts Options) (*http.Response, error) {
+	return doRequest(ctx, "MKCOL", url, opts)
+}
+
+// OptionsHandler asks the server what it supports at the given URL - the
+// methods it allows, and whether it speaks WebDAV.
+func OptionsHandler(ctx context.Context, url string, opts Options) (*http.Response, error) {
+	return doRequest(ctx, "OPTIONS", url, opts)
+}
+
+// doRequest sends the request, retrying with exponential backoff as described for
+// Retries and RetryDelay. Once the retries run out, whatever the last attempt got
+// is returned - the error for a network failure, or the response for a bad status
+// code, so the caller can decide what to do with it. Once ctx is cancelled, there
+// are no more retries - just ctx's error.
+func doRequest(ctx context.Context, method, url string, opts Options) (*http.Response, error) {
+	return doRequestBody(ctx, method, url, nil, 0, opts)
+}
+
+// doRequestBody works like doRequest, for requests that send a body: body is
+// called for a fresh copy of it for each attempt, and size is its length.
+func doRequestBody(ctx context.Context, method, url string, body func() (io.ReadCloser, error), size int64, opts Options) (*http.Response, error) {
+
+	delay := RetryDelay
+	client := opts.client()
+
+	for attempt := 0; ; attempt++ {
+
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			if req.Body, err = body(); err != nil {
+				return nil, err
+			}
+			req.GetBody = body
+			req.ContentLength = size
+		}
+		opts.Apply(req)
+		if opts.Limiter != nil {
+			if err := opts.Limiter.Wait(ctx); err != nil {
+				if req.Body != nil {
+					req.Body.Close()
+				}
+				return nil, err
+			}
+		}
+		if opts.Jar != nil {
+			for _, cookie := range opts.Jar.Cookies(req.URL) {
+				req.AddCookie(cookie)
+			}
+		}
+
+		sent := time.Now()
+		res, err := client.Do(req)
+		if err == nil && opts.Auth == "digest" {
+			res, err = answerDigest(client.Do, req, res, opts)
+		}
+		Requests.Add(1)
+		RequestTime.Add(int64(time.Since(sent)))
+		if opts.Jar != nil && res != nil {
+			opts.Jar.SetCookies(req.URL, res.Cookies())
+		}
+		if attempt >= Retries || ctx.Err() != nil || !shouldRetry(res, err) {
+			return res, err
+		}
+
+		wait := delay
+		if res != nil {
+			if after, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && time.Duration(after)*time.Second > wait {
+				wait = time.Duration(after) * time.Second
+			}
+			res.Body.Close()
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		delay *= 2
+	}
+
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// sleepContext waits for d to pass, unless ctx is cancelled first, in which
+// case it returns ctx's error straight away.
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+}
+
+// shouldRetry decides whether a request is worth another try - only network
+// errors, server errors, and being told to slow down are.
+func shouldRetry(res *http.Response, err error) bool {
 	if err != nil {
-		return nil, err
+		return networkError(err)
 	}
-	if user != "" || pass != "" {
-		req.SetBasicAuth(user, pass)
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+}
+
+// networkError reports whether err is the network letting a request down - a
+// dropped connection, a timeout, a name that didn't resolve - rather than
+// something that would only happen again, like a redirect we won't follow, a
+// certificate we don't trust, or a URL that doesn't parse.
+func networkError(err error) bool {
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
 	}
 
-	return (Client.Do(req))
+	// a *url.Error counts as a net.Error whatever it wraps, so it's what's
+	// inside that decides
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+
+}
+
+// ParseHeader splits a header given on the command line as "Name: value" into
+// its name and value.
+func ParseHeader(header string) (name, value string, err error) {
+
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("ERROR: header must look like \"Name: value\": <%s>", header)
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+
 }
@@ -2,11 +2,22 @@ package webhandler
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/davexre/sitescan/mocks"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -18,24 +29,65 @@ func TestValidateURL(t *testing.T) {
 
 	var tests = []struct {
 		input       string
+		schemes     []string
 		expectError bool
 	}{
-		{"", true},
-		{"someurl.com", true},
-		{"file://somefile", true},
-		{"http:some/file/path", true},
-		{"\"http://www.somehost.com/path\"", true},
-		{"http://www.somehost.com/path", false},
-		{"https://www.somehost.com/path", false},
+		{"", nil, true},
+		{"someurl.com", nil, true},
+		{"file://somefile", nil, true},
+		{"http:some/file/path", nil, true},
+		{"\"http://www.somehost.com/path\"", nil, true},
+		{"http://www.somehost.com/path", nil, false},
+		{"https://www.somehost.com/path", nil, false},
+		{"ftp://ftp.somehost.com/pub/", nil, true},
+		{"ftp://ftp.somehost.com/pub/", []string{"ftp", "s3"}, false},
+		{"FTP://ftp.somehost.com/pub/", []string{"ftp", "s3"}, false},
+		{"s3://somebucket/prefix/", []string{"ftp", "s3"}, false},
+		{"s3:///prefix/", []string{"ftp", "s3"}, true},
+		{"dav://www.somehost.com/share/", []string{"dav", "davs"}, false},
+		{"http://www.somehost.com/path", []string{"ftp", "s3"}, true},
 	}
 	for _, test := range tests {
 		if test.expectError {
-			assert.NotNil(ValidateURL(test.input))
+			assert.NotNil(ValidateURL(test.input, test.schemes...), test.input)
 		} else {
-			assert.Nil(ValidateURL(test.input))
+			assert.Nil(ValidateURL(test.input, test.schemes...), test.input)
 		}
 	}
 
+	assert.EqualError(ValidateURL("gopher://somehost/", "ftp", "http", "https"),
+		"ERROR: URL must begin with ftp, http or https: <gopher://somehost/>")
+
+}
+
+func TestNormalizeURL(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"http://www.somehost.com", "http://www.somehost.com/"},
+		{"http://www.somehost.com/", "http://www.somehost.com/"},
+		{"http://www.somehost.com/path", "http://www.somehost.com/path/"},
+		{"http://www.somehost.com/path/", "http://www.somehost.com/path/"},
+		{"https://www.somehost.com/path/v1.2", "https://www.somehost.com/path/v1.2/"},
+		{"http://www.somehost.com/path/index.html", "http://www.somehost.com/path/index.html"},
+		{"http://www.somehost.com/path/file.tar.gz", "http://www.somehost.com/path/file.tar.gz"},
+		{"http://www.somehost.com/.config", "http://www.somehost.com/.config/"},
+		{"http://www.somehost.com/path?sort=name", "http://www.somehost.com/path/?sort=name"},
+		{"http://www.somehost.com/path/?sort=name", "http://www.somehost.com/path/?sort=name"},
+		{"http://www.somehost.com/list.php?dir=a", "http://www.somehost.com/list.php?dir=a"},
+		{"http://www.somehost.com/my%20files", "http://www.somehost.com/my%20files/"},
+	}
+	for _, test := range tests {
+		normalized, err := NormalizeURL(test.input)
+		assert.Nil(err, test.input)
+		assert.Equal(test.expected, normalized, test.input)
+	}
+
+	_, err := NormalizeURL("someurl.com")
+	assert.NotNil(err)
 }
 
 func TestHTTPHandler(t *testing.T) {
@@ -69,3 +121,482 @@ func TestHTTPHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestHeadHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var method string
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+
+	res, err := HeadHandler(context.Background(), "http://testurl.com/file", Options{})
+	assert.Nil(err)
+	assert.NotNil(res)
+	assert.Equal("HEAD", method)
+}
+
+func TestPropfindHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var method, depth, custom string
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		method = req.Method
+		depth = req.Header.Get("Depth")
+		custom = req.Header.Get("X-Custom")
+		return &http.Response{
+			StatusCode: 207,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+
+	opts := Options{Headers: map[string]string{"Depth": "infinity", "X-Custom": "yes"}}
+	res, err := PropfindHandler(context.Background(), "http://testurl.com/dir/", opts)
+	assert.Nil(err)
+	assert.NotNil(res)
+	assert.Equal("PROPFIND", method)
+	assert.Equal("1", depth)
+	assert.Equal("yes", custom)
+
+	// the caller's headers are left alone
+	assert.Equal("infinity", opts.Headers["Depth"])
+}
+
+func TestPutHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	savedRetries, savedSleep := Retries, sleep
+	defer func() { Retries, sleep = savedRetries, savedSleep }()
+	Retries = 1
+	sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	// the first attempt fails, so the body has to be sent again in full
+	var methods, bodies []string
+	var lengths []int64
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		methods = append(methods, req.Method)
+		lengths = append(lengths, req.ContentLength)
+		if req.Body != nil {
+			body, _ := ioutil.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+		}
+		status := 201
+		if len(lengths) == 1 {
+			status = 503
+		}
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	body := func() (io.ReadCloser, error) { return ioutil.NopCloser(strings.NewReader("file contents")), nil }
+	res, err := PutHandler(context.Background(), "http://testurl.com/dir/file", body, 13, Options{})
+	assert.Nil(err)
+	assert.Equal(201, res.StatusCode)
+	assert.Equal([]string{"PUT", "PUT"}, methods)
+	assert.Equal([]string{"file contents", "file contents"}, bodies)
+	assert.Equal([]int64{13, 13}, lengths)
+
+	methods = nil
+	_, err = MkcolHandler(context.Background(), "http://testurl.com/dir/", Options{})
+	assert.Nil(err)
+	_, err = OptionsHandler(context.Background(), "http://testurl.com/dir/", Options{})
+	assert.Nil(err)
+	_, err = PostHandler(context.Background(), "http://testurl.com/hook", body, 13, Options{})
+	assert.Nil(err)
+	assert.Equal([]string{"MKCOL", "OPTIONS", "POST"}, methods)
+}
+
+// fakeLimiter counts how often it's waited on, instead of waiting.
+type fakeLimiter struct {
+	waits int
+	err   error
+}
+
+func (l *fakeLimiter) Wait(ctx context.Context) error {
+	l.waits++
+	return l.err
+}
+
+func TestLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	savedRetries, savedSleep := Retries, sleep
+	defer func() { Retries, sleep = savedRetries, savedSleep }()
+	Retries = 2
+	sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	// a server that's had enough, and then comes round
+	var requests int
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		requests++
+		status := 200
+		if requests == 1 {
+			status = http.StatusTooManyRequests
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	// every attempt waits its turn, the retry included
+	limiter := &fakeLimiter{}
+	res, err := HTTPHandlerWithOptions(context.Background(), "http://testurl.com/", Options{Limiter: limiter})
+	assert.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	assert.Equal(2, limiter.waits)
+	assert.Equal(2, requests)
+
+	// and if waiting fails, nothing's sent
+	requests = 0
+	limiter = &fakeLimiter{err: context.Canceled}
+	_, err = HTTPHandlerWithOptions(context.Background(), "http://testurl.com/", Options{Limiter: limiter})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(0, requests)
+
+	// a real one spaces requests out
+	real := NewRateLimiter(20)
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Nil(real.Wait(context.Background()))
+	}
+	assert.GreaterOrEqual(time.Since(started), 90*time.Millisecond)
+}
+
+func TestHTTPHandlerWithOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	var header http.Header
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		header = req.Header
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+
+	var tests = []struct {
+		opts          Options
+		authorization string
+		custom        string
+	}{
+		{Options{}, "", ""},
+		{Options{User: "user", Pass: "pass"}, "Basic dXNlcjpwYXNz", ""},
+		{Options{User: "user", Pass: "pass", Token: "abc123"}, "Bearer abc123", ""},
+		{Options{Token: "abc123", Headers: map[string]string{"X-Api-Key": "key"}}, "Bearer abc123", "key"},
+		{Options{Token: "abc123", Headers: map[string]string{"Authorization": "Custom xyz"}}, "Custom xyz", ""},
+	}
+	for _, test := range tests {
+		_, err := HTTPHandlerWithOptions(context.Background(), "http://testurl.com/", test.opts)
+		assert.Nil(err)
+		assert.Equal(test.authorization, header.Get("Authorization"), test.opts)
+		assert.Equal(test.custom, header.Get("X-Api-Key"), test.opts)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	name, value, err := ParseHeader("X-Api-Key: abc: 123")
+	assert.Nil(err)
+	assert.Equal("X-Api-Key", name)
+	assert.Equal("abc: 123", value)
+
+	_, _, err = ParseHeader("no colon here")
+	assert.NotNil(err)
+
+	_, _, err = ParseHeader(": value")
+	assert.NotNil(err)
+}
+
+func TestUserAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	var header http.Header
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		header = req.Header
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+
+	saved := UserAgent
+	defer func() { UserAgent = saved }()
+	UserAgent = "sitescan/test"
+
+	_, err := HTTPHandler("http://testurl.com/", "", "")
+	assert.Nil(err)
+	assert.Equal("sitescan/test", header.Get("User-Agent"))
+
+	_, err = HTTPHandlerWithOptions(context.Background(), "http://testurl.com/", Options{Headers: map[string]string{"User-Agent": "override"}})
+	assert.Nil(err)
+	assert.Equal("override", header.Get("User-Agent"))
+}
+
+func TestSetProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	defer SetProxy("")
+
+	req, _ := http.NewRequest("GET", "http://testurl.com/", nil)
+
+	assert.Nil(SetProxy("http://proxy.example.com:3128"))
+	proxyURL, err := Transport.Proxy(req)
+	assert.Nil(err)
+	assert.Equal("http://proxy.example.com:3128", proxyURL.String())
+
+	assert.NotNil(SetProxy("proxy.example.com:3128"))
+	assert.NotNil(SetProxy("ftp://proxy.example.com"))
+	assert.NotNil(SetProxy("http://"))
+
+	assert.Nil(SetProxy(""))
+	assert.NotNil(Transport.Proxy)
+
+	// downloads share the same transport, and so the same proxy
+	assert.Equal(Transport, NewClient().Transport)
+}
+
+func TestSetTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	saved := Client
+	defer func() { Client = saved }()
+	Client = NewClient()
+
+	SetTimeout(50 * time.Millisecond)
+	assert.Equal(50*time.Millisecond, Client.(*http.Client).Timeout)
+
+	res, err := HTTPHandler(server.URL, "", "")
+	assert.Nil(res)
+	assert.NotNil(err)
+	assert.True(IsTimeout(err))
+
+	// downloads get their own client, which shouldn't time out
+	assert.Zero(NewClient().Timeout)
+}
+
+func TestRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	savedRetries, savedDelay, savedSleep := Retries, RetryDelay, sleep
+	defer func() { Retries, RetryDelay, sleep = savedRetries, savedDelay, savedSleep }()
+
+	var waits []time.Duration
+	sleep = func(ctx context.Context, d time.Duration) error { waits = append(waits, d); return nil }
+	Retries = 3
+	RetryDelay = 100 * time.Millisecond
+
+	respond := func(status int) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+
+	// fails twice, then succeeds
+	attempts := 0
+	mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+		attempts++
+		switch attempts {
+		case 1:
+			return nil, &url.Error{Op: "Get", URL: "http://testurl.com/", Err: syscall.ECONNRESET}
+		case 2:
+			return respond(http.StatusServiceUnavailable)
+		default:
+			return respond(http.StatusOK)
+		}
+	}
+
+	requests := Requests.Load()
+	res, err := HTTPHandler("http://testurl.com/", "", "")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(3, attempts)
+	assert.Equal(requests+3, Requests.Load(), "each attempt counts as a request")
+	assert.Equal([]time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, waits)
+
+	// 4xx errors aren't retried
+	attempts, waits = 0, nil
+	mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+		attempts++
+		return respond(http.StatusNotFound)
+	}
+	res, err = HTTPHandler("http://testurl.com/", "", "")
+	assert.Nil(err)
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal(1, attempts)
+
+	// once the retries run out, the last error comes back
+	attempts, waits = 0, nil
+	mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+		attempts++
+		return nil, syscall.EHOSTUNREACH
+	}
+	res, err = HTTPHandler("http://testurl.com/", "", "")
+	assert.Nil(res)
+	assert.EqualError(err, "no route to host")
+	assert.Equal(4, attempts)
+	assert.Len(waits, 3)
+
+	// Retry-After wins when it asks for longer than our own backoff
+	attempts, waits = 0, nil
+	mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			res, _ := respond(http.StatusTooManyRequests)
+			res.Header.Set("Retry-After", "5")
+			return res, nil
+		}
+		return respond(http.StatusOK)
+	}
+	_, err = HTTPHandler("http://testurl.com/", "", "")
+	assert.Nil(err)
+	assert.Equal([]time.Duration{5 * time.Second}, waits)
+
+	// errors that would only happen again aren't retried
+	for _, failure := range []error{
+		fmt.Errorf("%w: <%s>", ErrOffsiteRedirect, "http://elsewhere.com/"),
+		fmt.Errorf("stopped after %d redirects", 10),
+		x509.UnknownAuthorityError{},
+		errors.New("invalid URL escape \"%zz\""),
+	} {
+		attempts, waits = 0, nil
+		mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+			attempts++
+			return nil, &url.Error{Op: "Get", URL: "http://testurl.com/", Err: failure}
+		}
+		_, err = HTTPHandler("http://testurl.com/", "", "")
+		assert.True(errors.Is(err, failure), "%v", failure)
+		assert.Equal(1, attempts, "%v", failure)
+		assert.Len(waits, 0)
+	}
+
+	// but a timeout or a dropped connection is
+	for _, failure := range []error{io.ErrUnexpectedEOF, &net.DNSError{Err: "no such host", IsTimeout: true}} {
+		attempts, waits = 0, nil
+		mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+			attempts++
+			return nil, &url.Error{Op: "Get", URL: "http://testurl.com/", Err: failure}
+		}
+		_, err = HTTPHandler("http://testurl.com/", "", "")
+		assert.Equal(4, attempts, "%v", failure)
+	}
+}
+
+func TestRetriesCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	savedRetries, savedDelay := Retries, RetryDelay
+	defer func() { Retries, RetryDelay = savedRetries, savedDelay }()
+	Retries = 3
+	RetryDelay = time.Hour
+
+	// cancelled while the first attempt fails, so there's no retry
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	mocks.GetDoFunc = func(*http.Request) (*http.Response, error) {
+		attempts++
+		cancel()
+		return nil, errors.New("connection reset by peer")
+	}
+
+	res, err := HTTPHandlerWithOptions(ctx, "http://testurl.com/", Options{})
+	assert.Nil(res)
+	assert.EqualError(err, "connection reset by peer")
+	assert.Equal(1, attempts)
+
+	// and once it's cancelled, nothing else is sent
+	attempts = 0
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, req.Context().Err()
+	}
+	_, err = HTTPHandlerWithOptions(ctx, "http://testurl.com/", Options{})
+	assert.True(errors.Is(err, context.Canceled))
+	assert.Equal(1, attempts)
+}
+
+func TestCookies(t *testing.T) {
+	assert := assert.New(t)
+
+	jar := NewJar()
+	assert.Nil(SeedCookies(jar, "http://testurl.com/", []string{"seeded=1"}))
+
+	// the first response starts a session, which goes back with the next request
+	var sent []string
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.Header.Get("Cookie"))
+		header := http.Header{}
+		if len(sent) == 1 {
+			header.Set("Set-Cookie", "session=abc; Path=/")
+		}
+		return &http.Response{StatusCode: 200, Header: header, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	opts := Options{Jar: jar}
+	_, err := HTTPHandlerWithOptions(context.Background(), "http://testurl.com/", opts)
+	assert.Nil(err)
+	_, err = HTTPHandlerWithOptions(context.Background(), "http://testurl.com/dir/", opts)
+	assert.Nil(err)
+
+	assert.Equal([]string{"seeded=1", "seeded=1; session=abc"}, sent)
+}
+
+func TestRedirects(t *testing.T) {
+	assert := assert.New(t)
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	// /loop redirects to itself forever
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dir":
+			http.Redirect(w, r, "/dir/", http.StatusMovedPermanently)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/away", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	saved, savedFollow, savedMax := Client, FollowRedirects, MaxRedirects
+	defer func() { Client, FollowRedirects, MaxRedirects = saved, savedFollow, savedMax }()
+	Client = &http.Client{Transport: Transport, CheckRedirect: stayOnHost}
+
+	res, err := HTTPHandlerWithOptions(context.Background(), ts.URL+"/dir", Options{})
+	if assert.Nil(err) {
+		res.Body.Close()
+		assert.Equal(ts.URL+"/dir/", res.Request.URL.String())
+	}
+
+	_, err = HTTPHandlerWithOptions(context.Background(), ts.URL+"/away", Options{})
+	assert.True(errors.Is(err, ErrOffsiteRedirect))
+
+	MaxRedirects = 3
+	_, err = HTTPHandlerWithOptions(context.Background(), ts.URL+"/loop", Options{})
+	assert.NotNil(err)
+
+	// downloads can go to another host
+	res, err = NewClient().Get(ts.URL + "/away")
+	if assert.Nil(err) {
+		res.Body.Close()
+		assert.Equal(other.URL+"/away", res.Request.URL.String())
+	}
+
+	// and without redirects, the redirect itself comes back
+	FollowRedirects = false
+	res, err = HTTPHandlerWithOptions(context.Background(), ts.URL+"/dir", Options{})
+	if assert.Nil(err) {
+		res.Body.Close()
+		assert.Equal(http.StatusMovedPermanently, res.StatusCode)
+	}
+}
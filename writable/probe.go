@@ -0,0 +1,34 @@
+package writable
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	// ErrNotDirectory is what IsWritable's error wraps when the path isn't a
+	// directory at all
+	ErrNotDirectory = errors.New("not a directory")
+
+	// ErrNoPermission is what IsWritable's error wraps when we're not allowed
+	// to write to the directory
+	ErrNoPermission = errors.New("no permission to write to it")
+)
+
+// probe finds out whether files can be made in the directory at path the only
+// sure way there is - by making one (and then removing it again). Permission
+// bits don't tell the whole story: ACLs, read-only mounts, and network
+// filesystems all have their say, too.
+func probe(path string) error {
+
+	f, err := os.CreateTemp(path, ".sitescan-probe-*")
+	if err != nil {
+		return err
+	}
+
+	name := f.Name()
+	f.Close()
+
+	return os.Remove(name)
+
+}
@@ -1,4 +1,6 @@
+//go:build !windows
 // +build !windows
+
 package writable
 
 import (
@@ -7,49 +9,72 @@ import (
 	"syscall"
 )
 
-func IsWritable(path string, debug bool) (isWritable bool, err error) {
-	isWritable = false
+// IsWritable reports whether files can be created in the directory at path. If
+// they can't, the error says why.
+func IsWritable(path string) (bool, error) {
+
 	info, err := os.Stat(path)
 	if err != nil {
-		if debug {
-			fmt.Println("Path doesn't exist")
-		}
-		return
+		return false, fmt.Errorf("unable to stat %s: %w", path, err)
 	}
-
-	err = nil
 	if !info.IsDir() {
-		if debug {
-			fmt.Println("Path isn't a directory")
-		}
-		return
+		return false, fmt.Errorf("%s: %w", path, ErrNotDirectory)
 	}
 
-	// Check if the user bit is enabled in file permission
-	if info.Mode().Perm()&(1<<(uint(7))) == 0 {
-		if debug {
-			fmt.Println("Write permission bit is not set on this file for user")
-		}
-		return
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false, fmt.Errorf("unable to stat %s: %w", path, err)
 	}
+	groups, _ := os.Getgroups()
+	bitsAllow := permitted(info.Mode().Perm(), stat.Uid, stat.Gid, os.Geteuid(), os.Getegid(), groups)
 
-	var stat syscall.Stat_t
-	if err = syscall.Stat(path, &stat); err != nil {
-		if debug {
-			fmt.Println("Unable to get stat")
+	// the permission bits aren't the last word - ACLs can allow more, and a
+	// read-only mount or NFS's uid mapping less - so try it and see
+	if err := probe(path); err != nil {
+		if !bitsAllow {
+			return false, fmt.Errorf("%s: %w - it's mode %v, owned by uid %d and gid %d, and we're uid %d",
+				path, ErrNoPermission, info.Mode().Perm(), stat.Uid, stat.Gid, os.Geteuid())
 		}
-		return
+		return false, fmt.Errorf("unable to create a file in %s: %w", path, err)
 	}
 
-	err = nil
-	if uint32(os.Geteuid()) != stat.Uid {
-		isWritable = false
-		if debug {
-			fmt.Println("User doesn't have permission to write to this directory")
+	return true, nil
+
+}
+
+// permitted works out whether a directory's permission bits let a user write
+// to it: the owner bit if they own it, the group bit if they're in its group,
+// and the other bit if neither. root can write anywhere.
+func permitted(perm os.FileMode, uid, gid uint32, euid, egid int, groups []int) bool {
+
+	switch {
+	case euid == 0:
+		return true
+	case uint32(euid) == uid:
+		return perm&0200 != 0
+	case uint32(egid) == gid || inGroup(gid, groups):
+		return perm&0020 != 0
+	default:
+		return perm&0002 != 0
+	}
+
+}
+
+// inGroup reports whether gid is one of groups.
+func inGroup(gid uint32, groups []int) bool {
+	for _, g := range groups {
+		if uint32(g) == gid {
+			return true
 		}
-		return
 	}
+	return false
+}
 
-	isWritable = true
-	return
+// Umask returns the process's file mode creation mask. There's no way to read
+// it without setting it, so it's briefly set to 0 and then put back - call it
+// before starting anything that might create files at the same time.
+func Umask() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
 }
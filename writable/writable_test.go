@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package writable
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermitted(t *testing.T) {
+
+	var tests = []struct {
+		name     string
+		perm     os.FileMode
+		euid     int
+		egid     int
+		groups   []int
+		expected bool
+	}{
+		{"owner, writable", 0755, 1000, 1000, nil, true},
+		{"owner, read only", 0555, 1000, 1000, nil, false},
+		{"owner, only group writable", 0575, 1000, 100, nil, false},
+		{"group, writable", 0775, 2000, 100, nil, true},
+		{"group, read only", 0755, 2000, 100, nil, false},
+		{"supplementary group, writable", 0775, 2000, 2000, []int{50, 100}, true},
+		{"other, writable", 0777, 2000, 2000, []int{50}, true},
+		{"other, only group writable", 0775, 2000, 2000, []int{50}, false},
+		{"root", 0555, 0, 0, nil, true},
+	}
+
+	// the directory is owned by uid 1000, in group 100
+	for _, test := range tests {
+		assert.Equal(t, test.expected, permitted(test.perm, 1000, 100, test.euid, test.egid, test.groups), test.name)
+	}
+}
+
+func TestIsWritable(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "sitescan-writable")
+	defer os.RemoveAll(dir)
+
+	writable, err := IsWritable(dir)
+	assert.Nil(t, err)
+	assert.True(t, writable)
+
+	// the probe doesn't leave anything behind
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 0)
+
+	file := filepath.Join(dir, "file")
+	assert.Nil(t, os.WriteFile(file, nil, 0644))
+	writable, err = IsWritable(file)
+	assert.False(t, writable)
+	assert.ErrorIs(t, err, ErrNotDirectory)
+
+	writable, err = IsWritable(filepath.Join(dir, "missing"))
+	assert.False(t, writable)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// root can write anywhere, so this only means something for anyone else
+	if os.Geteuid() != 0 {
+		readonly := filepath.Join(dir, "readonly")
+		assert.Nil(t, os.Mkdir(readonly, 0555))
+		writable, err = IsWritable(readonly)
+		assert.False(t, writable)
+		assert.ErrorIs(t, err, ErrNoPermission)
+	}
+}
@@ -5,32 +5,34 @@ import (
 	"os"
 )
 
-func IsWritable(path string, debug bool) (isWritable bool, err error) {
-	isWritable = false
+// IsWritable reports whether files can be created in the directory at path. If
+// they can't, the error says why.
+func IsWritable(path string) (bool, error) {
+
 	info, err := os.Stat(path)
 	if err != nil {
-		if debug {
-			fmt.Println("Path doesn't exist")
-		}
-		return
+		return false, fmt.Errorf("unable to stat %s: %w", path, err)
 	}
-
-	err = nil
 	if !info.IsDir() {
-		if debug {
-			fmt.Println("Path isn't a directory")
-		}
-		return
+		return false, fmt.Errorf("%s: %w", path, ErrNotDirectory)
 	}
 
-	// Check if the user bit is enabled in file permission
-	if info.Mode().Perm()&(1<<(uint(7))) == 0 {
-		if debug {
-			fmt.Println("Write permission bit is not set on this file for user")
+	// on Windows, ACLs decide who can write where, and the permission bits
+	// don't know about them - so try it and see
+	if err := probe(path); err != nil {
+		if os.IsPermission(err) {
+			return false, fmt.Errorf("%s: %w: %v", path, ErrNoPermission, err)
 		}
-		return
+		return false, fmt.Errorf("unable to create a file in %s: %w", path, err)
 	}
 
-	isWritable = true
-	return
+	return true, nil
+
+}
+
+// Umask returns the process's file mode creation mask. Windows doesn't have
+// one - permissions there are mostly just the read-only attribute - so it's
+// always 0.
+func Umask() os.FileMode {
+	return 0
 }